@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"database/sql"
+	"errors"
+
+	"gochat/logging"
+	"gochat/models"
+)
+
+// maxAttachmentsPerMessage caps how many uploads one message may carry
+const maxAttachmentsPerMessage = 10
+
+// checkAttachments loads the uploads a user wants to send with a message.
+// Each must be the user's own and not already sent.
+func (h *ChatHub) checkAttachments(userID int64, ids []int64) ([]*models.Attachment, error) {
+	if len(ids) > maxAttachmentsPerMessage {
+		return nil, newProtocolError(ErrCodeInvalidPayload, "at most %d attachments per message", maxAttachmentsPerMessage)
+	}
+
+	attachments := make([]*models.Attachment, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "attachment %d is listed twice", id)
+		}
+		seen[id] = true
+
+		attachment, err := h.attachmentRepo.GetAttachmentByID(id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, newProtocolError(ErrCodeNotFound, "attachment %d not found", id)
+			}
+			logging.Errorf("Error getting attachment %d: %v", id, err)
+			return nil, err
+		}
+
+		// Other users' uploads are reported as missing
+		if attachment.UserID != userID {
+			return nil, newProtocolError(ErrCodeNotFound, "attachment %d not found", id)
+		}
+		if attachment.MessageID != 0 {
+			return nil, newProtocolError(ErrCodeInvalidPayload, "attachment %d has already been sent", id)
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,235 @@
+package chat
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/websocket/v2"
+
+	"gochat/logging"
+)
+
+const (
+	// writeWait is the time allowed to write a frame to the peer
+	writeWait = 10 * time.Second
+
+	// pongWait is the time allowed to read the next pong from the peer
+	pongWait = 60 * time.Second
+
+	// pingPeriod is how often pings are sent; it must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+
+	// sendBufferSize is the number of outbound frames queued per client
+	// before it is considered too slow and disconnected
+	sendBufferSize = 256
+
+	// maxFrameSize is the largest inbound frame in bytes; it leaves room for
+	// the envelope around content of the maximum length. Larger frames
+	// close the connection.
+	maxFrameSize = 64 << 10
+)
+
+// Client is a single WebSocket connection registered with the hub. All
+// writes to the connection go through its send queue and are performed by
+// its write pump, so there is never more than one writer per connection.
+type Client struct {
+	hub  *ChatHub
+	conn *websocket.Conn
+
+	UserID    int64
+	SessionID string
+
+	// Buffered channel of outbound frames
+	send chan []byte
+
+	// closed is closed once the client starts shutting down; closeCode and
+	// closeText are sent to the peer in the close frame
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+
+	// writerDone is closed when the write pump has exited
+	writerDone chan struct{}
+
+	// lastActive is the UnixNano time of the last inbound frame; idle is set
+	// while the hub shows the user as away
+	lastActive atomic.Int64
+	idle       atomic.Bool
+}
+
+// newClient creates a client for a connection
+func newClient(hub *ChatHub, conn *websocket.Conn, userID int64, sessionID string) *Client {
+	client := &Client{
+		hub:        hub,
+		conn:       conn,
+		UserID:     userID,
+		SessionID:  sessionID,
+		send:       make(chan []byte, sendBufferSize),
+		closed:     make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	client.lastActive.Store(time.Now().UnixNano())
+
+	return client
+}
+
+// lastActiveAt returns when the client last sent a frame
+func (c *Client) lastActiveAt() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+// touch records inbound activity, waking the user up if they were away
+func (c *Client) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+
+	if c.idle.Load() {
+		select {
+		case c.hub.activity <- c.UserID:
+		default:
+			// The hub is busy; the next frame will try again
+		}
+	}
+}
+
+// enqueue queues a frame for the write pump without blocking. A client
+// whose buffer is full is disconnected rather than allowed to slow down
+// the hub; enqueue then reports false.
+func (c *Client) enqueue(data []byte) bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		return true
+	default:
+		logging.Warnf("Send buffer full for user %d, disconnecting", c.UserID)
+		c.close(websocket.CloseTryAgainLater, "send buffer overflow")
+		return false
+	}
+}
+
+// sendFrame marshals a frame and queues it for this client
+func (c *Client) sendFrame(frame interface{}) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		logging.Errorf("Error marshaling frame: %v", err)
+		return
+	}
+
+	c.enqueue(data)
+}
+
+// close asks the write pump to flush the queue, send a close frame with the
+// given code and close the connection. Only the first call has an effect.
+func (c *Client) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.closed)
+	})
+}
+
+// writePump writes queued frames and keepalive pings to the connection
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		close(c.writerDone)
+	}()
+
+	for {
+		select {
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				logging.Errorf("Error sending message to client: %v", err)
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				logging.Errorf("Error sending ping to client: %v", err)
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-c.closed:
+			c.flush()
+			return
+		}
+	}
+}
+
+// flush writes the frames still queued and the close frame, all within one
+// write deadline
+func (c *Client) flush() {
+	if c.closeCode == websocket.CloseAbnormalClosure {
+		// The connection is already broken; there is no one to flush to
+		return
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	for {
+		select {
+		case data := <-c.send:
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		default:
+			closeMessage := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+			c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
+			return
+		}
+	}
+}
+
+// readPump reads frames from the connection and dispatches them until the
+// connection fails or is closed
+func (c *Client) readPump() {
+	c.conn.SetReadLimit(maxFrameSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			logging.Errorf("Error reading message: %v", err)
+			return
+		}
+
+		// Frames over the user's rate limit are rejected unprocessed
+		if !c.allowFrame(data) {
+			continue
+		}
+		c.touch()
+
+		// Only JSON text frames are part of the protocol
+		if messageType != websocket.TextMessage {
+			c.sendFrame(newErrorFrame("", newProtocolError(ErrCodeBadFrame, "only text frames are supported")))
+			continue
+		}
+
+		// Decoding would silently replace invalid UTF-8, so reject it here
+		if !utf8.Valid(data) {
+			c.sendFrame(newErrorFrame("", newProtocolError(ErrCodeBadFrame, "text frames must be valid UTF-8")))
+			continue
+		}
+
+		reply := c.hub.dispatcher.Dispatch(&FrameContext{Client: c, UserID: c.UserID}, data)
+		if reply != nil {
+			c.sendFrame(reply)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package chat
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gochat/logging"
+	"gochat/validation"
+)
+
+// registerFrameHandlers wires the inbound frame types to their handlers
+func (h *ChatHub) registerFrameHandlers() {
+	h.dispatcher.Handle("message", h.handleMessage)
+	h.dispatcher.Handle("direct_message", h.handleDirectMessage)
+	h.dispatcher.Handle("typing_start", h.handleTyping(true))
+	h.dispatcher.Handle("typing_stop", h.handleTyping(false))
+	h.dispatcher.Handle("mark_read", h.handleMarkRead)
+	h.dispatcher.Handle("edit_message", h.handleEditMessage)
+	h.dispatcher.Handle("delete_message", h.handleDeleteMessage)
+	h.dispatcher.Handle("add_reaction", h.handleReaction(true))
+	h.dispatcher.Handle("remove_reaction", h.handleReaction(false))
+}
+
+// MessagePayload is the payload of a "message" frame
+type MessagePayload struct {
+	Content  string `json:"content"`
+	RoomID   int64  `json:"room_id,omitempty"`   // Zero posts to the global channel
+	ParentID int64  `json:"parent_id,omitempty"` // Set to reply in a thread
+
+	// Uploads to send with the message; content may then be empty
+	AttachmentIDs []int64 `json:"attachment_ids,omitempty"`
+}
+
+// DirectMessagePayload is the payload of a "direct_message" frame
+type DirectMessagePayload struct {
+	RecipientID int64  `json:"recipient_id"`
+	Content     string `json:"content"`
+	ParentID    int64  `json:"parent_id,omitempty"` // Set to reply in a thread
+
+	// Uploads to send with the message; content may then be empty
+	AttachmentIDs []int64 `json:"attachment_ids,omitempty"`
+}
+
+// handleMessage persists and broadcasts a message to the global channel or a room
+func (h *ChatHub) handleMessage(ctx *FrameContext, payload json.RawMessage) (int64, error) {
+	var req MessagePayload
+	if err := decodePayload(payload, &req); err != nil {
+		return 0, err
+	}
+
+	// Content may only be left out when sending attachments
+	if err := validation.Content("content", req.Content, len(req.AttachmentIDs) == 0); err != nil {
+		return 0, newFieldError(err)
+	}
+
+	// Only members may post to a room
+	if err := h.checkConversation(ctx.UserID, req.RoomID, 0); err != nil {
+		return 0, err
+	}
+	if err := h.checkParent(ctx.UserID, req.RoomID, 0, req.ParentID); err != nil {
+		return 0, err
+	}
+	attachments, err := h.checkAttachments(ctx.UserID, req.AttachmentIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return h.sendChatMessage(ctx, &ChatMessage{
+		Type:        "message",
+		RoomID:      req.RoomID,
+		ParentID:    req.ParentID,
+		Content:     req.Content,
+		Attachments: attachments,
+	})
+}
+
+// handleDirectMessage persists and delivers a private message to one user
+func (h *ChatHub) handleDirectMessage(ctx *FrameContext, payload json.RawMessage) (int64, error) {
+	var req DirectMessagePayload
+	if err := decodePayload(payload, &req); err != nil {
+		return 0, err
+	}
+
+	// Direct messages need an existing recipient other than the sender
+	if req.RecipientID == 0 {
+		return 0, newProtocolError(ErrCodeInvalidPayload, "recipient_id is required")
+	}
+	if err := validation.Content("content", req.Content, len(req.AttachmentIDs) == 0); err != nil {
+		return 0, newFieldError(err)
+	}
+	if err := h.checkConversation(ctx.UserID, 0, req.RecipientID); err != nil {
+		return 0, err
+	}
+	if err := h.checkParent(ctx.UserID, 0, req.RecipientID, req.ParentID); err != nil {
+		return 0, err
+	}
+	attachments, err := h.checkAttachments(ctx.UserID, req.AttachmentIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return h.sendChatMessage(ctx, &ChatMessage{
+		Type:        "direct_message",
+		RecipientID: req.RecipientID,
+		ParentID:    req.ParentID,
+		Content:     req.Content,
+		Attachments: attachments,
+	})
+}
+
+// checkConversation verifies that a user may take part in a conversation:
+// the global channel (both IDs zero), a room they belong to, or a direct
+// conversation with another existing user
+func (h *ChatHub) checkConversation(userID, roomID, recipientID int64) error {
+	switch {
+	case roomID != 0 && recipientID != 0:
+		return newProtocolError(ErrCodeInvalidPayload, "room_id and recipient_id are mutually exclusive")
+
+	case roomID != 0:
+		isMember, err := h.roomRepo.IsMember(roomID, userID)
+		if err != nil {
+			logging.Errorf("Error checking membership of room %d: %v", roomID, err)
+			return err
+		}
+		if !isMember {
+			return newProtocolError(ErrCodeForbidden, "not a member of room %d", roomID)
+		}
+
+	case recipientID != 0:
+		if recipientID < 0 || recipientID == userID {
+			return newProtocolError(ErrCodeInvalidPayload, "recipient_id must be another user")
+		}
+		if _, err := h.userRepo.GetUserByID(recipientID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return newProtocolError(ErrCodeNotFound, "user %d not found", recipientID)
+			}
+			logging.Errorf("Error getting recipient %d: %v", recipientID, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkParent verifies that a reply's parent, if any, is a live top-level
+// message of the same conversation. Threads are one level deep.
+func (h *ChatHub) checkParent(userID, roomID, recipientID, parentID int64) error {
+	if parentID == 0 {
+		return nil
+	}
+
+	parent, err := h.getMessage(parentID)
+	if err != nil {
+		return err
+	}
+	if parent.DeletedAt != nil {
+		return newProtocolError(ErrCodeNotFound, "message %d has been deleted", parentID)
+	}
+	if !inConversation(parent, userID, roomID, recipientID) {
+		return newProtocolError(ErrCodeInvalidPayload, "message %d is not in this conversation", parentID)
+	}
+	if parent.ParentID != 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "cannot reply to a reply")
+	}
+	return nil
+}
+
+// sendChatMessage fills in the sender, persists the message and queues it for
+// fan-out, returning the stored message ID
+func (h *ChatHub) sendChatMessage(ctx *FrameContext, message *ChatMessage) (int64, error) {
+	// Get user information
+	user, err := h.userRepo.GetUserByID(ctx.UserID)
+	if err != nil {
+		logging.Errorf("Error getting user %d: %v", ctx.UserID, err)
+		return 0, err
+	}
+
+	message.UserID = user.ID
+	message.Username = user.Username
+	message.Timestamp = time.Now()
+	message.origin = ctx.Client
+
+	// Persist before fan-out so clients can reference the message by ID
+	if err := h.storeMessage(message); err != nil {
+		logging.Errorf("Error storing message: %v", err)
+		return 0, err
+	}
+
+	// The message is stored even if the hub stops before it can be delivered
+	select {
+	case h.broadcast <- message:
+	case <-h.done:
+	}
+
+	// Let clients refresh the reply summary shown on the parent
+	if message.ParentID != 0 {
+		h.announceThread(message.ParentID)
+	}
+
+	return message.ID, nil
+}
+
+// announceThread sends the current reply summary of a parent message to its
+// conversation as a "thread_updated" event
+func (h *ChatHub) announceThread(parentID int64) {
+	parent, err := h.messageRepo.GetMessageByID(parentID)
+	if err != nil {
+		logging.Errorf("Error getting message %d: %v", parentID, err)
+		return
+	}
+
+	h.announceChange("thread_updated", parent)
+}
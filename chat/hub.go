@@ -2,113 +2,250 @@ package chat
 
 import (
 	"encoding/json"
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/websocket/v2"
+
+	"gochat/logging"
+	"gochat/models"
+	"gochat/ratelimit"
 )
 
 // ChatHub manages WebSocket connections and message broadcasting
 type ChatHub struct {
 	// Registered clients
-	clients map[*websocket.Conn]int64 // Connection -> UserID
+	clients map[*Client]bool
 
 	// Mutex for thread-safe operations on the clients map
 	clientsMu sync.RWMutex
 
+	// Connections and status of each online user, keyed by user ID; only
+	// used by the main loop
+	presence map[int64]*userPresence
+
+	// Active typing indicators; only used by the main loop
+	typists map[typingKey]*typingState
+
 	// Channels for communication between goroutines
 	broadcast  chan *ChatMessage
-	register   chan *ClientRegistration
-	unregister chan *websocket.Conn
-
-	// User repository for database operations
-	userRepo UserRepository
+	register   chan *Client
+	unregister chan *Client
+	activity   chan int64 // IDs of away users who sent a frame
+	typing     chan *typingEvent
+	renames    chan *userRename
+
+	// Routes inbound frames to their handlers
+	dispatcher *Dispatcher
+
+	// Shutdown coordination: closing is set once Shutdown starts, quit asks
+	// the main loop to stop and done is closed when it has stopped
+	closing atomic.Bool
+	quit    chan struct{}
+	done    chan struct{}
+
+	// Repositories for database operations
+	userRepo       UserRepository
+	messageRepo    MessageRepository
+	roomRepo       RoomRepository
+	readRepo       ReadMarkerRepository
+	reactionRepo   ReactionRepository
+	attachmentRepo AttachmentRepository
+
+	// IDs of the users allowed to edit and delete any message; set before Run
+	moderators map[int64]bool
+
+	// Inbound frame limits per user, or nil when frames are not limited;
+	// set before Run
+	frameLimiter  *ratelimit.Limiter
+	strikeLimiter *ratelimit.Limiter
 }
 
 // ChatMessage represents a message sent in the chat
 type ChatMessage struct {
-	Type      string    `json:"type"` // "message", "user_joined", "user_left"
-	UserID    int64     `json:"user_id"`
-	Username  string    `json:"username"`
-	Content   string    `json:"content,omitempty"` // Optional for system messages
-	Timestamp time.Time `json:"timestamp"`
+	ID          int64                  `json:"id,omitempty"` // Set once a message has been persisted
+	Type        string                 `json:"type"`         // "message", "direct_message", "user_joined", "user_left", "user_status", "user_updated", "typing_start", "typing_stop", "read_receipt", "message_edited", "message_deleted", "thread_updated", "reaction_updated"
+	UserID      int64                  `json:"user_id"`
+	Username    string                 `json:"username"`
+	RoomID      int64                  `json:"room_id,omitempty"`      // Zero for the global channel
+	RecipientID int64                  `json:"recipient_id,omitempty"` // Set for direct messages
+	ParentID    int64                  `json:"parent_id,omitempty"`    // Set for thread replies
+	Content     string                 `json:"content,omitempty"`      // Optional for system messages
+	Status      string                 `json:"status,omitempty"`       // Set for "user_status"
+	MessageID   int64                  `json:"message_id,omitempty"`   // Last read message of a "read_receipt"
+	Timestamp   time.Time              `json:"timestamp"`
+	EditedAt    *time.Time             `json:"edited_at,omitempty"`
+	DeletedAt   *time.Time             `json:"deleted_at,omitempty"`
+	ReplyCount  int                    `json:"reply_count,omitempty"` // Summary of the replies to a parent message
+	LastReplyAt *time.Time             `json:"last_reply_at,omitempty"`
+	Reactions   []models.ReactionCount `json:"reactions,omitempty"` // Set in history and "reaction_updated"
+	Attachments []*models.Attachment   `json:"attachments,omitempty"`
+
+	// origin is the client the message was sent from, if any
+	origin *Client
+
+	// skipSender keeps the message from every connection of its sender
+	skipSender bool
 }
 
-// ClientRegistration contains data for registering a new client
-type ClientRegistration struct {
-	Conn   *websocket.Conn
-	UserID int64
+// NewChatMessage converts a stored message into the frame sent to clients
+func NewChatMessage(message *models.Message) *ChatMessage {
+	messageType := "message"
+	if message.RecipientID != 0 {
+		messageType = "direct_message"
+	}
+
+	return &ChatMessage{
+		ID:          message.ID,
+		Type:        messageType,
+		UserID:      message.UserID,
+		Username:    message.Username,
+		RoomID:      message.RoomID,
+		RecipientID: message.RecipientID,
+		ParentID:    message.ParentID,
+		Content:     message.Content,
+		Timestamp:   message.CreatedAt,
+		EditedAt:    message.EditedAt,
+		DeletedAt:   message.DeletedAt,
+		ReplyCount:  message.ReplyCount,
+		LastReplyAt: message.LastReplyAt,
+		Reactions:   message.Reactions,
+		Attachments: message.Attachments,
+	}
 }
 
 // NewChatHub creates a new chat hub
-func NewChatHub(userRepo UserRepository) *ChatHub {
-	return &ChatHub{
-		clients:    make(map[*websocket.Conn]int64),
-		broadcast:  make(chan *ChatMessage, 256), // Buffered channel
-		register:   make(chan *ClientRegistration, 10),
-		unregister: make(chan *websocket.Conn, 10),
-		userRepo:   userRepo,
+func NewChatHub(userRepo UserRepository, messageRepo MessageRepository, roomRepo RoomRepository, readRepo ReadMarkerRepository, reactionRepo ReactionRepository, attachmentRepo AttachmentRepository) *ChatHub {
+	h := &ChatHub{
+		clients:        make(map[*Client]bool),
+		broadcast:      make(chan *ChatMessage, 256), // Buffered channel
+		register:       make(chan *Client, 10),
+		unregister:     make(chan *Client, 10),
+		activity:       make(chan int64, 10),
+		typing:         make(chan *typingEvent, 64),
+		renames:        make(chan *userRename, 10),
+		typists:        make(map[typingKey]*typingState),
+		presence:       make(map[int64]*userPresence),
+		moderators:     make(map[int64]bool),
+		userRepo:       userRepo,
+		messageRepo:    messageRepo,
+		roomRepo:       roomRepo,
+		readRepo:       readRepo,
+		reactionRepo:   reactionRepo,
+		attachmentRepo: attachmentRepo,
+		dispatcher:     NewDispatcher(),
+		quit:           make(chan struct{}),
+		done:           make(chan struct{}),
 	}
+
+	h.registerFrameHandlers()
+	return h
 }
 
-// Run starts the ChatHub's main loop in a goroutine
+// Run starts the ChatHub's main loop in a goroutine; it runs until Shutdown
 func (h *ChatHub) Run() {
+	// No client is connected yet, whatever statuses a crash left behind
+	if err := h.userRepo.MarkAllOffline(); err != nil {
+		logging.Errorf("Error marking users offline: %v", err)
+	}
+
 	// This is the main goroutine that handles all operations on the shared state
 	go func() {
+		defer close(h.done)
+
+		ticker := time.NewTicker(presenceCheckInterval)
+		defer ticker.Stop()
+
+		typingTicker := time.NewTicker(typingSweepInterval)
+		defer typingTicker.Stop()
+
 		for {
 			select {
-			case registration := <-h.register:
-				h.registerClient(registration)
+			case client := <-h.register:
+				h.registerClient(client)
 
-			case conn := <-h.unregister:
-				h.unregisterClient(conn)
+			case client := <-h.unregister:
+				h.unregisterClient(client)
 
 			case message := <-h.broadcast:
 				h.broadcastMessage(message)
+
+			case userID := <-h.activity:
+				h.markActive(userID)
+
+			case <-ticker.C:
+				h.checkIdleUsers()
+
+			case event := <-h.typing:
+				h.applyTyping(event)
+
+			case <-typingTicker.C:
+				h.expireTyping(time.Now())
+
+			case rename := <-h.renames:
+				h.applyRename(rename)
+
+			case <-h.quit:
+				h.shutdownClients()
+				return
 			}
 		}
 	}()
 }
 
-// registerClient adds a new client to the hub
-func (h *ChatHub) registerClient(registration *ClientRegistration) {
+// registerClient adds a new client to the hub. Presence is reference
+// counted, so only a user's first connection marks them online and
+// announces them.
+func (h *ChatHub) registerClient(client *Client) {
 	// Get user information from database
-	user, err := h.userRepo.GetUserByID(registration.UserID)
+	user, err := h.userRepo.GetUserByID(client.UserID)
 	if err != nil {
-		log.Printf("Error getting user %d: %v", registration.UserID, err)
+		logging.Errorf("Error getting user %d: %v", client.UserID, err)
+		client.close(websocket.CloseInternalServerErr, "")
 		return
 	}
 
-	// Update user status to online
-	if err := h.userRepo.UpdateUserStatus(user.ID, "online"); err != nil {
-		log.Printf("Error updating user status: %v", err)
-	}
-
 	// Register the connection
 	h.clientsMu.Lock()
-	h.clients[registration.Conn] = registration.UserID
+	h.clients[client] = true
 	h.clientsMu.Unlock()
 
-	// Broadcast user joined message
-	h.broadcast <- &ChatMessage{
-		Type:      "user_joined",
-		UserID:    user.ID,
-		Username:  user.Username,
-		Timestamp: time.Now(),
-		Content:   "joined the chat",
+	first := h.addPresence(client, user.Username)
+	if first {
+		// Update user status to online
+		if err := h.userRepo.UpdateUserStatus(user.ID, "online"); err != nil {
+			logging.Errorf("Error updating user status: %v", err)
+		}
+	} else {
+		// A new connection counts as activity for an away user
+		h.markActive(user.ID)
 	}
 
-	// Send current online users to the new client
-	h.sendOnlineUsers(registration.Conn)
+	// Send current online users and unread counts to the new client
+	h.sendOnlineUsers(client)
+	h.sendUnreadCounts(client)
+
+	// Announce the user's first connection. This runs on the main loop,
+	// the only reader of h.broadcast, so it must not queue there.
+	if first {
+		h.broadcastMessage(&ChatMessage{
+			Type:      "user_joined",
+			UserID:    user.ID,
+			Username:  user.Username,
+			Timestamp: time.Now(),
+			Content:   "joined the chat",
+		})
+	}
 }
 
-// unregisterClient removes a client from the hub
-func (h *ChatHub) unregisterClient(conn *websocket.Conn) {
+// unregisterClient removes a client from the hub; the user only goes
+// offline when their last connection closes
+func (h *ChatHub) unregisterClient(client *Client) {
 	h.clientsMu.Lock()
-	userID, exists := h.clients[conn]
+	exists := h.clients[client]
 	if exists {
-		delete(h.clients, conn)
+		delete(h.clients, client)
 	}
 	h.clientsMu.Unlock()
 
@@ -116,93 +253,139 @@ func (h *ChatHub) unregisterClient(conn *websocket.Conn) {
 		return
 	}
 
-	// Get user information
-	user, err := h.userRepo.GetUserByID(userID)
-	if err != nil {
-		log.Printf("Error getting user %d: %v", userID, err)
+	username := h.presence[client.UserID].username
+	if !h.removePresence(client) {
 		return
 	}
 
+	// A user who is gone cannot be typing anymore
+	h.clearTyping(client.UserID)
+
 	// Update user status to offline
-	if err := h.userRepo.UpdateUserStatus(user.ID, "offline"); err != nil {
-		log.Printf("Error updating user status: %v", err)
+	if err := h.userRepo.UpdateUserStatus(client.UserID, "offline"); err != nil {
+		logging.Errorf("Error updating user status: %v", err)
 	}
 
-	// Broadcast user left message
-	h.broadcast <- &ChatMessage{
+	// Broadcast user left message directly, since this runs on the main loop
+	h.broadcastMessage(&ChatMessage{
 		Type:      "user_left",
-		UserID:    user.ID,
-		Username:  user.Username,
+		UserID:    client.UserID,
+		Username:  username,
 		Timestamp: time.Now(),
 		Content:   "left the chat",
-	}
+	})
 }
 
-// broadcastMessage sends a message to all connected clients
+// broadcastMessage sends a message to all connected clients, or only to the
+// members of its room when the message belongs to one. Direct messages go to
+// every connection of the recipient and to the sender's other connections.
 func (h *ChatHub) broadcastMessage(message *ChatMessage) {
 	// Marshal the message to JSON
 	jsonMessage, err := json.Marshal(message)
 	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
+		logging.Errorf("Error marshaling message: %v", err)
 		return
 	}
 
-	// Make a copy of the clients map to avoid holding the lock while sending messages
-	h.clientsMu.RLock()
-	clients := make(map[*websocket.Conn]int64, len(h.clients))
-	for conn, userID := range h.clients {
-		clients[conn] = userID
+	// Look up the room members so only they receive room messages
+	var members map[int64]bool
+	if message.RoomID != 0 {
+		memberIDs, err := h.roomRepo.GetMemberIDs(message.RoomID)
+		if err != nil {
+			logging.Errorf("Error getting members of room %d: %v", message.RoomID, err)
+			return
+		}
+
+		members = make(map[int64]bool, len(memberIDs))
+		for _, id := range memberIDs {
+			members[id] = true
+		}
 	}
-	h.clientsMu.RUnlock()
-
-	// Send message to all clients
-	for conn := range clients {
-		// Use a goroutine to send each message concurrently
-		go func(c *websocket.Conn) {
-			if err := c.WriteMessage(websocket.TextMessage, jsonMessage); err != nil {
-				log.Printf("Error sending message to client: %v", err)
-				h.unregister <- c
+
+	// Queue the message for every matching client; the write pumps do the
+	// actual socket writes, so a slow client cannot hold up the others
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+
+	for client := range h.clients {
+		if members != nil && !members[client.UserID] {
+			continue
+		}
+		if message.skipSender && client.UserID == message.UserID {
+			continue
+		}
+		if message.RecipientID != 0 {
+			isRecipient := client.UserID == message.RecipientID
+			isSenderEcho := client.UserID == message.UserID && client != message.origin
+			if !isRecipient && !isSenderEcho {
+				continue
 			}
-		}(conn)
+		}
+		client.enqueue(jsonMessage)
 	}
 }
 
-// sendOnlineUsers sends a list of currently online users to a specific client
-func (h *ChatHub) sendOnlineUsers(conn *websocket.Conn) {
-	// Get all user IDs currently connected
-	h.clientsMu.RLock()
-	userIDs := make([]int64, 0, len(h.clients))
-	for _, userID := range h.clients {
-		// Avoid duplicates
-		found := false
-		for _, id := range userIDs {
-			if id == userID {
-				found = true
-				break
-			}
+// storeMessage persists a chat message and assigns the stored ID to it
+func (h *ChatHub) storeMessage(message *ChatMessage) error {
+	stored := &models.Message{
+		UserID:      message.UserID,
+		RoomID:      message.RoomID,
+		RecipientID: message.RecipientID,
+		ParentID:    message.ParentID,
+		Content:     message.Content,
+		CreatedAt:   message.Timestamp,
+	}
+
+	if err := h.messageRepo.CreateMessage(stored); err != nil {
+		return err
+	}
+
+	message.ID = stored.ID
+
+	// Link the uploads sent with the message
+	if len(message.Attachments) > 0 {
+		ids := make([]int64, len(message.Attachments))
+		for i, attachment := range message.Attachments {
+			ids[i] = attachment.ID
+			attachment.MessageID = message.ID
+		}
+
+		linked, err := h.attachmentRepo.AttachToMessage(ids, message.UserID, message.ID)
+		if err != nil {
+			return err
 		}
-		if !found {
-			userIDs = append(userIDs, userID)
+
+		// An upload sent with another message since it was checked stays
+		// with that one, so only announce the attachments linked here
+		if linked != len(ids) {
+			logging.Infof("Linked %d of %d attachments to message %d", linked, len(ids), message.ID)
+
+			attachments, err := h.attachmentRepo.GetAttachmentsForMessages([]int64{message.ID})
+			if err != nil {
+				return err
+			}
+			message.Attachments = attachments[message.ID]
 		}
 	}
-	h.clientsMu.RUnlock()
 
-	// Get user details from the database
+	return nil
+}
+
+// sendOnlineUsers sends a list of currently online users to a specific client
+func (h *ChatHub) sendOnlineUsers(client *Client) {
 	type OnlineUser struct {
 		ID       int64  `json:"id"`
 		Username string `json:"username"`
+		Status   string `json:"status"` // "online" or "away"
 	}
 
-	onlineUsers := make([]OnlineUser, 0, len(userIDs))
-	for _, userID := range userIDs {
-		user, err := h.userRepo.GetUserByID(userID)
-		if err != nil {
-			log.Printf("Error getting user %d: %v", userID, err)
-			continue
-		}
+	// Each online user appears once, however many connections they have
+	onlineUsers := make([]OnlineUser, 0, len(h.presence))
+	for userID, p := range h.presence {
 		onlineUsers = append(onlineUsers, OnlineUser{
-			ID:       user.ID,
-			Username: user.Username,
+			ID:       userID,
+			Username: p.username,
+			Status:   p.status,
 		})
 	}
 
@@ -217,66 +400,64 @@ func (h *ChatHub) sendOnlineUsers(conn *websocket.Conn) {
 		Timestamp:   time.Now(),
 	}
 
-	jsonMessage, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshaling online users message: %v", err)
-		return
-	}
+	client.sendFrame(message)
+}
 
-	if err := conn.WriteMessage(websocket.TextMessage, jsonMessage); err != nil {
-		log.Printf("Error sending online users to client: %v", err)
-		h.unregister <- conn
+// DisconnectSession closes every connection opened with the given login session
+func (h *ChatHub) DisconnectSession(sessionID string) {
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+
+	// Closing the connection ends its read loop, which unregisters it
+	for client := range h.clients {
+		if client.SessionID == sessionID {
+			client.close(websocket.ClosePolicyViolation, "session revoked")
+		}
 	}
 }
 
-// HandleWebSocket handles a WebSocket connection
-func (h *ChatHub) HandleWebSocket(c *websocket.Conn, userID int64) {
-	// Register the client
-	h.register <- &ClientRegistration{
-		Conn:   c,
-		UserID: userID,
-	}
+// HandleWebSocket handles a WebSocket connection until it is closed
+func (h *ChatHub) HandleWebSocket(c *websocket.Conn, userID int64, sessionID string) {
+	client := newClient(h, c, userID, sessionID)
 
-	// Unregister client when the function returns
-	defer func() {
-		h.unregister <- c
-		c.Close()
-	}()
+	// Start the single writer for this connection
+	go client.writePump()
 
-	// Handle incoming messages
-	for {
-		messageType, data, err := c.ReadMessage()
-		if err != nil {
-			log.Printf("Error reading message: %v", err)
-			break
-		}
+	// Register the client, unless the hub is shutting down
+	if h.closing.Load() {
+		h.refuseClient(client)
+		return
+	}
+	select {
+	case h.register <- client:
+	case <-h.quit:
+		h.refuseClient(client)
+		return
+	}
 
-		// Handle text messages
-		if messageType == websocket.TextMessage {
-			var message struct {
-				Content string `json:"content"`
-			}
+	// register is buffered, so the send can win the race against quit after
+	// the main loop has drained it for the last time. Wait for the loop to
+	// stop; a client it never saw is refused here instead.
+	if h.closing.Load() {
+		<-h.done
+		h.refuseClient(client)
+		return
+	}
 
-			if err := json.Unmarshal(data, &message); err != nil {
-				log.Printf("Error unmarshaling message: %v", err)
-				continue
-			}
+	client.readPump()
 
-			// Get user information
-			user, err := h.userRepo.GetUserByID(userID)
-			if err != nil {
-				log.Printf("Error getting user %d: %v", userID, err)
-				continue
-			}
+	// Unregister the client and wait for its writer to finish, since the
+	// connection must not be used once this handler returns
+	h.requestUnregister(client)
+	client.close(websocket.CloseNormalClosure, "")
+	<-client.writerDone
+}
 
-			// Broadcast the message
-			h.broadcast <- &ChatMessage{
-				Type:      "message",
-				UserID:    userID,
-				Username:  user.Username,
-				Content:   message.Content,
-				Timestamp: time.Now(),
-			}
-		}
+// requestUnregister asks the main loop to unregister a client. Once the
+// hub is shutting down every client is closed anyway, so it does not block.
+func (h *ChatHub) requestUnregister(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.quit:
 	}
 }
@@ -0,0 +1,838 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+
+	"gochat/database/memory"
+	"gochat/models"
+	"gochat/validation"
+)
+
+// frameTimeout bounds how long a test waits for an expected frame, and
+// quietPeriod how long it watches for a frame that must not arrive
+const (
+	frameTimeout = 2 * time.Second
+	quietPeriod  = 100 * time.Millisecond
+)
+
+// hubFixture is a running hub over an in-memory store holding alice, bob and
+// carol, a room with alice and bob, and a global message from bob
+type hubFixture struct {
+	hub               *ChatHub
+	store             *memory.Store
+	alice, bob, carol *models.User
+	room              *models.Room
+	bobMessage        *models.Message
+}
+
+// newHubFixture starts a hub with the given moderators that is shut down
+// when the test ends
+func newHubFixture(t *testing.T, moderators ...string) *hubFixture {
+	t.Helper()
+
+	store := memory.NewStore()
+	f := &hubFixture{store: store}
+
+	for _, user := range []**models.User{&f.alice, &f.bob, &f.carol} {
+		*user = &models.User{Status: "offline"}
+	}
+	f.alice.Username, f.bob.Username, f.carol.Username = "alice", "bob", "carol"
+	for _, user := range []*models.User{f.alice, f.bob, f.carol} {
+		user.Email = user.Username + "@example.com"
+		if err := store.CreateUser(user); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	f.room = &models.Room{Name: "team"}
+	if err := store.CreateRoom(f.room, f.alice.ID); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	store.AddMember(f.room.ID, f.bob.ID)
+
+	f.bobMessage = &models.Message{UserID: f.bob.ID, Content: "hello from bob"}
+	if err := store.CreateMessage(f.bobMessage); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+
+	f.hub = NewChatHub(store, store, store, store, store, store)
+	f.hub.SetModerators(moderators)
+	f.hub.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), frameTimeout)
+		defer cancel()
+		if err := f.hub.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	})
+
+	return f
+}
+
+// connect registers a client without a socket for user and waits for its
+// initial online users frame. Its frames are read straight from the send
+// queue; a stand-in write pump only reports when the client is closed.
+func (f *hubFixture) connect(t *testing.T, user *models.User) *Client {
+	t.Helper()
+
+	client := newClient(f.hub, nil, user.ID, fmt.Sprintf("session-%d", user.ID))
+	go func() {
+		<-client.closed
+		close(client.writerDone)
+	}()
+
+	f.hub.register <- client
+	waitFrame(t, client, "online_users")
+	return client
+}
+
+// send dispatches a raw frame from client and decodes the reply, if any
+func send(t *testing.T, client *Client, frame string) map[string]interface{} {
+	t.Helper()
+
+	reply := client.hub.dispatcher.Dispatch(&FrameContext{Client: client, UserID: client.UserID}, []byte(frame))
+	if reply == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		t.Fatalf("marshal reply: %v", err)
+	}
+	return decodeFrame(t, data)
+}
+
+// waitFrame returns the next frame of the given type queued for client,
+// skipping frames of other types
+func waitFrame(t *testing.T, client *Client, frameType string) map[string]interface{} {
+	t.Helper()
+
+	deadline := time.After(frameTimeout)
+	for {
+		select {
+		case data := <-client.send:
+			if frame := decodeFrame(t, data); frame["type"] == frameType {
+				return frame
+			}
+		case <-deadline:
+			t.Fatalf("user %d got no %q frame", client.UserID, frameType)
+			return nil
+		}
+	}
+}
+
+// expectNoFrame fails if a frame of the given type is queued for client
+// within the quiet period
+func expectNoFrame(t *testing.T, client *Client, frameType string) {
+	t.Helper()
+
+	deadline := time.After(quietPeriod)
+	for {
+		select {
+		case data := <-client.send:
+			if frame := decodeFrame(t, data); frame["type"] == frameType {
+				t.Fatalf("user %d got unexpected frame %v", client.UserID, frame)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// decodeFrame unmarshals a JSON frame
+func decodeFrame(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+
+	var frame map[string]interface{}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		t.Fatalf("decode frame %q: %v", data, err)
+	}
+	return frame
+}
+
+func TestChatHubReplies(t *testing.T) {
+	tests := []struct {
+		name     string
+		sender   string // "alice" or "carol"
+		frame    string // %d is replaced by the ID of bob's message
+		wantType string
+		wantCode string
+	}{
+		{"global message", "alice", `{"type":"message","request_id":"r1","payload":{"content":"hi"}}`, "ack", ""},
+		{"room message from member", "alice", `{"type":"message","request_id":"r1","payload":{"content":"hi","room_id":1}}`, "ack", ""},
+		{"room message from outsider", "carol", `{"type":"message","request_id":"r1","payload":{"content":"hi","room_id":1}}`, "error", ErrCodeForbidden},
+		{"direct message to self", "alice", `{"type":"direct_message","request_id":"r1","payload":{"recipient_id":1,"content":"hi"}}`, "error", ErrCodeInvalidPayload},
+		{"direct message to unknown user", "alice", `{"type":"direct_message","request_id":"r1","payload":{"recipient_id":99,"content":"hi"}}`, "error", ErrCodeNotFound},
+		{"reply", "carol", `{"type":"message","request_id":"r1","payload":{"content":"hi","parent_id":%d}}`, "ack", ""},
+		{"edit another user's message", "alice", `{"type":"edit_message","request_id":"r1","payload":{"message_id":%d,"content":"mine now"}}`, "error", ErrCodeForbidden},
+		{"reaction", "carol", `{"type":"add_reaction","request_id":"r1","payload":{"message_id":%d,"emoji":"👍"}}`, "ack", ""},
+		{"unknown type", "alice", `{"type":"shout","request_id":"r1","payload":{}}`, "error", ErrCodeUnknownType},
+		{"unsupported version", "alice", `{"v":2,"type":"message","request_id":"r1","payload":{"content":"hi"}}`, "error", ErrCodeUnsupportedVersion},
+		{"missing payload", "alice", `{"type":"message","request_id":"r1"}`, "error", ErrCodeInvalidPayload},
+		{"missing type", "alice", `{"request_id":"r1","payload":{"content":"hi"}}`, "error", ErrCodeBadFrame},
+		{"not an envelope", "alice", `not json`, "error", ErrCodeBadFrame},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newHubFixture(t)
+			sender := f.alice
+			if tt.sender == "carol" {
+				sender = f.carol
+			}
+			client := f.connect(t, sender)
+
+			frame := tt.frame
+			if strings.Contains(frame, "%d") {
+				frame = fmt.Sprintf(frame, f.bobMessage.ID)
+			}
+
+			reply := send(t, client, frame)
+			if reply == nil {
+				t.Fatal("no reply")
+			}
+			if reply["type"] != tt.wantType {
+				t.Fatalf("reply = %v, want type %q", reply, tt.wantType)
+			}
+			if tt.wantCode != "" && reply["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %q", reply["code"], tt.wantCode)
+			}
+			if tt.wantType == "ack" && reply["request_id"] != "r1" {
+				t.Errorf("ack request_id = %v, want r1", reply["request_id"])
+			}
+		})
+	}
+}
+
+func TestChatHubRejectsInvalidContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		frame    string // Sent by bob; %d is replaced by the ID of his message
+		wantCode string
+	}{
+		{"empty message", `{"type":"message","payload":{"content":"  "}}`, validation.CodeRequired},
+		{"message too long", `{"type":"message","payload":{"content":"` + strings.Repeat("a", validation.MaxContentLength+1) + `"}}`, validation.CodeTooLong},
+		{"control characters", `{"type":"direct_message","payload":{"recipient_id":1,"content":"\u001b[2J"}}`, validation.CodeInvalid},
+		{"edit to empty content", `{"type":"edit_message","payload":{"message_id":%d,"content":""}}`, validation.CodeRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newHubFixture(t)
+			client := f.connect(t, f.bob)
+
+			frame := tt.frame
+			if strings.Contains(frame, "%d") {
+				frame = fmt.Sprintf(frame, f.bobMessage.ID)
+			}
+
+			reply := send(t, client, frame)
+			if reply == nil || reply["code"] != ErrCodeInvalidPayload {
+				t.Fatalf("reply = %v, want an %q error", reply, ErrCodeInvalidPayload)
+			}
+			fields, _ := reply["fields"].([]interface{})
+			if len(fields) != 1 {
+				t.Fatalf("fields = %v, want the content field", reply["fields"])
+			}
+			if field := fields[0].(map[string]interface{}); field["field"] != "content" || field["code"] != tt.wantCode {
+				t.Errorf("field = %v, want content with code %q", field, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestChatHubModeratorsKeyedByID(t *testing.T) {
+	f := newHubFixture(t, "carol")
+	alice, carol := f.connect(t, f.alice), f.connect(t, f.carol)
+
+	// Carol gives up her name and alice takes it
+	f.carol.Username = "carol2"
+	f.alice.Username = "carol"
+	for _, user := range []*models.User{f.carol, f.alice} {
+		if err := f.store.UpdateUserProfile(user); err != nil {
+			t.Fatalf("UpdateUserProfile: %v", err)
+		}
+	}
+
+	edit := fmt.Sprintf(`{"type":"edit_message","request_id":"r1","payload":{"message_id":%d,"content":"moderated"}}`, f.bobMessage.ID)
+	if reply := send(t, alice, edit); reply == nil || reply["code"] != ErrCodeForbidden {
+		t.Errorf("alice under the moderator's name: reply = %v, want %q", reply, ErrCodeForbidden)
+	}
+	if reply := send(t, carol, edit); reply == nil || reply["type"] != "ack" {
+		t.Errorf("renamed moderator: reply = %v, want an ack", reply)
+	}
+}
+
+// Moderators may only change and audit messages they can see, so direct
+// messages between other users stay private
+func TestChatHubModeratorsLimitedToVisibleMessages(t *testing.T) {
+	f := newHubFixture(t, "carol")
+	carol := f.connect(t, f.carol)
+
+	tests := []struct {
+		name     string
+		message  *models.Message
+		wantCode string // Empty when the moderator may act
+	}{
+		{"global message", &models.Message{UserID: f.bob.ID, Content: "hi all"}, ""},
+		{"direct message of others", &models.Message{UserID: f.alice.ID, RecipientID: f.bob.ID, Content: "psst"}, ErrCodeNotFound},
+		{"room the moderator is not in", &models.Message{UserID: f.alice.ID, RoomID: f.room.ID, Content: "team only"}, ErrCodeNotFound},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := f.store.CreateMessage(tt.message); err != nil {
+				t.Fatalf("CreateMessage: %v", err)
+			}
+
+			_, err := f.hub.MessageRevisions(f.carol.ID, tt.message.ID)
+			var protocolErr *ProtocolError
+			switch {
+			case tt.wantCode == "" && err != nil:
+				t.Errorf("revisions: %v, want them listed", err)
+			case tt.wantCode != "" && (!errors.As(err, &protocolErr) || protocolErr.Code != tt.wantCode):
+				t.Errorf("revisions: error = %v, want %q", err, tt.wantCode)
+			}
+
+			edit := fmt.Sprintf(`{"type":"edit_message","request_id":"e%d","payload":{"message_id":%d,"content":"moderated"}}`, i, tt.message.ID)
+			reply := send(t, carol, edit)
+			if tt.wantCode == "" && (reply == nil || reply["type"] != "ack") {
+				t.Errorf("edit: reply = %v, want an ack", reply)
+			}
+			if tt.wantCode != "" && (reply == nil || reply["code"] != tt.wantCode) {
+				t.Errorf("edit: reply = %v, want %q", reply, tt.wantCode)
+			}
+
+			deletion := fmt.Sprintf(`{"type":"delete_message","request_id":"d%d","payload":{"message_id":%d}}`, i, tt.message.ID)
+			reply = send(t, carol, deletion)
+			if tt.wantCode == "" && (reply == nil || reply["type"] != "ack") {
+				t.Errorf("delete: reply = %v, want an ack", reply)
+			}
+			if tt.wantCode != "" && (reply == nil || reply["code"] != tt.wantCode) {
+				t.Errorf("delete: reply = %v, want %q", reply, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestChatHubDelivery(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     string // Sent by alice
+		frameType string
+		receivers []string
+		excluded  []string
+	}{
+		{
+			name:      "global message",
+			frame:     `{"type":"message","payload":{"content":"hello everyone"}}`,
+			frameType: "message",
+			receivers: []string{"alice", "bob", "carol"},
+		},
+		{
+			name:      "room message",
+			frame:     `{"type":"message","payload":{"content":"hello team","room_id":1}}`,
+			frameType: "message",
+			receivers: []string{"alice", "bob"},
+			excluded:  []string{"carol"},
+		},
+		{
+			name:      "direct message",
+			frame:     `{"type":"direct_message","payload":{"recipient_id":2,"content":"hello bob"}}`,
+			frameType: "direct_message",
+			receivers: []string{"bob"},
+			excluded:  []string{"alice", "carol"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newHubFixture(t)
+			clients := map[string]*Client{
+				"alice": f.connect(t, f.alice),
+				"bob":   f.connect(t, f.bob),
+				"carol": f.connect(t, f.carol),
+			}
+
+			if reply := send(t, clients["alice"], tt.frame); reply != nil {
+				t.Fatalf("reply = %v", reply)
+			}
+
+			var content string
+			for _, name := range tt.receivers {
+				frame := waitFrame(t, clients[name], tt.frameType)
+				if frame["username"] != "alice" {
+					t.Errorf("%s got %v", name, frame)
+				}
+				if content == "" {
+					content, _ = frame["content"].(string)
+				} else if frame["content"] != content {
+					t.Errorf("%s got content %v, want %q", name, frame["content"], content)
+				}
+			}
+			for _, name := range tt.excluded {
+				expectNoFrame(t, clients[name], tt.frameType)
+			}
+
+			// Delivered messages are stored
+			messages, _ := f.store.ListMessages(0, 0, 0, 10)
+			direct, _ := f.store.ListDirectMessages(f.alice.ID, f.bob.ID, 0, 0, 10)
+			inRoom, _ := f.store.ListMessages(f.room.ID, 0, 0, 10)
+			if total := len(messages) + len(direct) + len(inRoom); total != 2 {
+				t.Errorf("stored %d messages, want bob's and alice's", total)
+			}
+		})
+	}
+}
+
+func TestChatHubDeleteReply(t *testing.T) {
+	f := newHubFixture(t)
+	alice, carol := f.connect(t, f.alice), f.connect(t, f.carol)
+
+	reply := send(t, carol, fmt.Sprintf(`{"type":"message","request_id":"r1","payload":{"content":"hi","parent_id":%d}}`, f.bobMessage.ID))
+	if reply == nil || reply["type"] != "ack" {
+		t.Fatalf("reply = %v", reply)
+	}
+	if thread := waitFrame(t, alice, "thread_updated"); thread["reply_count"] != float64(1) {
+		t.Fatalf("thread_updated = %v, want one reply", thread)
+	}
+
+	// Deleting the only reply empties the thread summary
+	send(t, carol, fmt.Sprintf(`{"type":"delete_message","request_id":"r2","payload":{"message_id":%v}}`, reply["id"]))
+	if thread := waitFrame(t, alice, "thread_updated"); thread["id"] != float64(f.bobMessage.ID) || thread["reply_count"] != nil || thread["last_reply_at"] != nil {
+		t.Errorf("thread_updated = %v, want no replies", thread)
+	}
+	if parent, _ := f.store.GetMessageByID(f.bobMessage.ID); parent.ReplyCount != 0 {
+		t.Errorf("stored reply count = %d, want 0", parent.ReplyCount)
+	}
+}
+
+// Uploads sent with another message after they were checked are left out
+// of the broadcast message
+func TestChatHubStoreMessageAttachments(t *testing.T) {
+	f := newHubFixture(t)
+
+	var ids []int64
+	for _, name := range []string{"a.png", "b.png"} {
+		attachment := &models.Attachment{UserID: f.alice.ID, Filename: name}
+		if err := f.store.CreateAttachment(attachment); err != nil {
+			t.Fatalf("CreateAttachment: %v", err)
+		}
+		ids = append(ids, attachment.ID)
+	}
+	attachments, err := f.hub.checkAttachments(f.alice.ID, ids)
+	if err != nil {
+		t.Fatalf("checkAttachments: %v", err)
+	}
+
+	// A concurrent message takes the first upload
+	other := &models.Message{UserID: f.alice.ID, Content: "first"}
+	if err := f.store.CreateMessage(other); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	f.store.AttachToMessage(ids[:1], f.alice.ID, other.ID)
+
+	message := &ChatMessage{Type: "message", UserID: f.alice.ID, Timestamp: time.Now(), Attachments: attachments}
+	if err := f.hub.storeMessage(message); err != nil {
+		t.Fatalf("storeMessage: %v", err)
+	}
+	if len(message.Attachments) != 1 || message.Attachments[0].ID != ids[1] || message.Attachments[0].MessageID != message.ID {
+		t.Errorf("attachments = %+v, want only attachment %d", message.Attachments, ids[1])
+	}
+}
+
+func TestChatHubPresence(t *testing.T) {
+	f := newHubFixture(t)
+	alice := f.connect(t, f.alice)
+	if joined := waitFrame(t, alice, "user_joined"); joined["username"] != "alice" {
+		t.Fatalf("user_joined = %v, want alice's own", joined)
+	}
+
+	// A user's first connection announces them and marks them online
+	bob := f.connect(t, f.bob)
+	if joined := waitFrame(t, alice, "user_joined"); joined["username"] != "bob" {
+		t.Errorf("user_joined = %v", joined)
+	}
+	if user, _ := f.store.GetUserByID(f.bob.ID); user.Status != "online" {
+		t.Errorf("bob's status = %q, want online", user.Status)
+	}
+
+	// A second connection is not announced
+	second := f.connect(t, f.bob)
+	expectNoFrame(t, alice, "user_joined")
+
+	// Only the last connection to close takes the user offline
+	f.hub.unregister <- second
+	expectNoFrame(t, alice, "user_left")
+	f.hub.unregister <- bob
+	if left := waitFrame(t, alice, "user_left"); left["username"] != "bob" {
+		t.Errorf("user_left = %v", left)
+	}
+	if user, _ := f.store.GetUserByID(f.bob.ID); user.Status != "offline" {
+		t.Errorf("bob's status = %q, want offline", user.Status)
+	}
+}
+
+// Statuses left by an unclean exit are reset when the hub starts, and every
+// user is offline once it stops, connected or not
+func TestChatHubResetsStatuses(t *testing.T) {
+	store := memory.NewStore()
+	user := &models.User{Username: "alice", Email: "alice@example.com", Status: "online"}
+	if err := store.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	hub := NewChatHub(store, store, store, store, store, store)
+	hub.Run()
+	if got, _ := store.GetUserByID(user.ID); got.Status != "offline" {
+		t.Errorf("status after Run = %q, want offline", got.Status)
+	}
+
+	store.UpdateUserStatus(user.ID, "away")
+	ctx, cancel := context.WithTimeout(context.Background(), frameTimeout)
+	defer cancel()
+	if err := hub.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if got, _ := store.GetUserByID(user.ID); got.Status != "offline" {
+		t.Errorf("status after Shutdown = %q, want offline", got.Status)
+	}
+}
+
+func TestChatHubRenameUser(t *testing.T) {
+	f := newHubFixture(t)
+	alice, bob := f.connect(t, f.alice), f.connect(t, f.bob)
+
+	// Everyone learns the new name
+	f.hub.RenameUser(f.bob.ID, "robert")
+	for _, client := range []*Client{alice, bob} {
+		if updated := waitFrame(t, client, "user_updated"); updated["user_id"] != float64(f.bob.ID) || updated["username"] != "robert" {
+			t.Errorf("user_updated = %v", updated)
+		}
+	}
+
+	// Presence events use it from then on
+	f.hub.unregister <- bob
+	if left := waitFrame(t, alice, "user_left"); left["username"] != "robert" {
+		t.Errorf("user_left = %v, want robert", left)
+	}
+}
+
+// Presence events are raised on the main loop, which is the only reader of
+// the broadcast queue, so they must not wait for room in it
+func TestChatHubPresenceWithFullBroadcastQueue(t *testing.T) {
+	f := newHubFixture(t)
+
+	// A second hub whose loop is not running stands in for a busy one
+	hub := NewChatHub(f.store, f.store, f.store, f.store, f.store, f.store)
+	for len(hub.broadcast) < cap(hub.broadcast) {
+		hub.broadcast <- &ChatMessage{Type: "message", Content: "flood"}
+	}
+
+	client := newClient(hub, nil, f.alice.ID, "session")
+	done := make(chan struct{})
+	go func() {
+		hub.registerClient(client)
+		hub.unregisterClient(client)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(frameTimeout):
+		t.Fatal("registering with a full broadcast queue blocked the main loop")
+	}
+}
+
+func TestChatHubMarkRead(t *testing.T) {
+	f := newHubFixture(t)
+	alice, bob, carol := f.connect(t, f.alice), f.connect(t, f.bob), f.connect(t, f.carol)
+
+	direct := &models.Message{UserID: f.bob.ID, RecipientID: f.alice.ID, Content: "psst"}
+	if err := f.store.CreateMessage(direct); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		frame    string // Sent by alice
+		wantCode string
+	}{
+		{"missing message", `{"type":"mark_read","request_id":"r1","payload":{"recipient_id":2}}`, ErrCodeInvalidPayload},
+		{"unknown message", `{"type":"mark_read","request_id":"r1","payload":{"recipient_id":2,"message_id":99}}`, ErrCodeNotFound},
+		{"message of another conversation", fmt.Sprintf(`{"type":"mark_read","request_id":"r1","payload":{"recipient_id":2,"message_id":%d}}`, f.bobMessage.ID), ErrCodeInvalidPayload},
+		{"room without membership", fmt.Sprintf(`{"type":"mark_read","request_id":"r1","payload":{"room_id":99,"message_id":%d}}`, f.bobMessage.ID), ErrCodeForbidden},
+	}
+	for _, tt := range tests {
+		if reply := send(t, alice, tt.frame); reply == nil || reply["code"] != tt.wantCode {
+			t.Errorf("%s: reply = %v, want an %q error", tt.name, reply, tt.wantCode)
+		}
+	}
+
+	// The ack carries the stored marker
+	frame := fmt.Sprintf(`{"type":"mark_read","request_id":"r1","payload":{"recipient_id":%d,"message_id":%d}}`, f.bob.ID, direct.ID)
+	if reply := send(t, alice, frame); reply == nil || reply["type"] != "ack" || reply["id"] != float64(direct.ID) {
+		t.Fatalf("reply = %v, want an ack with id %d", reply, direct.ID)
+	}
+
+	// Both participants get the receipt, nobody else does
+	for _, client := range []*Client{alice, bob} {
+		receipt := waitFrame(t, client, "read_receipt")
+		if receipt["user_id"] != float64(f.alice.ID) || receipt["recipient_id"] != float64(f.bob.ID) || receipt["message_id"] != float64(direct.ID) {
+			t.Errorf("user %d got read_receipt %v", client.UserID, receipt)
+		}
+	}
+	expectNoFrame(t, carol, "read_receipt")
+
+	if counts, _ := f.store.GetUnreadCounts(f.alice.ID); len(counts) != 1 || counts[0].PeerID != 0 {
+		t.Errorf("unread counts = %+v, want only the global channel", counts)
+	}
+}
+
+// An edited message is announced whole, as the history shows it
+func TestChatHubEditKeepsAttachmentsAndReactions(t *testing.T) {
+	f := newHubFixture(t)
+	alice, bob := f.connect(t, f.alice), f.connect(t, f.bob)
+
+	attachment := &models.Attachment{UserID: f.bob.ID, Filename: "plan.txt"}
+	if err := f.store.CreateAttachment(attachment); err != nil {
+		t.Fatalf("CreateAttachment: %v", err)
+	}
+	f.store.AttachToMessage([]int64{attachment.ID}, f.bob.ID, f.bobMessage.ID)
+	f.store.AddReaction(f.bobMessage.ID, f.alice.ID, "👍")
+
+	edit := fmt.Sprintf(`{"type":"edit_message","request_id":"r1","payload":{"message_id":%d,"content":"hello again"}}`, f.bobMessage.ID)
+	if reply := send(t, bob, edit); reply == nil || reply["type"] != "ack" {
+		t.Fatalf("reply = %v", reply)
+	}
+
+	edited := waitFrame(t, alice, "message_edited")
+	if edited["content"] != "hello again" {
+		t.Errorf("content = %v", edited["content"])
+	}
+	if attachments, _ := edited["attachments"].([]interface{}); len(attachments) != 1 || attachments[0].(map[string]interface{})["filename"] != "plan.txt" {
+		t.Errorf("attachments = %v, want plan.txt", edited["attachments"])
+	}
+	if reactions := fmt.Sprint(edited["reactions"]); reactions != "[map[count:1 emoji:👍]]" {
+		t.Errorf("reactions = %s, want one 👍", reactions)
+	}
+}
+
+func TestChatHubReactions(t *testing.T) {
+	f := newHubFixture(t)
+	alice, bob, carol := f.connect(t, f.alice), f.connect(t, f.bob), f.connect(t, f.carol)
+
+	message := &models.Message{UserID: f.bob.ID, RoomID: f.room.ID, Content: "lunch?"}
+	if err := f.store.CreateMessage(message); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	react := func(client *Client, frameType, emoji string) map[string]interface{} {
+		t.Helper()
+		return send(t, client, fmt.Sprintf(`{"type":%q,"request_id":"r1","payload":{"message_id":%d,"emoji":%q}}`, frameType, message.ID, emoji))
+	}
+
+	steps := []struct {
+		name       string
+		client     *Client
+		frameType  string
+		wantCounts string // Reactions of the broadcast "reaction_updated", if any
+	}{
+		{"alice adds", alice, "add_reaction", "[map[count:1 emoji:👍]]"},
+		{"alice adds again", alice, "add_reaction", ""},
+		{"bob adds", bob, "add_reaction", "[map[count:2 emoji:👍]]"},
+		{"alice removes", alice, "remove_reaction", "[map[count:1 emoji:👍]]"},
+		{"alice removes again", alice, "remove_reaction", ""},
+		{"bob removes the last", bob, "remove_reaction", "<nil>"},
+	}
+	for _, step := range steps {
+		if reply := react(step.client, step.frameType, "👍"); reply == nil || reply["type"] != "ack" || reply["id"] != float64(message.ID) {
+			t.Fatalf("%s: reply = %v, want an ack", step.name, reply)
+		}
+		if step.wantCounts == "" {
+			expectNoFrame(t, alice, "reaction_updated")
+			continue
+		}
+
+		// The room sees the new counts; outsiders do not
+		for _, client := range []*Client{alice, bob} {
+			updated := waitFrame(t, client, "reaction_updated")
+			if updated["id"] != float64(message.ID) || fmt.Sprint(updated["reactions"]) != step.wantCounts {
+				t.Errorf("%s: user %d got %v, want reactions %s", step.name, client.UserID, updated, step.wantCounts)
+			}
+		}
+		expectNoFrame(t, carol, "reaction_updated")
+	}
+
+	// Carol cannot see the room, so the message does not exist for her
+	if reply := react(carol, "add_reaction", "👍"); reply == nil || reply["code"] != ErrCodeNotFound {
+		t.Errorf("outsider: reply = %v, want %q", reply, ErrCodeNotFound)
+	}
+
+	for _, emoji := range []string{"", "thumbs up", "👍\t", strings.Repeat("👍", maxEmojiLength)} {
+		if reply := react(alice, "add_reaction", emoji); reply == nil || reply["code"] != ErrCodeInvalidPayload {
+			t.Errorf("emoji %q: reply = %v, want %q", emoji, reply, ErrCodeInvalidPayload)
+		}
+	}
+	if counts, _ := f.store.GetReactionCounts([]int64{message.ID}); len(counts) != 0 {
+		t.Errorf("stored reactions = %v, want none", counts)
+	}
+}
+
+func TestChatHubTypingRelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string // Of alice's "typing_start"
+		receivers []string
+		excluded  []string
+	}{
+		{"global channel", `{}`, []string{"bob", "carol"}, []string{"alice"}},
+		{"room", `{"room_id":1}`, []string{"bob"}, []string{"alice", "carol"}},
+		{"direct conversation", `{"recipient_id":3}`, []string{"carol"}, []string{"alice", "bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newHubFixture(t)
+			clients := map[string]*Client{
+				"alice": f.connect(t, f.alice),
+				"bob":   f.connect(t, f.bob),
+				"carol": f.connect(t, f.carol),
+			}
+
+			if reply := send(t, clients["alice"], `{"type":"typing_start","request_id":"r1","payload":`+tt.payload+`}`); reply == nil || reply["type"] != "ack" {
+				t.Fatalf("reply = %v", reply)
+			}
+			for _, name := range tt.receivers {
+				if frame := waitFrame(t, clients[name], "typing_start"); frame["username"] != "alice" {
+					t.Errorf("%s got %v", name, frame)
+				}
+			}
+			for _, name := range tt.excluded {
+				expectNoFrame(t, clients[name], "typing_start")
+			}
+		})
+	}
+}
+
+// Indicators that are not renewed expire; the sweep is driven by hand on a
+// hub whose loop is not running
+func TestChatHubTypingExpires(t *testing.T) {
+	f := newHubFixture(t)
+	hub := NewChatHub(f.store, f.store, f.store, f.store, f.store, f.store)
+	bob := newClient(hub, nil, f.bob.ID, "session")
+	hub.registerClient(bob)
+
+	started := time.Now()
+	hub.applyTyping(&typingEvent{key: typingKey{userID: f.alice.ID}, username: "alice", typing: true})
+	waitFrame(t, bob, "typing_start")
+
+	hub.expireTyping(started.Add(typingTimeout - time.Second))
+	expectNoFrame(t, bob, "typing_stop")
+
+	hub.expireTyping(started.Add(typingTimeout + time.Second))
+	if frame := waitFrame(t, bob, "typing_stop"); frame["username"] != "alice" {
+		t.Errorf("typing_stop = %v", frame)
+	}
+	if len(hub.typists) != 0 {
+		t.Errorf("typists = %v, want none", hub.typists)
+	}
+}
+
+// A user stops typing once their last connection closes
+func TestChatHubTypingStopsOnDisconnect(t *testing.T) {
+	f := newHubFixture(t)
+	alice, second, bob := f.connect(t, f.alice), f.connect(t, f.alice), f.connect(t, f.bob)
+
+	send(t, alice, `{"type":"typing_start","payload":{"room_id":1}}`)
+	waitFrame(t, bob, "typing_start")
+
+	f.hub.unregister <- alice
+	expectNoFrame(t, bob, "typing_stop")
+
+	f.hub.unregister <- second
+	if frame := waitFrame(t, bob, "typing_stop"); frame["username"] != "alice" || frame["room_id"] != float64(f.room.ID) {
+		t.Errorf("typing_stop = %v", frame)
+	}
+}
+
+// A client whose send queue is full is disconnected rather than allowed to
+// hold up the hub
+func TestChatHubDisconnectsSlowClient(t *testing.T) {
+	f := newHubFixture(t)
+	slow, bob := f.connect(t, f.alice), f.connect(t, f.bob)
+
+	// The slow client's writer has stalled; once closed, its read loop
+	// ends and unregisters it like a real connection's would
+	for len(slow.send) < cap(slow.send) {
+		slow.send <- []byte(`{"type":"stalled"}`)
+	}
+	go func() {
+		<-slow.closed
+		f.hub.requestUnregister(slow)
+	}()
+
+	send(t, bob, `{"type":"message","payload":{"content":"anyone there?"}}`)
+	select {
+	case <-slow.closed:
+	case <-time.After(frameTimeout):
+		t.Fatal("slow client was not closed")
+	}
+	if slow.closeCode != websocket.CloseTryAgainLater {
+		t.Errorf("close code = %d, want %d", slow.closeCode, websocket.CloseTryAgainLater)
+	}
+
+	// The hub keeps serving everyone else and lets the user go
+	if message := waitFrame(t, bob, "message"); message["content"] != "anyone there?" {
+		t.Errorf("message = %v", message)
+	}
+	if left := waitFrame(t, bob, "user_left"); left["username"] != "alice" {
+		t.Errorf("user_left = %v, want alice", left)
+	}
+	send(t, bob, `{"type":"message","payload":{"content":"guess not"}}`)
+	if message := waitFrame(t, bob, "message"); message["content"] != "guess not" {
+		t.Errorf("message = %v", message)
+	}
+}
+
+// Chat messages in the format used before the envelope are still delivered
+func TestChatHubLegacyFrames(t *testing.T) {
+	tests := []struct {
+		name      string
+		frame     string // Sent by alice
+		frameType string
+		receivers []string
+		excluded  []string
+	}{
+		{"without a type", `{"content":"hello everyone"}`, "message", []string{"bob", "carol"}, nil},
+		{"room message", `{"type":"message","room_id":1,"content":"hello team"}`, "message", []string{"bob"}, []string{"carol"}},
+		{"direct message", `{"type":"direct_message","recipient_id":2,"content":"hello bob"}`, "direct_message", []string{"bob"}, []string{"carol"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newHubFixture(t)
+			alice := f.connect(t, f.alice)
+			clients := map[string]*Client{"bob": f.connect(t, f.bob), "carol": f.connect(t, f.carol)}
+
+			if reply := send(t, alice, tt.frame); reply != nil {
+				t.Fatalf("reply = %v", reply)
+			}
+			for _, name := range tt.receivers {
+				if frame := waitFrame(t, clients[name], tt.frameType); frame["username"] != "alice" || frame["content"] == nil {
+					t.Errorf("%s got %v", name, frame)
+				}
+			}
+			for _, name := range tt.excluded {
+				expectNoFrame(t, clients[name], tt.frameType)
+			}
+		})
+	}
+}
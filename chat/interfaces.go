@@ -7,5 +7,42 @@ import (
 // UserRepository defines the interface for the user repository needed by the chat hub
 type UserRepository interface {
 	GetUserByID(id int64) (*models.User, error)
+	GetUserByUsername(username string) (*models.User, error)
 	UpdateUserStatus(id int64, status string) error
+	MarkAllOffline() error
+}
+
+// MessageRepository defines the interface for the message repository needed by the chat hub
+type MessageRepository interface {
+	CreateMessage(message *models.Message) error
+	GetMessageByID(id int64) (*models.Message, error)
+	EditMessage(id, editorID int64, content string) (*models.Message, error)
+	DeleteMessage(id, deletedBy int64) (*models.Message, error)
+	ListRevisions(messageID int64) ([]*models.MessageRevision, error)
+}
+
+// RoomRepository defines the interface for the room repository needed by the chat hub
+type RoomRepository interface {
+	IsMember(roomID, userID int64) (bool, error)
+	GetMemberIDs(roomID int64) ([]int64, error)
+}
+
+// ReactionRepository defines the interface for the reaction repository needed by the chat hub
+type ReactionRepository interface {
+	AddReaction(messageID, userID int64, emoji string) (bool, error)
+	RemoveReaction(messageID, userID int64, emoji string) (bool, error)
+	GetReactionCounts(messageIDs []int64) (map[int64][]models.ReactionCount, error)
+}
+
+// AttachmentRepository defines the interface for the attachment repository needed by the chat hub
+type AttachmentRepository interface {
+	GetAttachmentByID(id int64) (*models.Attachment, error)
+	AttachToMessage(ids []int64, userID, messageID int64) (int, error)
+	GetAttachmentsForMessages(messageIDs []int64) (map[int64][]*models.Attachment, error)
+}
+
+// ReadMarkerRepository defines the interface for the read marker repository needed by the chat hub
+type ReadMarkerRepository interface {
+	MarkRead(userID, roomID, peerID, messageID int64) (*models.ReadMarker, error)
+	GetUnreadCounts(userID int64) ([]*models.UnreadCount, error)
 }
@@ -0,0 +1,222 @@
+package chat
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gochat/logging"
+	"gochat/models"
+	"gochat/validation"
+)
+
+// EditMessagePayload is the payload of an "edit_message" frame
+type EditMessagePayload struct {
+	MessageID int64  `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+// DeleteMessagePayload is the payload of a "delete_message" frame
+type DeleteMessagePayload struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// SetModerators allows the users with the given usernames to edit and delete
+// any message they can see. The names are resolved to user IDs once, so renaming an
+// account later neither grants nor revokes the permission; names without an
+// account are logged and ignored. It must be called before Run.
+func (h *ChatHub) SetModerators(usernames []string) {
+	h.moderators = make(map[int64]bool, len(usernames))
+	for _, username := range usernames {
+		user, err := h.userRepo.GetUserByUsername(username)
+		if err != nil {
+			logging.Warnf("Ignoring moderator %q: %v", username, err)
+			continue
+		}
+		h.moderators[user.ID] = true
+	}
+}
+
+// handleEditMessage replaces the content of a message
+func (h *ChatHub) handleEditMessage(ctx *FrameContext, payload json.RawMessage) (int64, error) {
+	var req EditMessagePayload
+	if err := decodePayload(payload, &req); err != nil {
+		return 0, err
+	}
+
+	message, err := h.EditMessage(ctx.UserID, req.MessageID, req.Content)
+	if err != nil {
+		return 0, err
+	}
+	return message.ID, nil
+}
+
+// handleDeleteMessage deletes a message
+func (h *ChatHub) handleDeleteMessage(ctx *FrameContext, payload json.RawMessage) (int64, error) {
+	var req DeleteMessagePayload
+	if err := decodePayload(payload, &req); err != nil {
+		return 0, err
+	}
+
+	message, err := h.DeleteMessage(ctx.UserID, req.MessageID)
+	if err != nil {
+		return 0, err
+	}
+	return message.ID, nil
+}
+
+// EditMessage replaces the content of a message on behalf of its author or a
+// moderator and sends "message_edited" to everyone who can see the message.
+// Errors that the caller should report are *ProtocolError.
+func (h *ChatHub) EditMessage(userID, messageID int64, content string) (*models.Message, error) {
+	if err := validation.Content("content", content, true); err != nil {
+		return nil, newFieldError(err)
+	}
+	if _, err := h.authorizeChange(userID, messageID); err != nil {
+		return nil, err
+	}
+
+	message, err := h.messageRepo.EditMessage(messageID, userID, content)
+	if err != nil {
+		// A concurrent deletion can win after authorizeChange
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, newProtocolError(ErrCodeNotFound, "message %d has been deleted", messageID)
+		}
+		logging.Errorf("Error editing message %d: %v", messageID, err)
+		return nil, err
+	}
+
+	// Clients replace the message they show, so send it whole
+	h.loadAttachmentsAndReactions(message)
+	h.announceChange("message_edited", message)
+	return message, nil
+}
+
+// DeleteMessage deletes a message on behalf of its author or a moderator and
+// sends "message_deleted" to everyone who can see the message. Errors that
+// the caller should report are *ProtocolError.
+func (h *ChatHub) DeleteMessage(userID, messageID int64) (*models.Message, error) {
+	if _, err := h.authorizeChange(userID, messageID); err != nil {
+		return nil, err
+	}
+
+	message, err := h.messageRepo.DeleteMessage(messageID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, newProtocolError(ErrCodeNotFound, "message %d has been deleted", messageID)
+		}
+		logging.Errorf("Error deleting message %d: %v", messageID, err)
+		return nil, err
+	}
+
+	h.announceChange("message_deleted", message)
+
+	// Let clients refresh the reply summary shown on the parent
+	if message.ParentID != 0 {
+		h.announceThread(message.ParentID)
+	}
+	return message, nil
+}
+
+// MessageRevisions returns the audit trail of a message to its author or a
+// moderator who can see it. Errors that the caller should report are *ProtocolError.
+func (h *ChatHub) MessageRevisions(userID, messageID int64) ([]*models.MessageRevision, error) {
+	message, err := h.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkAuthorOrModerator(userID, message); err != nil {
+		return nil, err
+	}
+
+	revisions, err := h.messageRepo.ListRevisions(messageID)
+	if err != nil {
+		logging.Errorf("Error listing revisions of message %d: %v", messageID, err)
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// authorizeChange loads a message that userID wants to edit or delete and
+// checks that it still exists and that they may change it
+func (h *ChatHub) authorizeChange(userID, messageID int64) (*models.Message, error) {
+	message, err := h.getMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.DeletedAt != nil {
+		return nil, newProtocolError(ErrCodeNotFound, "message %d has been deleted", messageID)
+	}
+	if err := h.checkAuthorOrModerator(userID, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// getMessage loads a message, reporting a missing one as not_found
+func (h *ChatHub) getMessage(messageID int64) (*models.Message, error) {
+	if messageID <= 0 {
+		return nil, newProtocolError(ErrCodeInvalidPayload, "message_id is required")
+	}
+
+	message, err := h.messageRepo.GetMessageByID(messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, newProtocolError(ErrCodeNotFound, "message %d not found", messageID)
+		}
+		logging.Errorf("Error getting message %d: %v", messageID, err)
+		return nil, err
+	}
+	return message, nil
+}
+
+// checkAuthorOrModerator allows the author of a message and moderators who
+// can see it, which keeps moderators out of other people's direct messages
+// and rooms they have not joined. Hidden messages are reported as missing.
+func (h *ChatHub) checkAuthorOrModerator(userID int64, message *models.Message) error {
+	if message.UserID == userID {
+		return nil
+	}
+	if err := h.checkCanSee(userID, message); err != nil {
+		return err
+	}
+	if h.moderators[userID] {
+		return nil
+	}
+	return newProtocolError(ErrCodeForbidden, "only the author or a moderator may change message %d", message.ID)
+}
+
+// loadAttachmentsAndReactions fills in the attachments and reaction counts
+// of a message as the history shows them. Failures are only logged, leaving
+// the fields empty.
+func (h *ChatHub) loadAttachmentsAndReactions(message *models.Message) {
+	ids := []int64{message.ID}
+
+	reactions, err := h.reactionRepo.GetReactionCounts(ids)
+	if err != nil {
+		logging.Errorf("Error getting reactions of message %d: %v", message.ID, err)
+	} else {
+		message.Reactions = reactions[message.ID]
+	}
+
+	attachments, err := h.attachmentRepo.GetAttachmentsForMessages(ids)
+	if err != nil {
+		logging.Errorf("Error getting attachments of message %d: %v", message.ID, err)
+	} else {
+		message.Attachments = attachments[message.ID]
+	}
+}
+
+// announceChange queues an event about a stored message for its
+// conversation; it is routed like the original message
+func (h *ChatHub) announceChange(eventType string, message *models.Message) {
+	event := NewChatMessage(message)
+	event.Type = eventType
+	event.Timestamp = time.Now()
+
+	select {
+	case h.broadcast <- event:
+	case <-h.done:
+	}
+}
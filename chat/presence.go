@@ -0,0 +1,151 @@
+package chat
+
+import (
+	"time"
+
+	"gochat/logging"
+)
+
+const (
+	// idleTimeout is how long all of a user's connections must be silent
+	// before the user is shown as away
+	idleTimeout = 5 * time.Minute
+
+	// presenceCheckInterval is how often idle users are looked for
+	presenceCheckInterval = 30 * time.Second
+)
+
+// userPresence tracks every connection of one user. It is only touched by
+// the hub's main loop.
+type userPresence struct {
+	username string
+	clients  map[*Client]bool
+	status   string // "online" or "away"
+}
+
+// userRename is a username change sent to the main loop
+type userRename struct {
+	userID   int64
+	username string
+}
+
+// addPresence records a new connection and reports whether it is the
+// user's first one
+func (h *ChatHub) addPresence(client *Client, username string) bool {
+	p, ok := h.presence[client.UserID]
+	if !ok {
+		p = &userPresence{
+			username: username,
+			clients:  make(map[*Client]bool),
+			status:   "online",
+		}
+		h.presence[client.UserID] = p
+	}
+
+	p.clients[client] = true
+	return !ok
+}
+
+// removePresence forgets a connection and reports whether it was the
+// user's last one
+func (h *ChatHub) removePresence(client *Client) bool {
+	p, ok := h.presence[client.UserID]
+	if !ok {
+		return false
+	}
+
+	delete(p.clients, client)
+	if len(p.clients) > 0 {
+		return false
+	}
+
+	delete(h.presence, client.UserID)
+	return true
+}
+
+// checkIdleUsers marks users away once all their connections have been
+// idle for idleTimeout
+func (h *ChatHub) checkIdleUsers() {
+	now := time.Now()
+	for userID, p := range h.presence {
+		if p.status != "online" {
+			continue
+		}
+
+		// The user is active if any of their connections is
+		var lastActive time.Time
+		for client := range p.clients {
+			if active := client.lastActiveAt(); active.After(lastActive) {
+				lastActive = active
+			}
+		}
+		if now.Sub(lastActive) < idleTimeout {
+			continue
+		}
+
+		for client := range p.clients {
+			client.idle.Store(true)
+		}
+		h.setStatus(userID, p, "away")
+	}
+}
+
+// markActive brings an away user back online
+func (h *ChatHub) markActive(userID int64) {
+	p, ok := h.presence[userID]
+	if !ok || p.status != "away" {
+		return
+	}
+
+	for client := range p.clients {
+		client.idle.Store(false)
+	}
+	h.setStatus(userID, p, "online")
+}
+
+// setStatus stores and announces a presence status change
+func (h *ChatHub) setStatus(userID int64, p *userPresence, status string) {
+	p.status = status
+
+	if err := h.userRepo.UpdateUserStatus(userID, status); err != nil {
+		logging.Errorf("Error updating user status: %v", err)
+	}
+
+	h.broadcastMessage(&ChatMessage{
+		Type:      "user_status",
+		UserID:    userID,
+		Username:  p.username,
+		Status:    status,
+		Timestamp: time.Now(),
+	})
+}
+
+// RenameUser replaces the username the hub shows for a user and sends
+// "user_updated" to everyone so clients can relabel them. It may be called
+// from any goroutine.
+func (h *ChatHub) RenameUser(userID int64, username string) {
+	select {
+	case h.renames <- &userRename{userID: userID, username: username}:
+	case <-h.done:
+	}
+}
+
+// applyRename updates the presence and typing indicators of a renamed user
+// and announces the new name
+func (h *ChatHub) applyRename(rename *userRename) {
+	if p, ok := h.presence[rename.userID]; ok {
+		p.username = rename.username
+	}
+	for key, state := range h.typists {
+		if key.userID == rename.userID {
+			state.username = rename.username
+		}
+	}
+
+	h.broadcastMessage(&ChatMessage{
+		Type:      "user_updated",
+		UserID:    rename.userID,
+		Username:  rename.username,
+		Timestamp: time.Now(),
+	})
+}
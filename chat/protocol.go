@@ -0,0 +1,197 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gochat/logging"
+	"gochat/validation"
+)
+
+// ProtocolVersion is the version of the inbound frame envelope understood by the hub
+const ProtocolVersion = 1
+
+// Error codes sent in "error" frames
+const (
+	ErrCodeBadFrame           = "bad_frame"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeInvalidPayload     = "invalid_payload"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeInternal           = "internal_error"
+)
+
+// Envelope wraps every inbound WebSocket frame
+type Envelope struct {
+	Version   int             `json:"v,omitempty"` // Defaults to ProtocolVersion when omitted
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"` // Echoed back in "ack" and "error" frames
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// AckFrame confirms that a frame carrying a request ID was accepted
+type AckFrame struct {
+	Type      string    `json:"type"` // Always "ack"
+	RequestID string    `json:"request_id"`
+	ID        int64     `json:"id,omitempty"` // ID of the resource created by the frame, if any
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ErrorFrame reports why an inbound frame was rejected
+type ErrorFrame struct {
+	Type      string            `json:"type"` // Always "error"
+	RequestID string            `json:"request_id,omitempty"`
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Fields    validation.Errors `json:"fields,omitempty"` // The payload fields that were rejected, if any
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// ProtocolError is returned by frame handlers to reject a frame with a specific code
+type ProtocolError struct {
+	Code    string
+	Message string
+	Field   *validation.FieldError // Set when a single payload field was rejected
+}
+
+// Error implements the error interface
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// newProtocolError creates a protocol error with a formatted message
+func newProtocolError(code, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// newFieldError creates the protocol error for an invalid payload field
+func newFieldError(err *validation.FieldError) *ProtocolError {
+	return &ProtocolError{Code: ErrCodeInvalidPayload, Message: err.Error(), Field: err}
+}
+
+// FrameContext carries the connection state a frame handler may need
+type FrameContext struct {
+	Client    *Client
+	UserID    int64
+	RequestID string
+}
+
+// FrameHandler handles the payload of one inbound frame type. The returned ID,
+// if non-zero, is reported in the "ack" frame.
+type FrameHandler func(ctx *FrameContext, payload json.RawMessage) (int64, error)
+
+// Dispatcher routes inbound frames to the handler registered for their type
+type Dispatcher struct {
+	handlers map[string]FrameHandler
+}
+
+// NewDispatcher creates a dispatcher with no registered handlers
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers: make(map[string]FrameHandler),
+	}
+}
+
+// Handle registers the handler for a frame type
+func (d *Dispatcher) Handle(frameType string, handler FrameHandler) {
+	d.handlers[frameType] = handler
+}
+
+// Dispatch decodes a raw frame and runs its handler. It returns the reply to
+// send back: an ErrorFrame when the frame was rejected, an AckFrame when it
+// was accepted and carried a request ID, or nil otherwise.
+func (d *Dispatcher) Dispatch(ctx *FrameContext, data []byte) interface{} {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return newErrorFrame("", newProtocolError(ErrCodeBadFrame, "frame is not a valid envelope: %v", err))
+	}
+	ctx.RequestID = envelope.RequestID
+
+	if envelope.Version != 0 && envelope.Version != ProtocolVersion {
+		return newErrorFrame(envelope.RequestID, newProtocolError(ErrCodeUnsupportedVersion, "protocol version %d is not supported", envelope.Version))
+	}
+
+	// Chat messages from clients that predate the envelope are still
+	// accepted for one release: their fields are at the top level and the
+	// type defaults to "message"
+	if len(envelope.Payload) == 0 && isLegacyFrame(envelope.Type, data) {
+		logging.Warnf("User %d sent a chat message without an envelope; this format is deprecated and will be rejected in the next release", ctx.UserID)
+		if envelope.Type == "" {
+			envelope.Type = "message"
+		}
+		envelope.Payload = data
+	}
+
+	if envelope.Type == "" {
+		return newErrorFrame(envelope.RequestID, newProtocolError(ErrCodeBadFrame, "frame type is required"))
+	}
+
+	handler, ok := d.handlers[envelope.Type]
+	if !ok {
+		return newErrorFrame(envelope.RequestID, newProtocolError(ErrCodeUnknownType, "unknown frame type %q", envelope.Type))
+	}
+
+	id, err := handler(ctx, envelope.Payload)
+	if err != nil {
+		return newErrorFrame(envelope.RequestID, err)
+	}
+
+	if envelope.RequestID == "" {
+		return nil
+	}
+
+	return &AckFrame{
+		Type:      "ack",
+		RequestID: envelope.RequestID,
+		ID:        id,
+		Timestamp: time.Now(),
+	}
+}
+
+// isLegacyFrame reports whether a frame without a payload is a chat message
+// in the format used before the envelope
+func isLegacyFrame(frameType string, data []byte) bool {
+	if frameType != "" && frameType != "message" && frameType != "direct_message" {
+		return false
+	}
+
+	var fields struct {
+		Content *string `json:"content"`
+	}
+	return json.Unmarshal(data, &fields) == nil && fields.Content != nil
+}
+
+// decodePayload unmarshals a frame payload, reporting failures as invalid_payload
+func decodePayload(payload json.RawMessage, v interface{}) error {
+	if len(payload) == 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "payload is required")
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return newProtocolError(ErrCodeInvalidPayload, "invalid payload: %v", err)
+	}
+	return nil
+}
+
+// newErrorFrame builds an error frame; errors that are not protocol errors
+// are reported as internal errors without leaking their details
+func newErrorFrame(requestID string, err error) *ErrorFrame {
+	protocolErr, ok := err.(*ProtocolError)
+	if !ok {
+		protocolErr = &ProtocolError{Code: ErrCodeInternal, Message: "internal server error"}
+	}
+
+	frame := &ErrorFrame{
+		Type:      "error",
+		RequestID: requestID,
+		Code:      protocolErr.Code,
+		Message:   protocolErr.Message,
+		Timestamp: time.Now(),
+	}
+	if protocolErr.Field != nil {
+		frame.Fields = validation.Errors{protocolErr.Field}
+	}
+	return frame
+}
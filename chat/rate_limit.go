@@ -0,0 +1,52 @@
+package chat
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gofiber/websocket/v2"
+
+	"gochat/logging"
+	"gochat/ratelimit"
+)
+
+// SetFrameLimits limits each user to rate inbound frames per second, in
+// bursts of up to burst frames across all of their connections. Rejected
+// frames use up strikes, which come back at the same rate; a connection
+// that runs out of strikes is closed. It must be called before Run.
+func (h *ChatHub) SetFrameLimits(rate float64, burst, strikes int) {
+	h.frameLimiter = ratelimit.NewLimiter(rate, burst)
+	h.strikeLimiter = ratelimit.NewLimiter(rate, strikes)
+}
+
+// allowFrame reports whether the client may send another frame. A rejected
+// frame is answered with a "rate_limited" error, and a client that keeps
+// sending frames over the limit is disconnected.
+func (c *Client) allowFrame(data []byte) bool {
+	if c.hub.frameLimiter == nil {
+		return true
+	}
+
+	key := strconv.FormatInt(c.UserID, 10)
+	if c.hub.frameLimiter.Allow(key) {
+		return true
+	}
+
+	if !c.hub.strikeLimiter.Allow(key) {
+		select {
+		case <-c.closed:
+			// Frames still arriving while the connection closes
+		default:
+			logging.Warnf("User %d keeps exceeding the frame rate limit, disconnecting", c.UserID)
+			c.close(websocket.ClosePolicyViolation, "rate limit exceeded")
+		}
+		return false
+	}
+
+	// Echo the request ID, if there is one, so the client knows which
+	// frame was dropped
+	var envelope Envelope
+	json.Unmarshal(data, &envelope)
+	c.sendFrame(newErrorFrame(envelope.RequestID, newProtocolError(ErrCodeRateLimited, "too many frames, slow down")))
+	return false
+}
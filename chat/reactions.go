@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gochat/logging"
+	"gochat/models"
+)
+
+// maxEmojiLength caps the size of a reaction in bytes; multi-codepoint emoji
+// such as flags and skin tone variants need more than one rune
+const maxEmojiLength = 64
+
+// ReactionPayload is the payload of "add_reaction" and "remove_reaction" frames
+type ReactionPayload struct {
+	MessageID int64  `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// handleReaction returns the handler for "add_reaction" (add true) or
+// "remove_reaction" (add false). Only changes are broadcast, so repeating a
+// frame is harmless.
+func (h *ChatHub) handleReaction(add bool) FrameHandler {
+	return func(ctx *FrameContext, payload json.RawMessage) (int64, error) {
+		var req ReactionPayload
+		if err := decodePayload(payload, &req); err != nil {
+			return 0, err
+		}
+		if err := validateEmoji(req.Emoji); err != nil {
+			return 0, err
+		}
+
+		// Reactions need a live message the user can see
+		message, err := h.getMessage(req.MessageID)
+		if err != nil {
+			return 0, err
+		}
+		if message.DeletedAt != nil {
+			return 0, newProtocolError(ErrCodeNotFound, "message %d has been deleted", message.ID)
+		}
+		if err := h.checkCanSee(ctx.UserID, message); err != nil {
+			return 0, err
+		}
+
+		var changed bool
+		if add {
+			changed, err = h.reactionRepo.AddReaction(message.ID, ctx.UserID, req.Emoji)
+		} else {
+			changed, err = h.reactionRepo.RemoveReaction(message.ID, ctx.UserID, req.Emoji)
+		}
+		if err != nil {
+			logging.Errorf("Error updating reactions of message %d: %v", message.ID, err)
+			return 0, err
+		}
+
+		if changed {
+			h.announceReactions(message)
+		}
+		return message.ID, nil
+	}
+}
+
+// announceReactions sends the current reaction counts of a message to its
+// conversation as a "reaction_updated" event
+func (h *ChatHub) announceReactions(message *models.Message) {
+	counts, err := h.reactionRepo.GetReactionCounts([]int64{message.ID})
+	if err != nil {
+		logging.Errorf("Error getting reactions of message %d: %v", message.ID, err)
+		return
+	}
+
+	// The event has no reactions once the last one is removed
+	message.Reactions = counts[message.ID]
+
+	h.announceChange("reaction_updated", message)
+}
+
+// checkCanSee verifies that a user may read a message, reporting hidden
+// messages as missing
+func (h *ChatHub) checkCanSee(userID int64, message *models.Message) error {
+	visible, err := message.VisibleTo(userID, h.roomRepo)
+	if err != nil {
+		logging.Errorf("Error checking access to message %d: %v", message.ID, err)
+		return err
+	}
+	if !visible {
+		return newProtocolError(ErrCodeNotFound, "message %d not found", message.ID)
+	}
+	return nil
+}
+
+// validateEmoji checks that a reaction is a short printable string
+func validateEmoji(emoji string) error {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return newProtocolError(ErrCodeInvalidPayload, "emoji must be 1 to %d bytes of UTF-8", maxEmojiLength)
+	}
+	if strings.IndexFunc(emoji, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "emoji must not contain spaces or control characters")
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gochat/logging"
+	"gochat/models"
+)
+
+// MarkReadPayload is the payload of a "mark_read" frame. Both conversation
+// IDs zero means the global channel.
+type MarkReadPayload struct {
+	RoomID      int64 `json:"room_id,omitempty"`
+	RecipientID int64 `json:"recipient_id,omitempty"` // The other user of a direct conversation
+	MessageID   int64 `json:"message_id"`
+}
+
+// handleMarkRead moves the sender's read marker forward
+func (h *ChatHub) handleMarkRead(ctx *FrameContext, payload json.RawMessage) (int64, error) {
+	var req MarkReadPayload
+	if err := decodePayload(payload, &req); err != nil {
+		return 0, err
+	}
+
+	marker, err := h.MarkRead(ctx.UserID, req.RoomID, req.RecipientID, req.MessageID)
+	if err != nil {
+		return 0, err
+	}
+	return marker.LastReadMessageID, nil
+}
+
+// MarkRead records that a user has read a conversation up to messageID and
+// sends a "read_receipt" to the other participants and the user's own
+// connections. Errors that the caller should report are *ProtocolError.
+func (h *ChatHub) MarkRead(userID, roomID, peerID, messageID int64) (*models.ReadMarker, error) {
+	if messageID <= 0 {
+		return nil, newProtocolError(ErrCodeInvalidPayload, "message_id is required")
+	}
+	if err := h.checkConversation(userID, roomID, peerID); err != nil {
+		return nil, err
+	}
+
+	// The message must belong to the conversation being marked
+	message, err := h.messageRepo.GetMessageByID(messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, newProtocolError(ErrCodeNotFound, "message %d not found", messageID)
+		}
+		logging.Errorf("Error getting message %d: %v", messageID, err)
+		return nil, err
+	}
+	if !inConversation(message, userID, roomID, peerID) {
+		return nil, newProtocolError(ErrCodeInvalidPayload, "message %d is not in this conversation", messageID)
+	}
+
+	marker, err := h.readRepo.MarkRead(userID, roomID, peerID, messageID)
+	if err != nil {
+		logging.Errorf("Error marking messages read: %v", err)
+		return nil, err
+	}
+
+	// Markers never move backwards, so there is nothing new to announce
+	// when an older message was marked
+	if marker.LastReadMessageID != messageID {
+		return marker, nil
+	}
+
+	user, err := h.userRepo.GetUserByID(userID)
+	if err != nil {
+		logging.Errorf("Error getting user %d: %v", userID, err)
+		return nil, err
+	}
+
+	receipt := &ChatMessage{
+		Type:        "read_receipt",
+		UserID:      user.ID,
+		Username:    user.Username,
+		RoomID:      roomID,
+		RecipientID: peerID,
+		MessageID:   marker.LastReadMessageID,
+		Timestamp:   marker.UpdatedAt,
+	}
+
+	select {
+	case h.broadcast <- receipt:
+	case <-h.done:
+	}
+	return marker, nil
+}
+
+// inConversation reports whether a message belongs to the conversation of
+// userID identified by roomID and peerID
+func inConversation(message *models.Message, userID, roomID, peerID int64) bool {
+	if peerID != 0 {
+		return (message.UserID == userID && message.RecipientID == peerID) ||
+			(message.UserID == peerID && message.RecipientID == userID)
+	}
+	return message.RecipientID == 0 && message.RoomID == roomID
+}
+
+// sendUnreadCounts sends a client the number of unread messages in each of
+// its user's conversations
+func (h *ChatHub) sendUnreadCounts(client *Client) {
+	counts, err := h.readRepo.GetUnreadCounts(client.UserID)
+	if err != nil {
+		logging.Errorf("Error getting unread counts for user %d: %v", client.UserID, err)
+		return
+	}
+
+	message := struct {
+		Type      string                `json:"type"`
+		Unread    []*models.UnreadCount `json:"unread"`
+		Timestamp time.Time             `json:"timestamp"`
+	}{
+		Type:      "unread_counts",
+		Unread:    counts,
+		Timestamp: time.Now(),
+	}
+
+	client.sendFrame(message)
+}
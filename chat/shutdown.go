@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+
+	"gochat/logging"
+)
+
+// IsShuttingDown reports whether Shutdown has been called; new WebSocket
+// upgrades should be refused from then on
+func (h *ChatHub) IsShuttingDown() bool {
+	return h.closing.Load()
+}
+
+// Shutdown stops the hub: it stops accepting clients, delivers the pending
+// broadcasts, tells every client the server is going away, closes their
+// connections and marks every user offline. It returns when the hub has
+// stopped or ctx is done, whichever comes first.
+func (h *ChatHub) Shutdown(ctx context.Context) error {
+	if h.closing.CompareAndSwap(false, true) {
+		close(h.quit)
+	}
+
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdownClients runs on the main loop once quit is closed
+func (h *ChatHub) shutdownClients() {
+	// Flush everything already queued so no accepted message is lost
+	h.drainQueues()
+
+	// Take every remaining client out of the hub
+	h.clientsMu.Lock()
+	clients := h.clients
+	h.clients = make(map[*Client]bool)
+	h.clientsMu.Unlock()
+	h.presence = make(map[int64]*userPresence)
+
+	// Notify and close every client; each write pump flushes its queue
+	// before sending the close frame
+	for client := range clients {
+		sendShutdown(client)
+	}
+	for client := range clients {
+		<-client.writerDone
+	}
+
+	// Nobody is connected anymore
+	if err := h.userRepo.MarkAllOffline(); err != nil {
+		logging.Errorf("Error marking users offline: %v", err)
+	}
+
+	logging.Infof("Chat hub stopped, disconnected %d clients", len(clients))
+}
+
+// drainQueues processes queued registrations, unregistrations and
+// broadcasts until all channels are empty
+func (h *ChatHub) drainQueues() {
+	for {
+		select {
+		case client := <-h.register:
+			// Too late to join; the client is closed like the others
+			sendShutdown(client)
+
+		case client := <-h.unregister:
+			h.unregisterClient(client)
+
+		case message := <-h.broadcast:
+			h.broadcastMessage(message)
+
+		default:
+			return
+		}
+	}
+}
+
+// refuseClient turns away a connection that arrives while the hub is
+// shutting down and waits for its writer to finish. A client the main loop
+// already closed is left as it is.
+func (h *ChatHub) refuseClient(client *Client) {
+	sendShutdown(client)
+	<-client.writerDone
+}
+
+// sendShutdown tells a client the server is going away and closes it
+func sendShutdown(client *Client) {
+	client.sendFrame(struct {
+		Type      string    `json:"type"`
+		Content   string    `json:"content"`
+		Timestamp time.Time `json:"timestamp"`
+	}{
+		Type:      "server_shutdown",
+		Content:   "The server is shutting down",
+		Timestamp: time.Now(),
+	})
+	client.close(websocket.CloseGoingAway, "server shutting down")
+}
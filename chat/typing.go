@@ -0,0 +1,137 @@
+package chat
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// typingTimeout is how long a typing indicator lasts without being
+	// renewed by another "typing_start"
+	typingTimeout = 6 * time.Second
+
+	// typingSweepInterval is how often expired indicators are cleared
+	typingSweepInterval = time.Second
+)
+
+// TypingPayload is the payload of "typing_start" and "typing_stop" frames.
+// Both IDs zero means the global channel.
+type TypingPayload struct {
+	RoomID      int64 `json:"room_id,omitempty"`
+	RecipientID int64 `json:"recipient_id,omitempty"`
+}
+
+// typingKey identifies one user typing in one conversation
+type typingKey struct {
+	userID      int64
+	roomID      int64
+	recipientID int64
+}
+
+// typingState is an active typing indicator
+type typingState struct {
+	username  string
+	expiresAt time.Time
+}
+
+// typingEvent is a typing change sent from a read goroutine to the main loop
+type typingEvent struct {
+	key      typingKey
+	username string
+	typing   bool
+}
+
+// handleTyping returns the handler for "typing_start" (typing true) or
+// "typing_stop" (typing false). Indicators are relayed, never persisted.
+func (h *ChatHub) handleTyping(typing bool) FrameHandler {
+	return func(ctx *FrameContext, payload json.RawMessage) (int64, error) {
+		var req TypingPayload
+		if len(payload) > 0 {
+			if err := decodePayload(payload, &req); err != nil {
+				return 0, err
+			}
+		}
+
+		if err := h.checkConversation(ctx.UserID, req.RoomID, req.RecipientID); err != nil {
+			return 0, err
+		}
+
+		user, err := h.userRepo.GetUserByID(ctx.UserID)
+		if err != nil {
+			return 0, err
+		}
+
+		event := &typingEvent{
+			key:      typingKey{userID: user.ID, roomID: req.RoomID, recipientID: req.RecipientID},
+			username: user.Username,
+			typing:   typing,
+		}
+
+		select {
+		case h.typing <- event:
+		case <-h.quit:
+		}
+		return 0, nil
+	}
+}
+
+// applyTyping starts, renews or stops an indicator. Only changes are
+// relayed, so clients may repeat "typing_start" to keep it alive.
+func (h *ChatHub) applyTyping(event *typingEvent) {
+	state, active := h.typists[event.key]
+
+	if !event.typing {
+		if active {
+			delete(h.typists, event.key)
+			h.relayTyping(event.key, state.username, false)
+		}
+		return
+	}
+
+	expiresAt := time.Now().Add(typingTimeout)
+	if active {
+		state.expiresAt = expiresAt
+		return
+	}
+
+	h.typists[event.key] = &typingState{username: event.username, expiresAt: expiresAt}
+	h.relayTyping(event.key, event.username, true)
+}
+
+// expireTyping clears the indicators that were not renewed in time
+func (h *ChatHub) expireTyping(now time.Time) {
+	for key, state := range h.typists {
+		if now.After(state.expiresAt) {
+			delete(h.typists, key)
+			h.relayTyping(key, state.username, false)
+		}
+	}
+}
+
+// clearTyping stops every indicator of a user, e.g. when they disconnect
+func (h *ChatHub) clearTyping(userID int64) {
+	for key, state := range h.typists {
+		if key.userID == userID {
+			delete(h.typists, key)
+			h.relayTyping(key, state.username, false)
+		}
+	}
+}
+
+// relayTyping sends a typing change to the other participants of the conversation
+func (h *ChatHub) relayTyping(key typingKey, username string, typing bool) {
+	messageType := "typing_stop"
+	if typing {
+		messageType = "typing_start"
+	}
+
+	h.broadcastMessage(&ChatMessage{
+		Type:        messageType,
+		UserID:      key.userID,
+		Username:    username,
+		RoomID:      key.roomID,
+		RecipientID: key.recipientID,
+		Timestamp:   time.Now(),
+		skipSender:  true,
+	})
+}
@@ -0,0 +1,397 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gochat/logging"
+)
+
+// DefaultJWTSecret is the development signing key; it is rejected in production
+const DefaultJWTSecret = "your-secret-key"
+
+// Supported values for Config.Env
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// Supported values for Config.DBDriver
+const (
+	DBDriverSQLite   = "sqlite"
+	DBDriverPostgres = "postgres"
+)
+
+// minProductionSecretLength is the shortest JWT secret accepted in production
+const minProductionSecretLength = 32
+
+// Config holds the server settings
+type Config struct {
+	Env            string           `json:"env"`             // "development" or "production"
+	ListenAddr     string           `json:"listen_addr"`     // e.g. ":8080"
+	DBDriver       string           `json:"db_driver"`       // "sqlite" or "postgres"
+	DBPath         string           `json:"db_path"`         // SQLite database file
+	DatabaseURL    string           `json:"database_url"`    // Postgres connection URL
+	SearchFallback bool             `json:"search_fallback"` // Search SQLite with LIKE in production if it lacks FTS5 instead of refusing to start
+	JWT            JWTConfig        `json:"jwt"`
+	CORSOrigins    []string         `json:"cors_origins"`
+	LogLevel       string           `json:"log_level"`  // "debug", "info", "warn" or "error"
+	AccessLog      bool             `json:"access_log"` // Log every HTTP request, whatever the log level
+	Moderators     []string         `json:"moderators"` // Usernames of existing accounts allowed to edit and delete any message
+	Attachments    AttachmentConfig `json:"attachments"`
+	RateLimit      RateLimitConfig  `json:"rate_limit"`
+}
+
+// AttachmentConfig holds the settings for uploaded files
+type AttachmentConfig struct {
+	Dir        string   `json:"dir"`         // Directory of the local blob store
+	MaxSize    int64    `json:"max_size"`    // Largest accepted upload in bytes
+	PendingTTL Duration `json:"pending_ttl"` // How long an upload not sent with a message is kept
+}
+
+// RateLimitConfig holds the limits on inbound WebSocket frames and on
+// authentication attempts
+type RateLimitConfig struct {
+	FrameRate    float64         `json:"frame_rate"`    // Frames per second each user may send
+	FrameBurst   int             `json:"frame_burst"`   // Frames a user may send at once
+	FrameStrikes int             `json:"frame_strikes"` // Rate-limited frames tolerated in a burst before disconnecting
+	Login        AuthLimitConfig `json:"login"`
+	Register     AuthLimitConfig `json:"register"`
+}
+
+// AuthLimitConfig locks out IP addresses and usernames that make too many
+// failed attempts at an authentication endpoint within a window
+type AuthLimitConfig struct {
+	MaxPerIP       int      `json:"max_per_ip"`
+	MaxPerUsername int      `json:"max_per_username"`
+	Window         Duration `json:"window"`
+	Lockout        Duration `json:"lockout"`
+}
+
+// JWTConfig holds the settings used to sign and verify access tokens
+type JWTConfig struct {
+	Secret     string   `json:"secret"`
+	Issuer     string   `json:"issuer"`
+	Audience   string   `json:"audience"`
+	TTL        Duration `json:"ttl"`         // Lifetime of access tokens
+	RefreshTTL Duration `json:"refresh_ttl"` // Lifetime of refresh tokens
+}
+
+// Duration is a time.Duration read from strings such as "15m" in config files
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = parsed
+	return nil
+}
+
+// Default returns the development configuration
+func Default() *Config {
+	return &Config{
+		Env:        EnvDevelopment,
+		ListenAddr: ":8080",
+		DBDriver:   DBDriverSQLite,
+		DBPath:     "./chat.db",
+		JWT: JWTConfig{
+			Secret:     DefaultJWTSecret,
+			Issuer:     "gochat",
+			Audience:   "gochat",
+			TTL:        Duration{15 * time.Minute},
+			RefreshTTL: Duration{30 * 24 * time.Hour},
+		},
+		CORSOrigins: []string{"*"},
+		LogLevel:    "info",
+		AccessLog:   true,
+		Attachments: AttachmentConfig{
+			Dir:        "./attachments",
+			MaxSize:    10 << 20,
+			PendingTTL: Duration{24 * time.Hour},
+		},
+		RateLimit: RateLimitConfig{
+			FrameRate:    10,
+			FrameBurst:   20,
+			FrameStrikes: 20,
+			Login: AuthLimitConfig{
+				MaxPerIP:       20,
+				MaxPerUsername: 5,
+				Window:         Duration{15 * time.Minute},
+				Lockout:        Duration{15 * time.Minute},
+			},
+			Register: AuthLimitConfig{
+				MaxPerIP:       10,
+				MaxPerUsername: 5,
+				Window:         Duration{time.Hour},
+				Lockout:        Duration{time.Hour},
+			},
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the optional JSON file
+// named by GOCHAT_CONFIG and then GOCHAT_* environment variables, in that
+// order of precedence, and validates the result
+func Load() (*Config, error) {
+	cfg := Default()
+
+	// Apply the config file if one is given
+	if path := os.Getenv("GOCHAT_CONFIG"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Environment variables override the file
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile merges the JSON config file at path into cfg. Unknown settings
+// are rejected, so a misspelt one cannot silently keep its default.
+func (cfg *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("parse config file %s: unexpected data after the settings", path)
+	}
+
+	return nil
+}
+
+// loadEnv merges GOCHAT_* environment variables into cfg
+func (cfg *Config) loadEnv() error {
+	setString := func(key string, target *string) {
+		if value, ok := os.LookupEnv(key); ok {
+			*target = value
+		}
+	}
+
+	setString("GOCHAT_ENV", &cfg.Env)
+	setString("GOCHAT_LISTEN_ADDR", &cfg.ListenAddr)
+	setString("GOCHAT_DB_DRIVER", &cfg.DBDriver)
+	setString("GOCHAT_DB_PATH", &cfg.DBPath)
+	setString("GOCHAT_DATABASE_URL", &cfg.DatabaseURL)
+	setString("GOCHAT_JWT_SECRET", &cfg.JWT.Secret)
+	setString("GOCHAT_JWT_ISSUER", &cfg.JWT.Issuer)
+	setString("GOCHAT_JWT_AUDIENCE", &cfg.JWT.Audience)
+	setString("GOCHAT_LOG_LEVEL", &cfg.LogLevel)
+	setString("GOCHAT_ATTACHMENTS_DIR", &cfg.Attachments.Dir)
+
+	setDuration := func(key string, target *Duration) error {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return nil
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", key, err)
+		}
+		*target = Duration{parsed}
+		return nil
+	}
+
+	if err := setDuration("GOCHAT_JWT_TTL", &cfg.JWT.TTL); err != nil {
+		return err
+	}
+	if err := setDuration("GOCHAT_JWT_REFRESH_TTL", &cfg.JWT.RefreshTTL); err != nil {
+		return err
+	}
+	if err := setDuration("GOCHAT_ATTACHMENTS_PENDING_TTL", &cfg.Attachments.PendingTTL); err != nil {
+		return err
+	}
+
+	setBool := func(key string, target *bool) error {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return nil
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", key, err)
+		}
+		*target = parsed
+		return nil
+	}
+
+	if err := setBool("GOCHAT_SEARCH_FALLBACK", &cfg.SearchFallback); err != nil {
+		return err
+	}
+	if err := setBool("GOCHAT_ACCESS_LOG", &cfg.AccessLog); err != nil {
+		return err
+	}
+
+	if value, ok := os.LookupEnv("GOCHAT_CORS_ORIGINS"); ok {
+		cfg.CORSOrigins = splitList(value)
+	}
+	if value, ok := os.LookupEnv("GOCHAT_MODERATORS"); ok {
+		cfg.Moderators = splitList(value)
+	}
+	if value, ok := os.LookupEnv("GOCHAT_ATTACHMENTS_MAX_SIZE"); ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse GOCHAT_ATTACHMENTS_MAX_SIZE: %w", err)
+		}
+		cfg.Attachments.MaxSize = size
+	}
+
+	return nil
+}
+
+// Validate checks that the configuration is usable
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	if cfg.Env != EnvDevelopment && cfg.Env != EnvProduction {
+		errs = append(errs, fmt.Errorf("env must be %q or %q, got %q", EnvDevelopment, EnvProduction, cfg.Env))
+	}
+	if cfg.ListenAddr == "" {
+		errs = append(errs, errors.New("listen address is required"))
+	}
+	switch cfg.DBDriver {
+	case DBDriverSQLite:
+		if cfg.DBPath == "" {
+			errs = append(errs, errors.New("database path is required"))
+		}
+	case DBDriverPostgres:
+		if cfg.DatabaseURL == "" {
+			errs = append(errs, errors.New("database URL is required for postgres"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("database driver must be %q or %q, got %q", DBDriverSQLite, DBDriverPostgres, cfg.DBDriver))
+	}
+	if cfg.JWT.Secret == "" {
+		errs = append(errs, errors.New("JWT secret is required"))
+	}
+	if cfg.JWT.Issuer == "" || cfg.JWT.Audience == "" {
+		errs = append(errs, errors.New("JWT issuer and audience are required"))
+	}
+	if cfg.JWT.TTL.Duration <= 0 {
+		errs = append(errs, errors.New("JWT TTL must be positive"))
+	}
+	if cfg.JWT.RefreshTTL.Duration <= cfg.JWT.TTL.Duration {
+		errs = append(errs, errors.New("JWT refresh TTL must be longer than the access token TTL"))
+	}
+	if len(cfg.CORSOrigins) == 0 {
+		errs = append(errs, errors.New("at least one CORS origin is required"))
+	}
+	if cfg.Attachments.Dir == "" {
+		errs = append(errs, errors.New("attachments directory is required"))
+	}
+	if cfg.Attachments.MaxSize <= 0 {
+		errs = append(errs, errors.New("attachment max size must be positive"))
+	}
+	if cfg.Attachments.PendingTTL.Duration <= 0 {
+		errs = append(errs, errors.New("attachment pending TTL must be positive"))
+	}
+
+	if cfg.RateLimit.FrameRate <= 0 || cfg.RateLimit.FrameBurst < 1 || cfg.RateLimit.FrameStrikes < 1 {
+		errs = append(errs, errors.New("frame rate, burst and strikes must be positive"))
+	}
+	if !cfg.RateLimit.Login.valid() {
+		errs = append(errs, errors.New("login rate limit attempts, window and lockout must be positive"))
+	}
+	if !cfg.RateLimit.Register.valid() {
+		errs = append(errs, errors.New("register rate limit attempts, window and lockout must be positive"))
+	}
+
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Refuse to run production with a guessable signing key or with every
+	// origin allowed to call the API
+	if cfg.IsProduction() {
+		if cfg.JWT.Secret == DefaultJWTSecret {
+			errs = append(errs, errors.New("the default JWT secret cannot be used in production"))
+		} else if len(cfg.JWT.Secret) < minProductionSecretLength {
+			errs = append(errs, fmt.Errorf("JWT secret must be at least %d bytes in production", minProductionSecretLength))
+		}
+		for _, origin := range cfg.CORSOrigins {
+			if origin == "*" {
+				errs = append(errs, errors.New("the wildcard CORS origin cannot be used in production"))
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// IsProduction reports whether the server runs in production mode
+func (cfg *Config) IsProduction() bool {
+	return cfg.Env == EnvProduction
+}
+
+// valid reports whether every limit is positive
+func (l AuthLimitConfig) valid() bool {
+	return l.MaxPerIP > 0 && l.MaxPerUsername > 0 && l.Window.Duration > 0 && l.Lockout.Duration > 0
+}
+
+// Level returns the configured log level; Validate has checked it
+func (cfg *Config) Level() logging.Level {
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	return level
+}
+
+// AllowSearchFallback reports whether SQLite built without FTS5 may search
+// messages with LIKE matching: always in development, so plain "go build"
+// and "go run" work, and in production only when search_fallback is set
+func (cfg *Config) AllowSearchFallback() bool {
+	return cfg.SearchFallback || !cfg.IsProduction()
+}
+
+// DatabaseDSN returns the data source of the configured database driver
+func (cfg *Config) DatabaseDSN() string {
+	if cfg.DBDriver == DBDriverPostgres {
+		return cfg.DatabaseURL
+	}
+	return cfg.DBPath
+}
+
+// splitList splits a comma-separated list, dropping blank entries
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gochat/logging"
+)
+
+// productionSecret is long enough for production
+const productionSecret = "0123456789abcdef0123456789abcdef"
+
+// clearEnv unsets every GOCHAT_* variable for the duration of the test
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(key, "GOCHAT_") {
+			t.Setenv(key, "")
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// writeFile writes a config file and returns its path
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string            // Config file contents; empty for none
+		env     map[string]string // GOCHAT_* variables besides GOCHAT_CONFIG
+		check   func(t *testing.T, cfg *Config)
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.ListenAddr != ":8080" || cfg.DBDriver != DBDriverSQLite || !cfg.AccessLog || cfg.Level() != logging.LevelInfo || cfg.IsProduction() {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "file overrides defaults",
+			file: `{"listen_addr": ":9090", "log_level": "warn", "access_log": false, "jwt": {"ttl": "5m"}}`,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.ListenAddr != ":9090" || cfg.Level() != logging.LevelWarn || cfg.AccessLog || cfg.JWT.TTL.Duration != 5*time.Minute {
+					t.Errorf("config = %+v", cfg)
+				}
+				// Settings missing from the file keep their defaults
+				if cfg.JWT.Issuer != "gochat" || cfg.DBPath != "./chat.db" {
+					t.Errorf("defaults lost: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "environment overrides file",
+			file: `{"listen_addr": ":9090", "log_level": "warn", "access_log": false, "cors_origins": ["https://file.example"]}`,
+			env: map[string]string{
+				"GOCHAT_LISTEN_ADDR":             ":7070",
+				"GOCHAT_LOG_LEVEL":               "debug",
+				"GOCHAT_ACCESS_LOG":              "true",
+				"GOCHAT_CORS_ORIGINS":            "https://a.example, ,https://b.example",
+				"GOCHAT_JWT_TTL":                 "1m",
+				"GOCHAT_ATTACHMENTS_PENDING_TTL": "2h",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.ListenAddr != ":7070" || cfg.Level() != logging.LevelDebug || !cfg.AccessLog || cfg.JWT.TTL.Duration != time.Minute {
+					t.Errorf("config = %+v", cfg)
+				}
+				if cfg.Attachments.PendingTTL.Duration != 2*time.Hour {
+					t.Errorf("pending upload TTL = %v", cfg.Attachments.PendingTTL)
+				}
+				if strings.Join(cfg.CORSOrigins, " ") != "https://a.example https://b.example" {
+					t.Errorf("CORS origins = %q", cfg.CORSOrigins)
+				}
+			},
+		},
+		{
+			name: "production from the environment",
+			file: `{"cors_origins": ["https://chat.example"]}`,
+			env:  map[string]string{"GOCHAT_ENV": "production", "GOCHAT_JWT_SECRET": productionSecret},
+			check: func(t *testing.T, cfg *Config) {
+				if !cfg.IsProduction() || cfg.AllowSearchFallback() {
+					t.Errorf("config = %+v", cfg)
+				}
+			},
+		},
+		{
+			name:    "unreadable file",
+			env:     map[string]string{"GOCHAT_CONFIG": "/nonexistent/config.json"},
+			wantErr: "read config file",
+		},
+		{
+			name:    "malformed file",
+			file:    `{"listen_addr": `,
+			wantErr: "parse config file",
+		},
+		{
+			name:    "unknown file setting",
+			file:    `{"listen_adress": ":9090"}`,
+			wantErr: `unknown field "listen_adress"`,
+		},
+		{
+			name:    "unknown nested file setting",
+			file:    `{"jwt": {"tll": "5m"}}`,
+			wantErr: `unknown field "tll"`,
+		},
+		{
+			name:    "data after the settings",
+			file:    `{"listen_addr": ":9090"} {"listen_addr": ":7070"}`,
+			wantErr: "unexpected data after the settings",
+		},
+		{
+			name:    "malformed file duration",
+			file:    `{"jwt": {"ttl": "soon"}}`,
+			wantErr: "parse config file",
+		},
+		{
+			name:    "malformed environment duration",
+			env:     map[string]string{"GOCHAT_JWT_REFRESH_TTL": "later"},
+			wantErr: "parse GOCHAT_JWT_REFRESH_TTL",
+		},
+		{
+			name:    "malformed environment boolean",
+			env:     map[string]string{"GOCHAT_ACCESS_LOG": "sometimes"},
+			wantErr: "parse GOCHAT_ACCESS_LOG",
+		},
+		{
+			name:    "malformed environment size",
+			env:     map[string]string{"GOCHAT_ATTACHMENTS_MAX_SIZE": "big"},
+			wantErr: "parse GOCHAT_ATTACHMENTS_MAX_SIZE",
+		},
+		{
+			name:    "result is validated",
+			file:    `{"env": "staging"}`,
+			wantErr: `env must be "development" or "production"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			if tt.file != "" {
+				t.Setenv("GOCHAT_CONFIG", writeFile(t, tt.file))
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(cfg *Config)
+		wantErr string // Empty if the config is valid
+	}{
+		{"defaults", func(cfg *Config) {}, ""},
+		{"unknown env", func(cfg *Config) { cfg.Env = "staging" }, "env must be"},
+		{"no listen address", func(cfg *Config) { cfg.ListenAddr = "" }, "listen address is required"},
+		{"no SQLite path", func(cfg *Config) { cfg.DBPath = "" }, "database path is required"},
+		{"no Postgres URL", func(cfg *Config) { cfg.DBDriver = DBDriverPostgres }, "database URL is required"},
+		{"Postgres", func(cfg *Config) { cfg.DBDriver, cfg.DatabaseURL = DBDriverPostgres, "postgres://localhost/gochat" }, ""},
+		{"unknown driver", func(cfg *Config) { cfg.DBDriver = "mysql" }, "database driver must be"},
+		{"no JWT secret", func(cfg *Config) { cfg.JWT.Secret = "" }, "JWT secret is required"},
+		{"no JWT issuer", func(cfg *Config) { cfg.JWT.Issuer = "" }, "JWT issuer and audience are required"},
+		{"no JWT audience", func(cfg *Config) { cfg.JWT.Audience = "" }, "JWT issuer and audience are required"},
+		{"zero JWT TTL", func(cfg *Config) { cfg.JWT.TTL = Duration{} }, "JWT TTL must be positive"},
+		{"short refresh TTL", func(cfg *Config) { cfg.JWT.RefreshTTL = cfg.JWT.TTL }, "refresh TTL must be longer"},
+		{"no CORS origins", func(cfg *Config) { cfg.CORSOrigins = nil }, "at least one CORS origin"},
+		{"no attachments directory", func(cfg *Config) { cfg.Attachments.Dir = "" }, "attachments directory is required"},
+		{"zero attachment size", func(cfg *Config) { cfg.Attachments.MaxSize = 0 }, "attachment max size must be positive"},
+		{"zero pending upload TTL", func(cfg *Config) { cfg.Attachments.PendingTTL.Duration = 0 }, "attachment pending TTL must be positive"},
+		{"zero frame rate", func(cfg *Config) { cfg.RateLimit.FrameRate = 0 }, "frame rate, burst and strikes"},
+		{"zero frame burst", func(cfg *Config) { cfg.RateLimit.FrameBurst = 0 }, "frame rate, burst and strikes"},
+		{"zero frame strikes", func(cfg *Config) { cfg.RateLimit.FrameStrikes = 0 }, "frame rate, burst and strikes"},
+		{"zero login lockout", func(cfg *Config) { cfg.RateLimit.Login.Lockout = Duration{} }, "login rate limit"},
+		{"unknown log level", func(cfg *Config) { cfg.LogLevel = "verbose" }, "log level must be"},
+		{"error log level", func(cfg *Config) { cfg.LogLevel = "error" }, ""},
+		{"zero register attempts", func(cfg *Config) { cfg.RateLimit.Register.MaxPerIP = 0 }, "register rate limit"},
+
+		// Production refuses the development defaults
+		{"production", productionConfig, ""},
+		{"production default secret", func(cfg *Config) {
+			productionConfig(cfg)
+			cfg.JWT.Secret = DefaultJWTSecret
+		}, "default JWT secret cannot be used in production"},
+		{"production short secret", func(cfg *Config) {
+			productionConfig(cfg)
+			cfg.JWT.Secret = productionSecret[1:]
+		}, "JWT secret must be at least 32 bytes in production"},
+		{"production wildcard origin", func(cfg *Config) {
+			productionConfig(cfg)
+			cfg.CORSOrigins = []string{"https://chat.example", "*"}
+		}, "wildcard CORS origin cannot be used in production"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// productionConfig turns the defaults into a valid production configuration
+func productionConfig(cfg *Config) {
+	cfg.Env = EnvProduction
+	cfg.JWT.Secret = productionSecret
+	cfg.CORSOrigins = []string{"https://chat.example"}
+}
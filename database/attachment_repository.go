@@ -0,0 +1,191 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gochat/models"
+)
+
+// attachmentRepository handles database operations for attachments
+type attachmentRepository struct {
+	db *DB
+	mu sync.RWMutex // for thread safety
+}
+
+// NewAttachmentRepository creates a new attachment repository
+func NewAttachmentRepository(db *DB) AttachmentRepository {
+	return &attachmentRepository{
+		db: db,
+	}
+}
+
+// attachmentColumns is the column list read by scanAttachment
+const attachmentColumns = "id, user_id, message_id, filename, content_type, size, width, height, storage_key, thumbnail_key, created_at"
+
+// CreateAttachment stores the metadata of an uploaded blob
+func (r *attachmentRepository) CreateAttachment(attachment *models.Attachment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var id int64
+	err := r.db.QueryRow(`
+		INSERT INTO attachments (user_id, filename, content_type, size, width, height, storage_key, thumbnail_key, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, attachment.UserID, attachment.Filename, attachment.ContentType, attachment.Size,
+		attachment.Width, attachment.Height, attachment.StorageKey, attachment.ThumbnailKey, now).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("insert attachment: %w", err)
+	}
+
+	attachment.ID = id
+	attachment.CreatedAt = now
+	attachment.SetURLs()
+
+	return nil
+}
+
+// GetAttachmentByID retrieves an attachment by ID
+func (r *attachmentRepository) GetAttachmentByID(id int64) (*models.Attachment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attachment, err := scanAttachment(r.db.QueryRow(`
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE id = ?
+	`, id))
+	if err != nil {
+		return nil, fmt.Errorf("query attachment by id: %w", err)
+	}
+
+	return attachment, nil
+}
+
+// AttachToMessage links unsent attachments uploaded by userID to a message.
+// It reports how many attachments were linked.
+func (r *attachmentRepository) AttachToMessage(ids []int64, userID, messageID int64) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	placeholders, args := inClause(ids)
+	result, err := r.db.Exec(`
+		UPDATE attachments
+		SET message_id = ?
+		WHERE id IN (`+placeholders+`) AND user_id = ? AND message_id IS NULL
+	`, append(append([]interface{}{messageID}, args...), userID)...)
+	if err != nil {
+		return 0, fmt.Errorf("attach to message: %w", err)
+	}
+
+	linked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get rows affected: %w", err)
+	}
+
+	return int(linked), nil
+}
+
+// GetAttachmentsForMessages retrieves the attachments of the given messages
+// in upload order. Messages without attachments are absent from the result.
+func (r *attachmentRepository) GetAttachmentsForMessages(messageIDs []int64) (map[int64][]*models.Attachment, error) {
+	attachments := make(map[int64][]*models.Attachment)
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	placeholders, args := inClause(messageIDs)
+	rows, err := r.db.Query(`
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE message_id IN (`+placeholders+`)
+		ORDER BY id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan attachment: %w", err)
+		}
+		attachments[attachment.MessageID] = append(attachments[attachment.MessageID], attachment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// DeletePendingAttachments deletes the attachments uploaded before the given
+// time that were never sent with a message and returns them, so their blobs
+// can be removed
+func (r *attachmentRepository) DeletePendingAttachments(before time.Time) ([]*models.Attachment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rows, err := r.db.Query(`
+		DELETE FROM attachments
+		WHERE message_id IS NULL AND created_at < ?
+		RETURNING `+attachmentColumns, before)
+	if err != nil {
+		return nil, fmt.Errorf("delete pending attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var deleted []*models.Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan attachment: %w", err)
+		}
+		deleted = append(deleted, attachment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate deleted attachments: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// scanAttachment reads an attachment row selected with attachmentColumns
+func scanAttachment(row rowScanner) (*models.Attachment, error) {
+	var attachment models.Attachment
+	var messageID sql.NullInt64
+
+	err := row.Scan(&attachment.ID, &attachment.UserID, &messageID, &attachment.Filename, &attachment.ContentType,
+		&attachment.Size, &attachment.Width, &attachment.Height, &attachment.StorageKey, &attachment.ThumbnailKey, &attachment.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.MessageID = messageID.Int64
+	attachment.SetURLs()
+	return &attachment, nil
+}
+
+// inClause returns the placeholders and arguments of an IN list of IDs
+func inClause(ids []int64) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
@@ -1,83 +1,33 @@
 package database
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
 	"sync"
 	"time"
 
-	"gochat/models"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
 
-	_ "github.com/mattn/go-sqlite3"
+	"gochat/models"
 )
 
-// DB is a shared database instance
-var DB *sql.DB
-
-// Connect initializes the database connection
-func Connect(dbPath string) error {
-	var err error
-
-	// Open SQLite database
-	DB, err = sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return err
-	}
-
-	// Test connection
-	if err = DB.Ping(); err != nil {
-		return err
-	}
-
-	// Create tables if they don't exist
-	if err = createTables(); err != nil {
-		return err
-	}
-
-	log.Println("Database connected successfully")
-	return nil
-}
-
-// Close closes the database connection
-func Close() {
-	if DB != nil {
-		DB.Close()
-	}
-}
-
-// createTables creates required tables if they don't exist
-func createTables() error {
-	// Create users table
-	_, err := DB.Exec(`
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE NOT NULL,
-		email TEXT UNIQUE NOT NULL,
-		password TEXT NOT NULL,
-		status TEXT DEFAULT 'offline',
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`)
-
-	return err
-}
-
-// UserRepository handles database operations for users
-type UserRepository struct {
-	db *sql.DB
+// userRepository handles database operations for users
+type userRepository struct {
+	db *DB
 	mu sync.RWMutex // for thread safety
 }
 
 // NewUserRepository creates a new user repository
-func NewUserRepository(db *sql.DB) *UserRepository {
-	return &UserRepository{
+func NewUserRepository(db *DB) UserRepository {
+	return &userRepository{
 		db: db,
 	}
 }
 
 // CreateUser creates a new user in the database
-func (r *UserRepository) CreateUser(user *models.User) error {
+func (r *userRepository) CreateUser(user *models.User) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -85,6 +35,7 @@ func (r *UserRepository) CreateUser(user *models.User) error {
 	stmt, err := r.db.Prepare(`
 		INSERT INTO users (username, email, password, status, created_at, updated_at)
 		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
 	`)
 	if err != nil {
 		return fmt.Errorf("prepare insert user statement: %w", err)
@@ -92,14 +43,10 @@ func (r *UserRepository) CreateUser(user *models.User) error {
 	defer stmt.Close()
 
 	now := time.Now()
-	result, err := stmt.Exec(user.Username, user.Email, user.Password, user.Status, now, now)
+	var id int64
+	err = stmt.QueryRow(user.Username, user.Email, user.Password, user.Status, now, now).Scan(&id)
 	if err != nil {
-		return fmt.Errorf("execute insert user: %w", err)
-	}
-
-	id, err := result.LastInsertId()
-	if err != nil {
-		return fmt.Errorf("get last insert id: %w", err)
+		return fmt.Errorf("execute insert user: %w", duplicateField(err, "username", "email"))
 	}
 
 	user.ID = id
@@ -109,8 +56,8 @@ func (r *UserRepository) CreateUser(user *models.User) error {
 	return nil
 }
 
-// GetUserByUsername retrieves a user by username
-func (r *UserRepository) GetUserByUsername(username string) (*models.User, error) {
+// GetUserByUsername retrieves a user by username, ignoring case
+func (r *userRepository) GetUserByUsername(username string) (*models.User, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -118,7 +65,7 @@ func (r *UserRepository) GetUserByUsername(username string) (*models.User, error
 	err := r.db.QueryRow(`
 		SELECT id, username, email, password, status, created_at, updated_at
 		FROM users
-		WHERE username = ?
+		WHERE LOWER(username) = LOWER(CAST(? AS TEXT))
 	`, username).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Status, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
@@ -129,7 +76,7 @@ func (r *UserRepository) GetUserByUsername(username string) (*models.User, error
 }
 
 // GetUserByID retrieves a user by ID
-func (r *UserRepository) GetUserByID(id int64) (*models.User, error) {
+func (r *userRepository) GetUserByID(id int64) (*models.User, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -148,7 +95,7 @@ func (r *UserRepository) GetUserByID(id int64) (*models.User, error) {
 }
 
 // UpdateUserStatus updates a user's status
-func (r *UserRepository) UpdateUserStatus(id int64, status string) error {
+func (r *userRepository) UpdateUserStatus(id int64, status string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -164,3 +111,147 @@ func (r *UserRepository) UpdateUserStatus(id int64, status string) error {
 
 	return nil
 }
+
+// MarkAllOffline sets every user who is not offline to offline
+func (r *userRepository) MarkAllOffline() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.db.Exec(`
+		UPDATE users
+		SET status = 'offline', updated_at = CURRENT_TIMESTAMP
+		WHERE status <> 'offline'
+	`)
+
+	if err != nil {
+		return fmt.Errorf("mark users offline: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserByEmail retrieves a user by email address, ignoring case
+func (r *userRepository) GetUserByEmail(email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var user models.User
+	err := r.db.QueryRow(`
+		SELECT id, username, email, password, status, created_at, updated_at
+		FROM users
+		WHERE LOWER(email) = LOWER(CAST(? AS TEXT))
+	`, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Status, &user.CreatedAt, &user.UpdatedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("query user by email: %w", err)
+	}
+
+	return &user, nil
+}
+
+// ListUsers retrieves a page of users matching the filter, ordered by
+// username, along with the total number of matching users
+func (r *userRepository) ListUsers(filter models.UserFilter) ([]*models.User, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Build the WHERE clause from the filter
+	where := "1 = 1"
+	args := []interface{}{}
+	if filter.UsernamePrefix != "" {
+		// LOWER keeps the match case-insensitive on Postgres too; the cast
+		// picks its text form over the range ones
+		where += ` AND LOWER(username) LIKE LOWER(CAST(? AS TEXT)) ESCAPE '\'`
+		args = append(args, escapeLike(filter.UsernamePrefix)+"%")
+	}
+	if filter.Status != "" {
+		where += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM users WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count users: %w", err)
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, username, email, password, status, created_at, updated_at
+		FROM users
+		WHERE `+where+`
+		ORDER BY username
+		LIMIT ? OFFSET ?
+	`, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Status, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, 0, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate users: %w", err)
+	}
+
+	return users, total, nil
+}
+
+// UpdateUserProfile updates a user's username and email
+func (r *userRepository) UpdateUserProfile(user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	_, err := r.db.Exec(`
+		UPDATE users
+		SET username = ?, email = ?, updated_at = ?
+		WHERE id = ?
+	`, user.Username, user.Email, now, user.ID)
+
+	if err != nil {
+		return fmt.Errorf("update user profile: %w", duplicateField(err, "username", "email"))
+	}
+
+	user.UpdatedAt = now
+	return nil
+}
+
+// pgUniqueViolation is the SQLSTATE of a unique constraint violation
+const pgUniqueViolation = "23505"
+
+// duplicateField turns a violation of the unique constraint or index of one
+// of fields into a *models.DuplicateError and returns other errors as is
+func duplicateField(err error, fields ...string) error {
+	// SQLite names the column or index and Postgres the constraint or
+	// index, e.g. "UNIQUE constraint failed: users.email" and
+	// "users_username_lower"
+	var detail string
+	var sqliteErr sqlite3.Error
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique:
+		detail = sqliteErr.Error()
+	case errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation:
+		detail = pgErr.ConstraintName
+	default:
+		return err
+	}
+
+	for _, field := range fields {
+		if strings.Contains(detail, field) {
+			return &models.DuplicateError{Field: field}
+		}
+	}
+	return err
+}
+
+// escapeLike escapes the LIKE wildcards in s using backslash
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+
+	"gochat/logging"
+)
+
+// Supported database drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// sqlDrivers maps the supported drivers to their database/sql driver names
+var sqlDrivers = map[string]string{
+	DriverSQLite:   "sqlite3",
+	DriverPostgres: "pgx",
+}
+
+// DB is a database connection that speaks the SQL dialect of its driver.
+// Queries are written with ? placeholders, which are rewritten to $1, $2, ...
+// for Postgres. Time arguments are converted to UTC, so SQLite stores and
+// compares every timestamp with the same offset.
+type DB struct {
+	*sql.DB
+	driver string
+	fts    bool // Whether the SQLite full-text index exists
+}
+
+// Connect opens the database, applies pending migrations and prepares the
+// search index. SQLite must be built with FTS5 (build tag sqlite_fts5) unless
+// allowSearchFallback accepts searching with LIKE matching instead.
+func Connect(driver, dsn string, allowSearchFallback bool) (*DB, error) {
+	db, err := Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bring the schema up to date
+	applied, err := Migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if applied > 0 {
+		logging.Infof("Applied %d database migration(s)", applied)
+	}
+
+	// Create the SQLite search index; it depends on how SQLite was built, so
+	// it is managed here rather than by a migration. Postgres searches with
+	// the index created by its migrations.
+	if driver == DriverSQLite {
+		if db.fts, err = createSearchIndex(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+		if !db.fts {
+			if !allowSearchFallback {
+				db.Close()
+				return nil, errors.New("SQLite was built without FTS5: build with -tags sqlite_fts5, or set search_fallback to use LIKE matching")
+			}
+			logging.Warnf("SQLite was built without FTS5 (build tag sqlite_fts5); message search uses LIKE matching")
+		}
+	}
+
+	logging.Infof("Database connected successfully (%s)", driver)
+	return db, nil
+}
+
+// Open opens and pings the database without changing its schema. dsn is a
+// file path for SQLite and a connection URL for Postgres.
+func Open(driver, dsn string) (*DB, error) {
+	name, ok := sqlDrivers[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Test connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{DB: db, driver: driver}, nil
+}
+
+// Driver returns the name of the database driver
+func (db *DB) Driver() string {
+	return db.driver
+}
+
+// Exec executes a query without returning any rows
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(rebind(db.driver, query), utcArgs(args)...)
+}
+
+// Query executes a query that returns rows
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(rebind(db.driver, query), utcArgs(args)...)
+}
+
+// QueryRow executes a query that returns at most one row
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(rebind(db.driver, query), utcArgs(args)...)
+}
+
+// Prepare creates a prepared statement
+func (db *DB) Prepare(query string) (*Stmt, error) {
+	stmt, err := db.DB.Prepare(rebind(db.driver, query))
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{Stmt: stmt}, nil
+}
+
+// Begin starts a transaction
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, driver: db.driver}, nil
+}
+
+// Tx is a transaction that rewrites placeholders like DB
+type Tx struct {
+	*sql.Tx
+	driver string
+}
+
+// Exec executes a query without returning any rows
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(rebind(tx.driver, query), utcArgs(args)...)
+}
+
+// Query executes a query that returns rows
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(rebind(tx.driver, query), utcArgs(args)...)
+}
+
+// QueryRow executes a query that returns at most one row
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(rebind(tx.driver, query), utcArgs(args)...)
+}
+
+// Stmt is a prepared statement that converts time arguments like DB
+type Stmt struct {
+	*sql.Stmt
+}
+
+// Exec executes the statement without returning any rows
+func (s *Stmt) Exec(args ...interface{}) (sql.Result, error) {
+	return s.Stmt.Exec(utcArgs(args)...)
+}
+
+// Query executes the statement and returns its rows
+func (s *Stmt) Query(args ...interface{}) (*sql.Rows, error) {
+	return s.Stmt.Query(utcArgs(args)...)
+}
+
+// QueryRow executes the statement and returns at most one row
+func (s *Stmt) QueryRow(args ...interface{}) *sql.Row {
+	return s.Stmt.QueryRow(utcArgs(args)...)
+}
+
+// utcArgs returns args with its times converted to UTC. SQLite stores times
+// as text with the offset of the value, which would compare out of order
+// with times stored with another offset.
+func utcArgs(args []interface{}) []interface{} {
+	converted := args
+	copied := false
+	for i, arg := range args {
+		var t time.Time
+		switch v := arg.(type) {
+		case time.Time:
+			t = v
+		case *time.Time:
+			if v == nil {
+				continue
+			}
+			t = *v
+		default:
+			continue
+		}
+
+		// Copy before the first change so the caller's slice is left alone
+		if !copied {
+			converted = append([]interface{}(nil), args...)
+			copied = true
+		}
+		converted[i] = t.UTC()
+	}
+	return converted
+}
+
+// rebind rewrites the ? placeholders of query to the numbered form Postgres
+// expects. Question marks inside string literals are left alone.
+func rebind(driver, query string) string {
+	if driver != DriverPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'':
+			quoted = !quoted
+		case ch == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(ch)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,11 @@
+package database
+
+// DisableFullTextSearch makes db search like SQLite built without FTS5
+func DisableFullTextSearch(db *DB) {
+	db.fts = false
+}
+
+// ApplyMigration applies a single migration the way Migrate does
+func ApplyMigration(db *DB, migration Migration) error {
+	return applyMigration(db, migration)
+}
@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"gochat/models"
+)
+
+// CreateAttachment stores the metadata of an uploaded blob
+func (s *Store) CreateAttachment(attachment *models.Attachment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attachment.ID = s.nextID("attachments")
+	attachment.MessageID = 0
+	attachment.CreatedAt = time.Now()
+	attachment.SetURLs()
+
+	stored := *attachment
+	s.attachments[attachment.ID] = &stored
+	return nil
+}
+
+// GetAttachmentByID retrieves an attachment by ID
+func (s *Store) GetAttachmentByID(id int64) (*models.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attachment, ok := s.attachments[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *attachment
+	return &found, nil
+}
+
+// AttachToMessage links unsent attachments uploaded by userID to a message.
+// It reports how many attachments were linked.
+func (s *Store) AttachToMessage(ids []int64, userID, messageID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	linked := 0
+	for _, id := range ids {
+		attachment, ok := s.attachments[id]
+		if !ok || attachment.UserID != userID || attachment.MessageID != 0 {
+			continue
+		}
+		attachment.MessageID = messageID
+		linked++
+	}
+	return linked, nil
+}
+
+// DeletePendingAttachments deletes the attachments uploaded before the given
+// time that were never sent with a message and returns them, so their blobs
+// can be removed
+func (s *Store) DeletePendingAttachments(before time.Time) ([]*models.Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted []*models.Attachment
+	for id, attachment := range s.attachments {
+		if attachment.MessageID == 0 && attachment.CreatedAt.Before(before) {
+			delete(s.attachments, id)
+			deleted = append(deleted, attachment)
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].ID < deleted[j].ID })
+	return deleted, nil
+}
+
+// GetAttachmentsForMessages retrieves the attachments of the given messages
+// in upload order. Messages without attachments are absent from the result.
+func (s *Store) GetAttachmentsForMessages(messageIDs []int64) (map[int64][]*models.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[int64]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	var found []*models.Attachment
+	for _, attachment := range s.attachments {
+		if attachment.MessageID != 0 && wanted[attachment.MessageID] {
+			copied := *attachment
+			found = append(found, &copied)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+
+	attachments := make(map[int64][]*models.Attachment)
+	for _, attachment := range found {
+		attachments[attachment.MessageID] = append(attachments[attachment.MessageID], attachment)
+	}
+	return attachments, nil
+}
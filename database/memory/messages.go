@@ -0,0 +1,318 @@
+package memory
+
+import (
+	"database/sql"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"gochat/models"
+)
+
+// CreateMessage stores a new message. A reply also updates the reply
+// summary of its parent.
+func (s *Store) CreateMessage(message *models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keep the caller's timestamp so the stored and broadcast times match
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+	message.ID = s.nextID("messages")
+
+	stored := *message
+	stored.Username = ""
+	stored.Reactions = nil
+	stored.Attachments = nil
+	s.messages[message.ID] = &stored
+
+	if parent, ok := s.messages[message.ParentID]; ok {
+		parent.ReplyCount++
+		lastReplyAt := message.CreatedAt
+		parent.LastReplyAt = &lastReplyAt
+	}
+
+	return nil
+}
+
+// GetMessageByID retrieves a message by ID
+func (s *Store) GetMessageByID(id int64) (*models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getMessage(id)
+}
+
+// ListMessages retrieves a page of messages of a room, oldest first, leaving
+// out thread replies. A zero roomID lists the global channel.
+func (s *Store) ListMessages(roomID, beforeID, afterID int64, limit int) ([]*models.Message, error) {
+	return s.listPage(func(m *models.Message) bool {
+		return m.RoomID == roomID && m.RecipientID == 0 && m.ParentID == 0
+	}, beforeID, afterID, limit)
+}
+
+// ListDirectMessages retrieves a page of the direct conversation between two
+// users, oldest first and without thread replies
+func (s *Store) ListDirectMessages(userID, peerID, beforeID, afterID int64, limit int) ([]*models.Message, error) {
+	return s.listPage(func(m *models.Message) bool {
+		return m.ParentID == 0 &&
+			((m.UserID == userID && m.RecipientID == peerID) || (m.UserID == peerID && m.RecipientID == userID))
+	}, beforeID, afterID, limit)
+}
+
+// ListReplies retrieves a page of the replies to a message, oldest first
+func (s *Store) ListReplies(parentID, beforeID, afterID int64, limit int) ([]*models.Message, error) {
+	return s.listPage(func(m *models.Message) bool {
+		return m.ParentID == parentID
+	}, beforeID, afterID, limit)
+}
+
+// EditMessage replaces the content of a message, recording the previous
+// content as a revision made by editorID
+func (s *Store) EditMessage(id, editorID int64, content string) (*models.Message, error) {
+	return s.revise(id, editorID, models.RevisionEdit, content)
+}
+
+// DeleteMessage soft-deletes a message: its content is cleared and kept only
+// in a revision made by deletedBy
+func (s *Store) DeleteMessage(id, deletedBy int64) (*models.Message, error) {
+	return s.revise(id, deletedBy, models.RevisionDelete, "")
+}
+
+// revise stores the current content of a message as a revision and applies
+// an edit or deletion; a deleted message is reported like a missing one
+func (s *Store) revise(id, changedBy int64, action, content string) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, ok := s.messages[id]
+	if !ok || message.DeletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	now := time.Now()
+	s.revisions = append(s.revisions, &models.MessageRevision{
+		ID:        s.nextID("message_revisions"),
+		MessageID: id,
+		Action:    action,
+		Content:   message.Content,
+		ChangedBy: changedBy,
+		CreatedAt: now,
+	})
+
+	message.Content = content
+	if action == models.RevisionDelete {
+		message.DeletedAt = &now
+		s.summarizeReplies(message.ParentID)
+	} else {
+		message.EditedAt = &now
+	}
+
+	return s.getMessage(id)
+}
+
+// summarizeReplies recomputes the reply summary of a parent message from
+// its replies that are not deleted; the caller holds the write lock
+func (s *Store) summarizeReplies(parentID int64) {
+	parent, ok := s.messages[parentID]
+	if !ok {
+		return
+	}
+
+	parent.ReplyCount = 0
+	parent.LastReplyAt = nil
+	for _, reply := range s.messages {
+		if reply.ParentID != parentID || reply.DeletedAt != nil {
+			continue
+		}
+		parent.ReplyCount++
+		if parent.LastReplyAt == nil || reply.CreatedAt.After(*parent.LastReplyAt) {
+			lastReplyAt := reply.CreatedAt
+			parent.LastReplyAt = &lastReplyAt
+		}
+	}
+}
+
+// ListRevisions retrieves the audit trail of a message, oldest first
+func (s *Store) ListRevisions(messageID int64) ([]*models.MessageRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := []*models.MessageRevision{}
+	for _, revision := range s.revisions {
+		if revision.MessageID == messageID {
+			found := *revision
+			revisions = append(revisions, &found)
+		}
+	}
+	return revisions, nil
+}
+
+// SearchMessages finds the live messages visible to search.UserID whose
+// content contains every term of the query, ignoring case, newest first.
+// Snippets are the whole content, HTML-escaped with matches wrapped in
+// <mark> elements.
+func (s *Store) SearchMessages(search models.MessageSearch) ([]*models.SearchResult, error) {
+	terms := strings.Fields(strings.ToLower(search.Query))
+	if len(terms) == 0 {
+		return []*models.SearchResult{}, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := []*models.SearchResult{}
+	for _, id := range s.messageIDs() {
+		m := s.messages[id]
+		if m.DeletedAt != nil || !s.canSee(search.UserID, m) {
+			continue
+		}
+		if (search.RoomID != 0 && m.RoomID != search.RoomID) || (search.AuthorID != 0 && m.UserID != search.AuthorID) {
+			continue
+		}
+		if (!search.From.IsZero() && m.CreatedAt.Before(search.From)) || (!search.To.IsZero() && !m.CreatedAt.Before(search.To)) {
+			continue
+		}
+		if !containsAll(strings.ToLower(m.Content), terms) {
+			continue
+		}
+
+		message, _ := s.getMessage(id)
+		results = append(results, &models.SearchResult{Message: message, Snippet: snippet(m.Content, terms)})
+	}
+
+	// Newest first
+	sort.Slice(results, func(i, j int) bool { return results[i].Message.ID > results[j].Message.ID })
+
+	return page(results, search.Limit, search.Offset), nil
+}
+
+// listPage returns a cursor-paginated page of the messages matching keep,
+// oldest first. When afterID is set the page starts right after that
+// message; otherwise it ends right before beforeID, or at the newest message.
+func (s *Store) listPage(keep func(*models.Message) bool, beforeID, afterID int64, limit int) ([]*models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []int64
+	for _, id := range s.messageIDs() {
+		if !keep(s.messages[id]) {
+			continue
+		}
+		if afterID > 0 && id <= afterID {
+			continue
+		}
+		if afterID == 0 && beforeID > 0 && id >= beforeID {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	// Keep the oldest page after a cursor and the newest one otherwise
+	if limit >= 0 && len(ids) > limit {
+		if afterID > 0 {
+			ids = ids[:limit]
+		} else {
+			ids = ids[len(ids)-limit:]
+		}
+	}
+
+	messages := make([]*models.Message, 0, len(ids))
+	for _, id := range ids {
+		message, _ := s.getMessage(id)
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// getMessage returns a copy of a message with its author's username; the
+// caller holds the lock
+func (s *Store) getMessage(id int64) (*models.Message, error) {
+	message, ok := s.messages[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	found := *message
+	if user, ok := s.users[message.UserID]; ok {
+		found.Username = user.Username
+	}
+	return &found, nil
+}
+
+// messageIDs returns the IDs of all messages in ascending order; the caller
+// holds the lock
+func (s *Store) messageIDs() []int64 {
+	ids := make([]int64, 0, len(s.messages))
+	for id := range s.messages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// canSee reports whether a user can see a message: it is in the global
+// channel, a room they belong to or one of their direct conversations. The
+// caller holds the lock.
+func (s *Store) canSee(userID int64, m *models.Message) bool {
+	switch {
+	case m.RecipientID != 0:
+		return m.UserID == userID || m.RecipientID == userID
+	case m.RoomID != 0:
+		_, member := s.members[m.RoomID][userID]
+		return member
+	default:
+		return true
+	}
+}
+
+// snippet escapes content for HTML and wraps the occurrences of terms in
+// <mark> elements
+func snippet(content string, terms []string) string {
+	lower := strings.ToLower(content)
+	if len(lower) != len(content) {
+		// Byte offsets would not line up; show the content unmarked
+		return html.EscapeString(content)
+	}
+
+	marked := make([]bool, len(content))
+	for _, term := range terms {
+		for offset := 0; ; {
+			i := strings.Index(lower[offset:], term)
+			if i < 0 {
+				break
+			}
+			for j := offset + i; j < offset+i+len(term); j++ {
+				marked[j] = true
+			}
+			offset += i + len(term)
+		}
+	}
+
+	var b strings.Builder
+	for start := 0; start < len(content); {
+		end := start
+		for end < len(content) && marked[end] == marked[start] {
+			end++
+		}
+		if marked[start] {
+			b.WriteString("<mark>" + html.EscapeString(content[start:end]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(content[start:end]))
+		}
+		start = end
+	}
+	return b.String()
+}
+
+// containsAll reports whether content contains every term
+func containsAll(content string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(content, term) {
+			return false
+		}
+	}
+	return true
+}
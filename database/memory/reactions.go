@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"gochat/models"
+)
+
+// reaction is one user's reaction to a message
+type reaction struct {
+	messageID int64
+	userID    int64
+	emoji     string
+}
+
+// AddReaction records a user's reaction to a message. It reports whether the
+// reaction is new.
+func (s *Store) AddReaction(messageID, userID int64, emoji string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := reaction{messageID, userID, emoji}
+	for _, r := range s.reactions {
+		if r == added {
+			return false, nil
+		}
+	}
+
+	s.reactions = append(s.reactions, added)
+	return true, nil
+}
+
+// RemoveReaction deletes a user's reaction to a message. It reports whether
+// the reaction existed.
+func (s *Store) RemoveReaction(messageID, userID int64, emoji string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := reaction{messageID, userID, emoji}
+	for i, r := range s.reactions {
+		if r == removed {
+			s.reactions = append(s.reactions[:i], s.reactions[i+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// GetReactionCounts aggregates the reactions of the given messages by emoji,
+// in the order each emoji was first used. Messages without reactions are
+// absent from the result.
+func (s *Store) GetReactionCounts(messageIDs []int64) (map[int64][]models.ReactionCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[int64]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	counts := make(map[int64][]models.ReactionCount)
+	for _, r := range s.reactions {
+		if !wanted[r.messageID] {
+			continue
+		}
+
+		emojis := counts[r.messageID]
+		found := false
+		for i := range emojis {
+			if emojis[i].Emoji == r.emoji {
+				emojis[i].Count++
+				found = true
+				break
+			}
+		}
+		if !found {
+			emojis = append(emojis, models.ReactionCount{Emoji: r.emoji, Count: 1})
+		}
+		counts[r.messageID] = emojis
+	}
+
+	return counts, nil
+}
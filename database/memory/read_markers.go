@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"gochat/models"
+)
+
+// markerKey identifies the read marker of one user in one conversation
+type markerKey struct {
+	userID, roomID, peerID int64
+}
+
+// MarkRead moves a user's read marker in a conversation forward to
+// messageID. Markers never move backwards; the stored marker is returned.
+func (s *Store) MarkRead(userID, roomID, peerID, messageID int64) (*models.ReadMarker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := markerKey{userID, roomID, peerID}
+	marker, ok := s.markers[key]
+	if !ok {
+		marker = &models.ReadMarker{UserID: userID, RoomID: roomID, PeerID: peerID}
+		s.markers[key] = marker
+	}
+	if messageID > marker.LastReadMessageID {
+		marker.LastReadMessageID = messageID
+	}
+	marker.UpdatedAt = time.Now()
+
+	found := *marker
+	return &found, nil
+}
+
+// GetUnreadCounts counts the messages from other users after the user's
+// read marker in the global channel, every room they belong to and every
+// direct conversation. Only messages sent since the user registered, or
+// since they joined the room, are counted. Thread replies are left out like
+// in the history the markers refer to. Conversations without unread messages
+// are omitted.
+func (s *Store) GetUnreadCounts(userID int64) ([]*models.UnreadCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[markerKey]*models.UnreadCount)
+	for _, m := range s.messages {
+		if m.DeletedAt != nil || m.ParentID != 0 || m.UserID == userID {
+			continue
+		}
+
+		var key markerKey
+		switch {
+		case m.RecipientID != 0:
+			if m.RecipientID != userID {
+				continue
+			}
+			key = markerKey{userID, 0, m.UserID}
+		case m.RoomID != 0:
+			joinedAt, member := s.members[m.RoomID][userID]
+			if !member || m.CreatedAt.Before(joinedAt) {
+				continue
+			}
+			key = markerKey{userID, m.RoomID, 0}
+		default:
+			if user, ok := s.users[userID]; !ok || m.CreatedAt.Before(user.CreatedAt) {
+				continue
+			}
+			key = markerKey{userID, 0, 0}
+		}
+
+		var lastRead int64
+		if marker, ok := s.markers[key]; ok {
+			lastRead = marker.LastReadMessageID
+		}
+		if m.ID <= lastRead {
+			continue
+		}
+
+		count, ok := counts[key]
+		if !ok {
+			count = &models.UnreadCount{RoomID: key.roomID, PeerID: key.peerID, LastReadMessageID: lastRead}
+			counts[key] = count
+		}
+		count.Count++
+	}
+
+	// The global channel first, then rooms, then direct conversations
+	result := make([]*models.UnreadCount, 0, len(counts))
+	for _, count := range counts {
+		result = append(result, count)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if (a.PeerID == 0) != (b.PeerID == 0) {
+			return a.PeerID == 0
+		}
+		if a.RoomID != b.RoomID {
+			return a.RoomID < b.RoomID
+		}
+		return a.PeerID < b.PeerID
+	})
+
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package memory
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"gochat/models"
+)
+
+// CreateRoom stores a new room with creatorID as its first member; room
+// names must be unique
+func (s *Store) CreateRoom(room *models.Room, creatorID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.rooms {
+		if other.Name == room.Name {
+			return &models.DuplicateError{Field: "name"}
+		}
+	}
+
+	room.ID = s.nextID("rooms")
+	room.CreatedAt = time.Now()
+
+	stored := *room
+	s.rooms[room.ID] = &stored
+	s.members[room.ID] = map[int64]time.Time{creatorID: room.CreatedAt}
+	return nil
+}
+
+// GetRoomByID retrieves a room by ID
+func (s *Store) GetRoomByID(id int64) (*models.Room, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	room, ok := s.rooms[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *room
+	return &found, nil
+}
+
+// GetRoomByName retrieves a room by name
+func (s *Store) GetRoomByName(name string) (*models.Room, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, room := range s.rooms {
+		if room.Name == name {
+			found := *room
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ListRooms retrieves all rooms ordered by name
+func (s *Store) ListRooms() ([]*models.Room, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rooms := make([]*models.Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		found := *room
+		rooms = append(rooms, &found)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms, nil
+}
+
+// AddMember adds a user to a room; joining twice is a no-op
+func (s *Store) AddMember(roomID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members, ok := s.members[roomID]
+	if !ok {
+		return fmt.Errorf("insert room member: room %d does not exist", roomID)
+	}
+	if _, joined := members[userID]; !joined {
+		members[userID] = time.Now()
+	}
+	return nil
+}
+
+// RemoveMember removes a user from a room
+func (s *Store) RemoveMember(roomID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.members[roomID], userID)
+	return nil
+}
+
+// IsMember reports whether a user belongs to a room
+func (s *Store) IsMember(roomID, userID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, member := s.members[roomID][userID]
+	return member, nil
+}
+
+// GetMemberIDs retrieves the IDs of all users in a room in ascending order
+func (s *Store) GetMemberIDs(roomID int64) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int64, 0, len(s.members[roomID]))
+	for id := range s.members[roomID] {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gochat/models"
+)
+
+// CreateSession stores a new session
+func (s *Store) CreateSession(session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[session.ID]; exists {
+		return fmt.Errorf("insert session: session %q already exists", session.ID)
+	}
+
+	session.CreatedAt = time.Now()
+	stored := *session
+	s.sessions[session.ID] = &stored
+	return nil
+}
+
+// GetSession retrieves a session by ID
+func (s *Store) GetSession(id string) (*models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *session
+	return &found, nil
+}
+
+// RevokeSession marks a session as revoked; revoking twice keeps the first time
+func (s *Store) RevokeSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[id]; ok && session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+// CreateRefreshToken stores a refresh token hash for a session
+func (s *Store) CreateRefreshToken(token *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tokens[token.TokenHash]; exists {
+		return fmt.Errorf("insert refresh token: token already exists")
+	}
+
+	token.CreatedAt = time.Now()
+	stored := *token
+	s.tokens[token.TokenHash] = &stored
+	return nil
+}
+
+// GetRefreshToken retrieves a refresh token by its hash, including the
+// user of its session
+func (s *Store) GetRefreshToken(tokenHash string) (*models.RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[tokenHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	session, ok := s.sessions[token.SessionID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	found := *token
+	found.UserID = session.UserID
+	return &found, nil
+}
+
+// MarkRefreshTokenUsed consumes a refresh token. It reports false when the
+// token had already been used.
+func (s *Store) MarkRefreshTokenUsed(tokenHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[tokenHash]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	token.UsedAt = &now
+	return true, nil
+}
@@ -0,0 +1,79 @@
+// Package memory provides in-memory implementations of the database
+// repositories, so handlers and the chat hub can be tested without a
+// database file. They are safe for concurrent use.
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"gochat/database"
+	"gochat/models"
+)
+
+// Store holds every table in memory and implements all the repository
+// interfaces of the database package. Lookups of missing rows return
+// sql.ErrNoRows like the SQL repositories.
+type Store struct {
+	mu sync.RWMutex // guards everything below
+
+	users       map[int64]*models.User
+	messages    map[int64]*models.Message
+	revisions   []*models.MessageRevision
+	rooms       map[int64]*models.Room
+	members     map[int64]map[int64]time.Time // Join time by room and user ID
+	sessions    map[string]*models.Session
+	tokens      map[string]*models.RefreshToken
+	markers     map[markerKey]*models.ReadMarker
+	reactions   []reaction // In the order they were added
+	attachments map[int64]*models.Attachment
+
+	// Last ID handed out per table, like SQL auto-increment columns
+	sequences map[string]int64
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{
+		users:       make(map[int64]*models.User),
+		messages:    make(map[int64]*models.Message),
+		rooms:       make(map[int64]*models.Room),
+		members:     make(map[int64]map[int64]time.Time),
+		sessions:    make(map[string]*models.Session),
+		tokens:      make(map[string]*models.RefreshToken),
+		markers:     make(map[markerKey]*models.ReadMarker),
+		attachments: make(map[int64]*models.Attachment),
+		sequences:   make(map[string]int64),
+	}
+}
+
+// NewRepositories creates the repositories of a new, empty store
+func NewRepositories() *database.Repositories {
+	store := NewStore()
+	return &database.Repositories{
+		Users:       store,
+		Messages:    store,
+		Rooms:       store,
+		Sessions:    store,
+		ReadMarkers: store,
+		Reactions:   store,
+		Attachments: store,
+	}
+}
+
+// nextID returns the next ID of a table; the caller holds the write lock
+func (s *Store) nextID(table string) int64 {
+	s.sequences[table]++
+	return s.sequences[table]
+}
+
+// Compile-time checks that Store implements every repository
+var (
+	_ database.UserRepository       = (*Store)(nil)
+	_ database.MessageRepository    = (*Store)(nil)
+	_ database.RoomRepository       = (*Store)(nil)
+	_ database.SessionRepository    = (*Store)(nil)
+	_ database.ReadMarkerRepository = (*Store)(nil)
+	_ database.ReactionRepository   = (*Store)(nil)
+	_ database.AttachmentRepository = (*Store)(nil)
+)
@@ -0,0 +1,171 @@
+package memory
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"gochat/models"
+)
+
+// CreateUser stores a new user; usernames and emails must be unique
+func (s *Store) CreateUser(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(user); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user.ID = s.nextID("users")
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	stored := *user
+	s.users[user.ID] = &stored
+	return nil
+}
+
+// GetUserByUsername retrieves a user by username, ignoring case
+func (s *Store) GetUserByUsername(username string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Username, username) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetUserByID retrieves a user by ID
+func (s *Store) GetUserByID(id int64) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *user
+	return &found, nil
+}
+
+// GetUserByEmail retrieves a user by email address, ignoring case
+func (s *Store) GetUserByEmail(email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Email, email) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// UpdateUserStatus updates a user's status
+func (s *Store) UpdateUserStatus(id int64, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Like an UPDATE, a missing user is not an error
+	if user, ok := s.users[id]; ok {
+		user.Status = status
+		user.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// MarkAllOffline sets every user who is not offline to offline
+func (s *Store) MarkAllOffline() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, user := range s.users {
+		if user.Status != "offline" {
+			user.Status = "offline"
+			user.UpdatedAt = now
+		}
+	}
+	return nil
+}
+
+// UpdateUserProfile updates a user's username and email
+func (s *Store) UpdateUserProfile(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return nil
+	}
+	if err := s.checkUnique(user); err != nil {
+		return err
+	}
+
+	user.UpdatedAt = time.Now()
+	stored.Username = user.Username
+	stored.Email = user.Email
+	stored.UpdatedAt = user.UpdatedAt
+	return nil
+}
+
+// ListUsers retrieves a page of users matching the filter, ordered by
+// username, along with the total number of matching users
+func (s *Store) ListUsers(filter models.UserFilter) ([]*models.User, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := strings.ToLower(filter.UsernamePrefix)
+	matches := []*models.User{}
+	for _, user := range s.users {
+		if !strings.HasPrefix(strings.ToLower(user.Username), prefix) {
+			continue
+		}
+		if filter.Status != "" && user.Status != filter.Status {
+			continue
+		}
+		found := *user
+		matches = append(matches, &found)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Username < matches[j].Username })
+
+	return page(matches, filter.Limit, filter.Offset), len(matches), nil
+}
+
+// checkUnique rejects a user whose username or email belongs to another
+// user in any case, like the unique indexes of the users table; the caller
+// holds the lock
+func (s *Store) checkUnique(user *models.User) error {
+	for _, other := range s.users {
+		if other.ID == user.ID {
+			continue
+		}
+		if strings.EqualFold(other.Username, user.Username) {
+			return &models.DuplicateError{Field: "username"}
+		}
+		if strings.EqualFold(other.Email, user.Email) {
+			return &models.DuplicateError{Field: "email"}
+		}
+	}
+	return nil
+}
+
+// page returns the items of one LIMIT/OFFSET page
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return items[:0]
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,341 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"gochat/models"
+)
+
+// messageRepository handles database operations for chat messages
+type messageRepository struct {
+	db *DB
+	mu sync.RWMutex // for thread safety
+}
+
+// NewMessageRepository creates a new message repository
+func NewMessageRepository(db *DB) MessageRepository {
+	return &messageRepository{
+		db: db,
+	}
+}
+
+// CreateMessage stores a new message in the database. A reply also updates
+// the reply summary of its parent.
+func (r *messageRepository) CreateMessage(message *models.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Keep the caller's timestamp so the stored and broadcast times match
+	createdAt := message.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO messages (user_id, room_id, recipient_id, parent_id, content, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, message.UserID, nullableID(message.RoomID), nullableID(message.RecipientID), nullableID(message.ParentID), message.Content, createdAt).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("execute insert message: %w", err)
+	}
+
+	if message.ParentID != 0 {
+		_, err = tx.Exec(`
+			UPDATE messages
+			SET reply_count = reply_count + 1, last_reply_at = ?
+			WHERE id = ?
+		`, createdAt, message.ParentID)
+		if err != nil {
+			return fmt.Errorf("update reply summary: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	message.ID = id
+	message.CreatedAt = createdAt
+
+	return nil
+}
+
+// messageColumns is the column list read by scanMessage
+const messageColumns = "m.id, m.user_id, u.username, m.room_id, m.recipient_id, m.parent_id, m.content, m.created_at, m.edited_at, m.deleted_at, m.reply_count, m.last_reply_at"
+
+// GetMessageByID retrieves a message by ID
+func (r *messageRepository) GetMessageByID(id int64) (*models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	message, err := getMessage(r.db, id)
+	if err != nil {
+		return nil, fmt.Errorf("query message by id: %w", err)
+	}
+
+	return message, nil
+}
+
+// EditMessage replaces the content of a message, recording the previous
+// content as a revision made by editorID
+func (r *messageRepository) EditMessage(id, editorID int64, content string) (*models.Message, error) {
+	return r.revise(id, editorID, models.RevisionEdit, content)
+}
+
+// DeleteMessage soft-deletes a message: its content is cleared and kept only
+// in a revision made by deletedBy
+func (r *messageRepository) DeleteMessage(id, deletedBy int64) (*models.Message, error) {
+	return r.revise(id, deletedBy, models.RevisionDelete, "")
+}
+
+// revise stores the current content of a message as a revision and applies
+// an edit or deletion in one transaction. A message that is already deleted
+// cannot be changed and is reported like a missing one, so racing changes
+// that passed authorization leave no revision behind.
+func (r *messageRepository) revise(id, changedBy int64, action, content string) (*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previous string
+	var parentID sql.NullInt64
+	err = tx.QueryRow("SELECT content, parent_id FROM messages WHERE id = ? AND deleted_at IS NULL", id).Scan(&previous, &parentID)
+	if err != nil {
+		return nil, fmt.Errorf("query message content: %w", err)
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(`
+		INSERT INTO message_revisions (message_id, action, content, changed_by, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, id, action, previous, changedBy, now)
+	if err != nil {
+		return nil, fmt.Errorf("insert message revision: %w", err)
+	}
+
+	var result sql.Result
+	if action == models.RevisionDelete {
+		result, err = tx.Exec("UPDATE messages SET content = '', deleted_at = ? WHERE id = ? AND deleted_at IS NULL", now, id)
+	} else {
+		result, err = tx.Exec("UPDATE messages SET content = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL", content, now, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("update message: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("update message: %w", err)
+	}
+	if updated == 0 {
+		return nil, fmt.Errorf("update message: %w", sql.ErrNoRows)
+	}
+
+	// A deleted reply no longer counts towards the summary of its parent
+	if action == models.RevisionDelete && parentID.Valid {
+		_, err = tx.Exec(`
+			UPDATE messages
+			SET reply_count = (SELECT COUNT(*) FROM messages WHERE parent_id = ? AND deleted_at IS NULL),
+				last_reply_at = (SELECT MAX(created_at) FROM messages WHERE parent_id = ? AND deleted_at IS NULL)
+			WHERE id = ?
+		`, parentID.Int64, parentID.Int64, parentID.Int64)
+		if err != nil {
+			return nil, fmt.Errorf("update reply summary: %w", err)
+		}
+	}
+
+	message, err := getMessage(tx, id)
+	if err != nil {
+		return nil, fmt.Errorf("query message by id: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return message, nil
+}
+
+// ListRevisions retrieves the audit trail of a message, oldest first
+func (r *messageRepository) ListRevisions(messageID int64) ([]*models.MessageRevision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rows, err := r.db.Query(`
+		SELECT id, message_id, action, content, changed_by, created_at
+		FROM message_revisions
+		WHERE message_id = ?
+		ORDER BY id
+	`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("query message revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []*models.MessageRevision{}
+	for rows.Next() {
+		var revision models.MessageRevision
+		if err := rows.Scan(&revision.ID, &revision.MessageID, &revision.Action, &revision.Content, &revision.ChangedBy, &revision.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan message revision: %w", err)
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate message revisions: %w", err)
+	}
+
+	return revisions, nil
+}
+
+// queryRower is implemented by both *DB and *Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getMessage reads one message without taking the repository lock
+func getMessage(q queryRower, id int64) (*models.Message, error) {
+	return scanMessage(q.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.id = ?
+	`, id))
+}
+
+// ListMessages retrieves a page of messages of a room, oldest first, leaving
+// out thread replies. A zero roomID lists the global channel. When afterID is
+// set the page starts right after that message; otherwise it ends right
+// before beforeID (or at the newest message when beforeID is zero).
+func (r *messageRepository) ListMessages(roomID, beforeID, afterID int64, limit int) ([]*models.Message, error) {
+	// IS NOT DISTINCT FROM gives Postgres no type for a NULL room, so the
+	// parameter is cast like the cursors
+	return r.listPage(
+		"m.room_id IS NOT DISTINCT FROM CAST(? AS BIGINT) AND m.recipient_id IS NULL AND m.parent_id IS NULL",
+		[]interface{}{nullableID(roomID)},
+		beforeID, afterID, limit,
+	)
+}
+
+// ListDirectMessages retrieves a page of the direct conversation between two
+// users, oldest first and without thread replies, using the same cursors as
+// ListMessages
+func (r *messageRepository) ListDirectMessages(userID, peerID, beforeID, afterID int64, limit int) ([]*models.Message, error) {
+	return r.listPage(
+		"((m.user_id = ? AND m.recipient_id = ?) OR (m.user_id = ? AND m.recipient_id = ?)) AND m.parent_id IS NULL",
+		[]interface{}{userID, peerID, peerID, userID},
+		beforeID, afterID, limit,
+	)
+}
+
+// ListReplies retrieves a page of the replies to a message, oldest first,
+// using the same cursors as ListMessages
+func (r *messageRepository) ListReplies(parentID, beforeID, afterID int64, limit int) ([]*models.Message, error) {
+	return r.listPage("m.parent_id = ?", []interface{}{parentID}, beforeID, afterID, limit)
+}
+
+// listPage runs a cursor-paginated query over the messages matching where
+func (r *messageRepository) listPage(where string, args []interface{}, beforeID, afterID int64, limit int) ([]*models.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// The cursors are cast so Postgres does not infer a narrower type for
+	// them from the literal they are compared with
+	var query string
+	if afterID > 0 {
+		query = `
+			SELECT ` + messageColumns + `
+			FROM messages m
+			JOIN users u ON u.id = m.user_id
+			WHERE ` + where + ` AND m.id > CAST(? AS BIGINT)
+			ORDER BY m.id ASC
+			LIMIT ?`
+		args = append(args, afterID, limit)
+	} else {
+		// Select the newest page first, then flip it back to chronological order
+		query = `
+			SELECT * FROM (
+				SELECT ` + messageColumns + `
+				FROM messages m
+				JOIN users u ON u.id = m.user_id
+				WHERE ` + where + ` AND (CAST(? AS BIGINT) = 0 OR m.id < CAST(? AS BIGINT))
+				ORDER BY m.id DESC
+				LIMIT ?
+			) AS page
+			ORDER BY id ASC`
+		args = append(args, beforeID, beforeID, limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]*models.Message, 0, limit)
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage reads a message row selected with messageColumns
+func scanMessage(row rowScanner) (*models.Message, error) {
+	var message models.Message
+	var roomID, recipientID, parentID sql.NullInt64
+	var editedAt, deletedAt, lastReplyAt sql.NullTime
+
+	err := row.Scan(&message.ID, &message.UserID, &message.Username, &roomID, &recipientID, &parentID, &message.Content,
+		&message.CreatedAt, &editedAt, &deletedAt, &message.ReplyCount, &lastReplyAt)
+	if err != nil {
+		return nil, err
+	}
+
+	message.RoomID = roomID.Int64
+	message.RecipientID = recipientID.Int64
+	message.ParentID = parentID.Int64
+	if lastReplyAt.Valid {
+		message.LastReplyAt = &lastReplyAt.Time
+	}
+	if editedAt.Valid {
+		message.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		message.DeletedAt = &deletedAt.Time
+	}
+	return &message, nil
+}
+
+// nullableID maps a zero ID to SQL NULL
+func nullableID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
@@ -0,0 +1,305 @@
+package database
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gochat/models"
+)
+
+const (
+	// snippetTokens is the number of tokens in an FTS5 snippet
+	snippetTokens = 12
+
+	// headlineOptions configures the Postgres snippets to match FTS5 ones
+	headlineOptions = `StartSel="` + markStart + `", StopSel="` + markEnd + `", MaxWords=12, MinWords=6`
+
+	// likeSnippetContext is how many bytes of content the LIKE fallback keeps
+	// before the first match, and likeSnippetLength the snippet length
+	likeSnippetContext = 40
+	likeSnippetLength  = 160
+
+	// Highlight markers used while building snippets; they are replaced by
+	// HTML once the snippet has been escaped
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// createSearchIndex creates the FTS5 index of message content and the
+// triggers that keep it in sync. It reports false if FTS5 is not available,
+// in which case the triggers are dropped so writes keep working; the index
+// is rebuilt the next time the triggers are created.
+func createSearchIndex(db *DB) (bool, error) {
+	_, err := db.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+		content,
+		content = 'messages',
+		content_rowid = 'id'
+	)`)
+	if err == nil {
+		// An existing table only needs the module once it is used
+		_, err = db.Exec("SELECT rowid FROM messages_fts LIMIT 0")
+	}
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such module: fts5") {
+			return false, err
+		}
+
+		_, err = db.Exec(`
+		DROP TRIGGER IF EXISTS messages_fts_insert;
+		DROP TRIGGER IF EXISTS messages_fts_delete;
+		DROP TRIGGER IF EXISTS messages_fts_update;
+		`)
+		return false, err
+	}
+
+	var synced int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'messages_fts_insert'").Scan(&synced)
+	if err != nil {
+		return false, err
+	}
+
+	// Mirror every change of message content into the index
+	_, err = db.Exec(`
+	CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+	END;
+	`)
+	if err != nil {
+		return false, err
+	}
+
+	// Index the messages written while the triggers were missing
+	if synced == 0 {
+		if _, err = db.Exec("INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')"); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// SearchMessages finds the live messages visible to search.UserID whose
+// content contains every term of the query, best matches first. Terms match
+// as prefixes of words. Snippets are HTML-escaped with matches wrapped in
+// <mark> elements.
+func (r *messageRepository) SearchMessages(search models.MessageSearch) ([]*models.SearchResult, error) {
+	terms := strings.Fields(search.Query)
+	if len(terms) == 0 {
+		return []*models.SearchResult{}, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Only messages the user can see: the global channel, rooms they belong
+	// to and their own direct conversations
+	where := `m.deleted_at IS NULL AND (
+		(m.room_id IS NULL AND m.recipient_id IS NULL)
+		OR m.room_id IN (SELECT room_id FROM room_members WHERE user_id = ?)
+		OR (m.recipient_id IS NOT NULL AND (m.user_id = ? OR m.recipient_id = ?))
+	)`
+	args := []interface{}{search.UserID, search.UserID, search.UserID}
+
+	if search.RoomID != 0 {
+		where += " AND m.room_id = ?"
+		args = append(args, search.RoomID)
+	}
+	if search.AuthorID != 0 {
+		where += " AND m.user_id = ?"
+		args = append(args, search.AuthorID)
+	}
+	// Timestamps are stored in UTC, so compare them the same way
+	if !search.From.IsZero() {
+		where += " AND m.created_at >= ?"
+		args = append(args, search.From.UTC())
+	}
+	if !search.To.IsZero() {
+		where += " AND m.created_at < ?"
+		args = append(args, search.To.UTC())
+	}
+
+	// SQLite without FTS5 matches substrings and builds snippets in Go
+	likeFallback := r.db.driver == DriverSQLite && !r.db.fts
+
+	var query string
+	switch {
+	case r.db.driver == DriverPostgres:
+		query = `
+			SELECT ` + messageColumns + `, ts_headline('simple', m.content, q, ?)
+			FROM messages m
+			JOIN users u ON u.id = m.user_id
+			CROSS JOIN to_tsquery('simple', ?) AS q
+			WHERE to_tsvector('simple', m.content) @@ q AND ` + where + `
+			ORDER BY ts_rank(to_tsvector('simple', m.content), q) DESC, m.id DESC
+			LIMIT ? OFFSET ?`
+		args = append([]interface{}{headlineOptions, tsQuery(terms)}, args...)
+	case !likeFallback:
+		query = `
+			SELECT ` + messageColumns + `, snippet(messages_fts, 0, char(2), char(3), '…', ` + fmt.Sprint(snippetTokens) + `)
+			FROM messages_fts
+			JOIN messages m ON m.id = messages_fts.rowid
+			JOIN users u ON u.id = m.user_id
+			WHERE messages_fts MATCH ? AND ` + where + `
+			ORDER BY messages_fts.rank, m.id DESC
+			LIMIT ? OFFSET ?`
+		args = append([]interface{}{ftsQuery(terms)}, args...)
+	default:
+		for _, term := range terms {
+			where += ` AND m.content LIKE ? ESCAPE '\'`
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+		query = `
+			SELECT ` + messageColumns + `, ''
+			FROM messages m
+			JOIN users u ON u.id = m.user_id
+			WHERE ` + where + `
+			ORDER BY m.id DESC
+			LIMIT ? OFFSET ?`
+	}
+	args = append(args, search.Limit, search.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+	defer rows.Close()
+
+	results := []*models.SearchResult{}
+	for rows.Next() {
+		var snippet string
+		message, err := scanMessage(snippetScanner{rows, &snippet})
+		if err != nil {
+			return nil, fmt.Errorf("scan search result: %w", err)
+		}
+
+		if likeFallback {
+			snippet = likeSnippet(message.Content, terms)
+		}
+		results = append(results, &models.SearchResult{
+			Message: message,
+			Snippet: highlight(snippet),
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate search results: %w", err)
+	}
+
+	return results, nil
+}
+
+// snippetScanner reads a message row followed by a snippet column
+type snippetScanner struct {
+	row     rowScanner
+	snippet *string
+}
+
+// Scan implements rowScanner
+func (s snippetScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.snippet)...)
+}
+
+// ftsQuery turns search terms into an FTS5 query matching all of them as
+// word prefixes. Quoting keeps user input from being read as query syntax.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// tsQuery turns search terms into a Postgres tsquery matching all of them
+// as word prefixes, quoted like ftsQuery
+func tsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = "'" + strings.NewReplacer(`\`, `\\`, "'", "''").Replace(term) + "':*"
+	}
+	return strings.Join(quoted, " & ")
+}
+
+// likeSnippet marks the case-insensitive occurrences of terms in content,
+// keeping a window around the first one
+func likeSnippet(content string, terms []string) string {
+	lower := strings.ToLower(content)
+	if len(lower) != len(content) {
+		// Byte offsets would not line up; show the content unmarked
+		return truncate(content, 0, likeSnippetLength)
+	}
+
+	// Find every occurrence of every term, then drop overlapping ones
+	type span struct{ start, end int }
+	var spans []span
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		for offset := 0; ; {
+			i := strings.Index(lower[offset:], term)
+			if i < 0 {
+				break
+			}
+			spans = append(spans, span{offset + i, offset + i + len(term)})
+			offset += i + len(term)
+		}
+	}
+	if len(spans) == 0 {
+		return truncate(content, 0, likeSnippetLength)
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	start := max(0, spans[0].start-likeSnippetContext)
+	end := min(len(content), start+likeSnippetLength)
+
+	var b strings.Builder
+	pos := start
+	for _, s := range spans {
+		if s.start < pos || s.end > end {
+			continue
+		}
+		b.WriteString(truncate(content, pos, s.start))
+		b.WriteString(markStart + content[s.start:s.end] + markEnd)
+		pos = s.end
+	}
+	b.WriteString(truncate(content, pos, end))
+
+	snippet := b.String()
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(content) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// truncate returns content[start:end] with both bounds moved back to rune
+// boundaries
+func truncate(content string, start, end int) string {
+	end = min(end, len(content))
+	for start > 0 && start < len(content) && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && end > start && !utf8.RuneStart(content[end]) {
+		end--
+	}
+	return content[start:end]
+}
+
+// highlight escapes a snippet for HTML and turns its markers into <mark>
+// elements
+func highlight(snippet string) string {
+	return strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>").Replace(html.EscapeString(snippet))
+}
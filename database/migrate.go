@@ -0,0 +1,199 @@
+package database
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations of each driver in
+// migrations/<driver>, named NNNN_description.sql and applied in version
+// order. Both drivers share the same versions and names.
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// version of the server than this one
+var ErrSchemaTooNew = errors.New("database schema is newer than this server supports")
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus describes a migration known to the server or recorded in
+// the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time // Nil while the migration is pending
+	Known     bool       // False for migrations recorded by a newer server
+}
+
+// Migrations returns the embedded migrations of driver in version order
+func Migrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version number", entry.Name())
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	// Versions must run 1, 2, 3, ... so a gap or duplicate is caught at startup
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %s: expected version %d", migration.Name, i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Migrate applies the pending migrations to db, each in its own transaction,
+// and returns how many were applied. It refuses to touch a database whose
+// schema is newer than the embedded migrations.
+func Migrate(db *DB) (int, error) {
+	migrations, err := Migrations(db.driver)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := createMigrationsTable(db); err != nil {
+		return 0, err
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return 0, fmt.Errorf("query schema version: %w", err)
+	}
+	if current > len(migrations) {
+		return 0, fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, len(migrations))
+	}
+
+	applied := 0
+	for _, migration := range migrations[current:] {
+		if err := applyMigration(db, migration); err != nil {
+			return applied, err
+		}
+		applied++
+	}
+
+	return applied, nil
+}
+
+// Status lists every known migration and any unknown ones recorded in db,
+// in version order
+func Status(db *DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations(db.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("query applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	recorded := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scan applied migration: %w", err)
+		}
+		status.AppliedAt = &appliedAt
+		recorded[status.Version] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate applied migrations: %w", err)
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name, Known: true}
+		if applied, ok := recorded[migration.Version]; ok {
+			status.AppliedAt = applied.AppliedAt
+			delete(recorded, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	// Whatever is left was applied by a newer server
+	for _, status := range recorded {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// createMigrationsTable creates the table recording applied migrations
+func createMigrationsTable(db *DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// applyMigration runs one migration and records it in the same transaction
+func applyMigration(db *DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin migration %s: %w", migration.Name, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(migration.SQL); err != nil {
+		return fmt.Errorf("apply migration %s: %w", migration.Name, err)
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, time.Now())
+	if err != nil {
+		return fmt.Errorf("record migration %s: %w", migration.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit migration %s: %w", migration.Name, err)
+	}
+
+	return nil
+}
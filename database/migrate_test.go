@@ -0,0 +1,236 @@
+package database_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gochat/database"
+)
+
+// openUnmigrated opens an empty SQLite database in a temporary directory,
+// returning it with its path
+func openUnmigrated(t *testing.T) (*database.DB, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "chat.db")
+	db, err := database.Open(database.DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, path
+}
+
+// appliedVersions returns the versions recorded in schema_migrations
+func appliedVersions(t *testing.T, db *database.DB) []int {
+	t.Helper()
+
+	rows, err := db.Query("SELECT version FROM schema_migrations ORDER BY version")
+	if err != nil {
+		t.Fatalf("query schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			t.Fatalf("scan version: %v", err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("iterate versions: %v", err)
+	}
+	return versions
+}
+
+// tableExists reports whether SQLite has a table with the given name
+func tableExists(t *testing.T, db *database.DB, name string) bool {
+	t.Helper()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count); err != nil {
+		t.Fatalf("query sqlite_master: %v", err)
+	}
+	return count > 0
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	db, _ := openUnmigrated(t)
+
+	migrations, err := database.Migrations(database.DriverSQLite)
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	applied, err := database.Migrate(db)
+	if err != nil {
+		t.Fatalf("first Migrate: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("first Migrate applied %d migrations, want %d", applied, len(migrations))
+	}
+
+	// Running again finds nothing to do and records nothing twice
+	applied, err = database.Migrate(db)
+	if err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+	if applied != 0 {
+		t.Errorf("second Migrate applied %d migrations, want 0", applied)
+	}
+	if versions := appliedVersions(t, db); len(versions) != len(migrations) {
+		t.Errorf("recorded versions = %v, want 1 to %d once each", versions, len(migrations))
+	}
+
+	statuses, err := database.Status(db)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, status := range statuses {
+		if !status.Known || status.AppliedAt == nil {
+			t.Errorf("migration %s: known %v, applied at %v; want known and applied", status.Name, status.Known, status.AppliedAt)
+		}
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	db, path := openUnmigrated(t)
+
+	migrations, err := database.Migrations(database.DriverSQLite)
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// A newer server has applied a migration this one does not know
+	future := len(migrations) + 1
+	_, err = db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		future, "from_the_future", time.Now())
+	if err != nil {
+		t.Fatalf("record future migration: %v", err)
+	}
+
+	if _, err := database.Migrate(db); !errors.Is(err, database.ErrSchemaTooNew) {
+		t.Errorf("Migrate error = %v, want ErrSchemaTooNew", err)
+	}
+	if connected, err := database.Connect(database.DriverSQLite, path, true); !errors.Is(err, database.ErrSchemaTooNew) {
+		if err == nil {
+			connected.Close()
+		}
+		t.Errorf("Connect error = %v, want ErrSchemaTooNew", err)
+	}
+
+	statuses, err := database.Status(db)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(statuses) != future {
+		t.Fatalf("Status listed %d migrations, want %d", len(statuses), future)
+	}
+	last := statuses[future-1]
+	if last.Version != future || last.Name != "from_the_future" || last.Known || last.AppliedAt == nil {
+		t.Errorf("last status = %+v, want version %d from_the_future, unknown and applied", last, future)
+	}
+}
+
+func TestFailedMigrationLeavesNoTrace(t *testing.T) {
+	db, _ := openUnmigrated(t)
+
+	migrations, err := database.Migrations(database.DriverSQLite)
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// The first statement succeeds and the second fails
+	broken := database.Migration{
+		Version: len(migrations) + 1,
+		Name:    "broken",
+		SQL: `CREATE TABLE half_done (id INTEGER PRIMARY KEY);
+		INSERT INTO no_such_table (id) VALUES (1);`,
+	}
+	if err := database.ApplyMigration(db, broken); err == nil {
+		t.Fatal("ApplyMigration succeeded, want an error")
+	}
+
+	// Neither the schema change nor the version was kept
+	if tableExists(t, db, "half_done") {
+		t.Error("table created by the failed migration was kept")
+	}
+	for _, version := range appliedVersions(t, db) {
+		if version == broken.Version {
+			t.Errorf("failed migration was recorded as version %d", version)
+		}
+	}
+}
+
+// Migration 7 rewrites SQLite timestamps stored with a local offset in UTC
+// without losing their sub-second digits, so they order correctly against
+// the full-precision timestamps written since
+func TestSQLiteTimestampsToUTC(t *testing.T) {
+	db, err := database.Open(database.DriverSQLite, filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	migrations, err := database.Migrations(database.DriverSQLite)
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	for _, migration := range migrations[:6] {
+		if _, err := db.Exec(migration.SQL); err != nil {
+			t.Fatalf("apply %s: %v", migration.Name, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		stored string
+		want   string
+	}{
+		{"nanoseconds ahead of UTC", "2024-01-02 15:30:00.123456789+05:30", "2024-01-02 10:00:00.123456789+00:00"},
+		{"fraction behind UTC", "2024-01-02 10:00:00.5-02:00", "2024-01-02 12:00:00.5+00:00"},
+		{"across midnight", "2024-01-02 01:00:00.000001+02:00", "2024-01-01 23:00:00.000001+00:00"},
+		{"already UTC", "2024-01-02 10:00:00.25+00:00", "2024-01-02 10:00:00.25+00:00"},
+		{"whole seconds", "2024-01-02 10:00:00+01:00", "2024-01-02 09:00:00+00:00"},
+		{"CURRENT_TIMESTAMP", "2024-01-02 10:00:00", "2024-01-02 10:00:00+00:00"},
+		{"unparsable", "yesterday", "yesterday"},
+	}
+
+	for i, tt := range tests {
+		_, err := db.Exec("INSERT INTO users (id, username, email, password, created_at) VALUES (?, ?, ?, 'hash', ?)",
+			i+1, tt.name, tt.name+"@example.com", tt.stored)
+		if err != nil {
+			t.Fatalf("insert %s: %v", tt.name, err)
+		}
+	}
+
+	if migrations[6].Version != 7 {
+		t.Fatalf("migration %s is not version 7", migrations[6].Name)
+	}
+	if _, err := db.Exec(migrations[6].SQL); err != nil {
+		t.Fatalf("apply %s: %v", migrations[6].Name, err)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if err := db.QueryRow("SELECT created_at || '' FROM users WHERE id = ?", i+1).Scan(&got); err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("created_at = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
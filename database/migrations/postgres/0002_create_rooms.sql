@@ -0,0 +1,13 @@
+-- Chat rooms and their members
+CREATE TABLE IF NOT EXISTS rooms (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS room_members (
+	user_id BIGINT NOT NULL REFERENCES users(id),
+	room_id BIGINT NOT NULL REFERENCES rooms(id),
+	joined_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, room_id)
+);
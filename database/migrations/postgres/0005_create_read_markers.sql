@@ -0,0 +1,10 @@
+-- Read markers; room_id and peer_id are 0 when unused so the primary key can
+-- cover them
+CREATE TABLE IF NOT EXISTS read_markers (
+	user_id BIGINT NOT NULL REFERENCES users(id),
+	room_id BIGINT NOT NULL DEFAULT 0,
+	peer_id BIGINT NOT NULL DEFAULT 0,
+	last_read_message_id BIGINT NOT NULL,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, room_id, peer_id)
+);
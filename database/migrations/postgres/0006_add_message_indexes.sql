@@ -0,0 +1,8 @@
+-- Indexes for history pages, direct conversations, threads, attachments and
+-- message search
+CREATE INDEX IF NOT EXISTS idx_messages_room ON messages (room_id, id);
+CREATE INDEX IF NOT EXISTS idx_messages_recipient ON messages (recipient_id, user_id, id);
+CREATE INDEX IF NOT EXISTS idx_messages_parent ON messages (parent_id, id);
+CREATE INDEX IF NOT EXISTS idx_attachments_message ON attachments (message_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens (session_id);
+CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (to_tsvector('simple', content));
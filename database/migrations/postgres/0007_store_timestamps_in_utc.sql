@@ -0,0 +1,3 @@
+-- TIMESTAMPTZ columns already store instants, so only SQLite needs its
+-- timestamps converted to UTC
+SELECT 1;
@@ -0,0 +1,13 @@
+-- Chat rooms and their members
+CREATE TABLE IF NOT EXISTS rooms (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS room_members (
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	room_id INTEGER NOT NULL REFERENCES rooms(id),
+	joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, room_id)
+);
@@ -0,0 +1,50 @@
+-- Messages. A NULL room_id is the global channel, a recipient_id marks a
+-- direct message and a parent_id a thread reply; reply_count and
+-- last_reply_at summarize the replies of a parent.
+CREATE TABLE IF NOT EXISTS messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	room_id INTEGER REFERENCES rooms(id),
+	recipient_id INTEGER REFERENCES users(id),
+	parent_id INTEGER REFERENCES messages(id),
+	content TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	edited_at TIMESTAMP,
+	deleted_at TIMESTAMP,
+	reply_count INTEGER NOT NULL DEFAULT 0,
+	last_reply_at TIMESTAMP
+);
+
+-- The content a message had before each edit or deletion
+CREATE TABLE IF NOT EXISTS message_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id INTEGER NOT NULL REFERENCES messages(id),
+	action TEXT NOT NULL,
+	content TEXT NOT NULL,
+	changed_by INTEGER NOT NULL REFERENCES users(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Reactions; each user reacts at most once per emoji
+CREATE TABLE IF NOT EXISTS reactions (
+	message_id INTEGER NOT NULL REFERENCES messages(id),
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	emoji TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (message_id, user_id, emoji)
+);
+
+-- Uploaded files; message_id stays NULL until the upload is sent with a message
+CREATE TABLE IF NOT EXISTS attachments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	message_id INTEGER REFERENCES messages(id),
+	filename TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	storage_key TEXT NOT NULL,
+	thumbnail_key TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
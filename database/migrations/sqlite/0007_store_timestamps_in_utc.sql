@@ -0,0 +1,64 @@
+-- Convert the timestamps stored with the server's local offset to UTC, the
+-- offset the server now writes, so text comparisons order them correctly.
+--
+-- Every distinct stored value is converted once into utc_timestamps, then
+-- each column is rewritten from it. strftime converts the seconds, but its
+-- %f keeps only milliseconds, so the fraction is copied from the stored
+-- text instead: the digits after the dot at position 20, up to the offset.
+-- Values SQLite cannot parse are left as they are.
+CREATE TEMP TABLE utc_timestamps (
+	stored TEXT PRIMARY KEY,
+	converted TEXT NOT NULL
+);
+
+INSERT INTO utc_timestamps (stored, converted)
+SELECT stored,
+	strftime('%Y-%m-%d %H:%M:%S', stored)
+	|| CASE WHEN substr(stored, 20, 1) = '.'
+		THEN substr(stored, 20, length(stored) - 19 - length(ltrim(substr(stored, 21), '0123456789')))
+		ELSE '' END
+	|| '+00:00'
+FROM (
+	SELECT created_at AS stored FROM users
+	UNION SELECT updated_at FROM users
+	UNION SELECT created_at FROM rooms
+	UNION SELECT joined_at FROM room_members
+	UNION SELECT created_at FROM messages
+	UNION SELECT edited_at FROM messages
+	UNION SELECT deleted_at FROM messages
+	UNION SELECT last_reply_at FROM messages
+	UNION SELECT created_at FROM message_revisions
+	UNION SELECT created_at FROM reactions
+	UNION SELECT created_at FROM attachments
+	UNION SELECT created_at FROM sessions
+	UNION SELECT revoked_at FROM sessions
+	UNION SELECT expires_at FROM refresh_tokens
+	UNION SELECT used_at FROM refresh_tokens
+	UNION SELECT created_at FROM refresh_tokens
+	UNION SELECT updated_at FROM read_markers
+) AS timestamps
+WHERE strftime('%s', stored) IS NOT NULL;
+
+UPDATE users SET
+	created_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = users.created_at), created_at),
+	updated_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = users.updated_at), updated_at);
+UPDATE rooms SET created_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = rooms.created_at), created_at);
+UPDATE room_members SET joined_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = room_members.joined_at), joined_at);
+UPDATE messages SET
+	created_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = messages.created_at), created_at),
+	edited_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = messages.edited_at), edited_at),
+	deleted_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = messages.deleted_at), deleted_at),
+	last_reply_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = messages.last_reply_at), last_reply_at);
+UPDATE message_revisions SET created_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = message_revisions.created_at), created_at);
+UPDATE reactions SET created_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = reactions.created_at), created_at);
+UPDATE attachments SET created_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = attachments.created_at), created_at);
+UPDATE sessions SET
+	created_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = sessions.created_at), created_at),
+	revoked_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = sessions.revoked_at), revoked_at);
+UPDATE refresh_tokens SET
+	expires_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = refresh_tokens.expires_at), expires_at),
+	used_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = refresh_tokens.used_at), used_at),
+	created_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = refresh_tokens.created_at), created_at);
+UPDATE read_markers SET updated_at = COALESCE((SELECT converted FROM utc_timestamps WHERE stored = read_markers.updated_at), updated_at);
+
+DROP TABLE utc_timestamps;
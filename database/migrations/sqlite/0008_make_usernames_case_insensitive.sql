@@ -0,0 +1,4 @@
+-- Usernames and emails are unique whatever their case. Creating the indexes
+-- fails if existing accounts differ only in case; rename one of them first.
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower ON users (LOWER(username));
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower ON users (LOWER(email));
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gochat/models"
+)
+
+// reactionRepository handles database operations for message reactions
+type reactionRepository struct {
+	db *DB
+	mu sync.RWMutex // for thread safety
+}
+
+// NewReactionRepository creates a new reaction repository
+func NewReactionRepository(db *DB) ReactionRepository {
+	return &reactionRepository{
+		db: db,
+	}
+}
+
+// AddReaction records a user's reaction to a message. It reports whether the
+// reaction is new.
+func (r *reactionRepository) AddReaction(messageID, userID int64, emoji string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result, err := r.db.Exec(`
+		INSERT INTO reactions (message_id, user_id, emoji, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, messageID, userID, emoji, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("insert reaction: %w", err)
+	}
+
+	added, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get rows affected: %w", err)
+	}
+
+	return added > 0, nil
+}
+
+// RemoveReaction deletes a user's reaction to a message. It reports whether
+// the reaction existed.
+func (r *reactionRepository) RemoveReaction(messageID, userID int64, emoji string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result, err := r.db.Exec(`
+		DELETE FROM reactions
+		WHERE message_id = ? AND user_id = ? AND emoji = ?
+	`, messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("delete reaction: %w", err)
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get rows affected: %w", err)
+	}
+
+	return removed > 0, nil
+}
+
+// GetReactionCounts aggregates the reactions of the given messages by emoji,
+// in the order each emoji was first used. Messages without reactions are
+// absent from the result.
+func (r *reactionRepository) GetReactionCounts(messageIDs []int64) (map[int64][]models.ReactionCount, error) {
+	counts := make(map[int64][]models.ReactionCount)
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	placeholders, args := inClause(messageIDs)
+	rows, err := r.db.Query(`
+		SELECT message_id, emoji, COUNT(*)
+		FROM reactions
+		WHERE message_id IN (`+placeholders+`)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at), emoji
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query reaction counts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int64
+		var count models.ReactionCount
+		if err := rows.Scan(&messageID, &count.Emoji, &count.Count); err != nil {
+			return nil, fmt.Errorf("scan reaction count: %w", err)
+		}
+		counts[messageID] = append(counts[messageID], count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate reaction counts: %w", err)
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gochat/models"
+)
+
+// readMarkerRepository handles database operations for read markers
+type readMarkerRepository struct {
+	db *DB
+	mu sync.RWMutex // for thread safety
+}
+
+// NewReadMarkerRepository creates a new read marker repository
+func NewReadMarkerRepository(db *DB) ReadMarkerRepository {
+	return &readMarkerRepository{
+		db: db,
+	}
+}
+
+// MarkRead moves a user's read marker in a conversation forward to
+// messageID. Markers never move backwards; the stored marker is returned.
+func (r *readMarkerRepository) MarkRead(userID, roomID, peerID, messageID int64) (*models.ReadMarker, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.db.Exec(`
+		INSERT INTO read_markers (user_id, room_id, peer_id, last_read_message_id, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, room_id, peer_id) DO UPDATE SET
+			last_read_message_id = CASE
+				WHEN excluded.last_read_message_id > read_markers.last_read_message_id THEN excluded.last_read_message_id
+				ELSE read_markers.last_read_message_id
+			END,
+			updated_at = excluded.updated_at
+	`, userID, roomID, peerID, messageID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("upsert read marker: %w", err)
+	}
+
+	marker := models.ReadMarker{UserID: userID, RoomID: roomID, PeerID: peerID}
+	err = r.db.QueryRow(`
+		SELECT last_read_message_id, updated_at
+		FROM read_markers
+		WHERE user_id = ? AND room_id = ? AND peer_id = ?
+	`, userID, roomID, peerID).Scan(&marker.LastReadMessageID, &marker.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("query read marker: %w", err)
+	}
+
+	return &marker, nil
+}
+
+// GetUnreadCounts counts the messages from other users after the user's
+// read marker in the global channel, every room they belong to and every
+// direct conversation. Only messages sent since the user registered, or
+// since they joined the room, are counted. Thread replies are left out like
+// in the history the markers refer to. Conversations without unread messages
+// are omitted.
+func (r *readMarkerRepository) GetUnreadCounts(userID int64) ([]*models.UnreadCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rows, err := r.db.Query(`
+		SELECT 0 AS room_id, 0 AS peer_id, COUNT(m.id), COALESCE(rm.last_read_message_id, 0)
+		FROM users u
+		JOIN messages m ON m.created_at >= u.created_at
+		LEFT JOIN read_markers rm ON rm.user_id = u.id AND rm.room_id = 0 AND rm.peer_id = 0
+		WHERE u.id = ? AND m.room_id IS NULL AND m.recipient_id IS NULL
+			AND m.user_id != u.id AND m.id > COALESCE(rm.last_read_message_id, 0)
+			AND m.deleted_at IS NULL AND m.parent_id IS NULL
+		GROUP BY rm.last_read_message_id
+
+		UNION ALL
+
+		SELECT m.room_id, 0, COUNT(m.id), COALESCE(rm.last_read_message_id, 0)
+		FROM room_members mem
+		JOIN messages m ON m.room_id = mem.room_id AND m.created_at >= mem.joined_at
+		LEFT JOIN read_markers rm ON rm.user_id = mem.user_id AND rm.room_id = mem.room_id AND rm.peer_id = 0
+		WHERE mem.user_id = ?
+			AND m.user_id != mem.user_id AND m.id > COALESCE(rm.last_read_message_id, 0)
+			AND m.deleted_at IS NULL AND m.parent_id IS NULL
+		GROUP BY m.room_id, rm.last_read_message_id
+
+		UNION ALL
+
+		SELECT 0, m.user_id, COUNT(m.id), COALESCE(rm.last_read_message_id, 0)
+		FROM messages m
+		LEFT JOIN read_markers rm ON rm.user_id = m.recipient_id AND rm.room_id = 0 AND rm.peer_id = m.user_id
+		WHERE m.recipient_id = ?
+			AND m.id > COALESCE(rm.last_read_message_id, 0)
+			AND m.deleted_at IS NULL AND m.parent_id IS NULL
+		GROUP BY m.user_id, rm.last_read_message_id
+	`, userID, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query unread counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []*models.UnreadCount{}
+	for rows.Next() {
+		var count models.UnreadCount
+		if err := rows.Scan(&count.RoomID, &count.PeerID, &count.Count, &count.LastReadMessageID); err != nil {
+			return nil, fmt.Errorf("scan unread count: %w", err)
+		}
+		if count.Count > 0 {
+			counts = append(counts, &count)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate unread counts: %w", err)
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"time"
+
+	"gochat/models"
+)
+
+// The repository interfaces below are implemented for every supported
+// driver. Lookups of missing rows fail with an error wrapping sql.ErrNoRows.
+
+// UserRepository stores user accounts
+type UserRepository interface {
+	CreateUser(user *models.User) error
+	GetUserByUsername(username string) (*models.User, error)
+	GetUserByID(id int64) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	UpdateUserStatus(id int64, status string) error
+	MarkAllOffline() error
+	UpdateUserProfile(user *models.User) error
+	ListUsers(filter models.UserFilter) ([]*models.User, int, error)
+}
+
+// MessageRepository stores chat messages and their revisions
+type MessageRepository interface {
+	CreateMessage(message *models.Message) error
+	GetMessageByID(id int64) (*models.Message, error)
+	ListMessages(roomID, beforeID, afterID int64, limit int) ([]*models.Message, error)
+	ListDirectMessages(userID, peerID, beforeID, afterID int64, limit int) ([]*models.Message, error)
+	ListReplies(parentID, beforeID, afterID int64, limit int) ([]*models.Message, error)
+	EditMessage(id, editorID int64, content string) (*models.Message, error)
+	DeleteMessage(id, deletedBy int64) (*models.Message, error)
+	ListRevisions(messageID int64) ([]*models.MessageRevision, error)
+	SearchMessages(search models.MessageSearch) ([]*models.SearchResult, error)
+}
+
+// RoomRepository stores chat rooms and their members
+type RoomRepository interface {
+	CreateRoom(room *models.Room, creatorID int64) error
+	GetRoomByID(id int64) (*models.Room, error)
+	GetRoomByName(name string) (*models.Room, error)
+	ListRooms() ([]*models.Room, error)
+	AddMember(roomID, userID int64) error
+	RemoveMember(roomID, userID int64) error
+	IsMember(roomID, userID int64) (bool, error)
+	GetMemberIDs(roomID int64) ([]int64, error)
+}
+
+// SessionRepository stores login sessions and refresh tokens
+type SessionRepository interface {
+	CreateSession(session *models.Session) error
+	GetSession(id string) (*models.Session, error)
+	RevokeSession(id string) error
+	CreateRefreshToken(token *models.RefreshToken) error
+	GetRefreshToken(tokenHash string) (*models.RefreshToken, error)
+	MarkRefreshTokenUsed(tokenHash string) (bool, error)
+}
+
+// ReadMarkerRepository stores how far users have read each conversation
+type ReadMarkerRepository interface {
+	MarkRead(userID, roomID, peerID, messageID int64) (*models.ReadMarker, error)
+	GetUnreadCounts(userID int64) ([]*models.UnreadCount, error)
+}
+
+// ReactionRepository stores emoji reactions to messages
+type ReactionRepository interface {
+	AddReaction(messageID, userID int64, emoji string) (bool, error)
+	RemoveReaction(messageID, userID int64, emoji string) (bool, error)
+	GetReactionCounts(messageIDs []int64) (map[int64][]models.ReactionCount, error)
+}
+
+// AttachmentRepository stores the metadata of uploaded files
+type AttachmentRepository interface {
+	CreateAttachment(attachment *models.Attachment) error
+	GetAttachmentByID(id int64) (*models.Attachment, error)
+	AttachToMessage(ids []int64, userID, messageID int64) (int, error)
+	GetAttachmentsForMessages(messageIDs []int64) (map[int64][]*models.Attachment, error)
+	DeletePendingAttachments(before time.Time) ([]*models.Attachment, error)
+}
+
+// Repositories bundles the repositories of one database
+type Repositories struct {
+	Users       UserRepository
+	Messages    MessageRepository
+	Rooms       RoomRepository
+	Sessions    SessionRepository
+	ReadMarkers ReadMarkerRepository
+	Reactions   ReactionRepository
+	Attachments AttachmentRepository
+}
+
+// NewRepositories creates the repositories backed by db
+func NewRepositories(db *DB) *Repositories {
+	return &Repositories{
+		Users:       NewUserRepository(db),
+		Messages:    NewMessageRepository(db),
+		Rooms:       NewRoomRepository(db),
+		Sessions:    NewSessionRepository(db),
+		ReadMarkers: NewReadMarkerRepository(db),
+		Reactions:   NewReactionRepository(db),
+		Attachments: NewAttachmentRepository(db),
+	}
+}
@@ -0,0 +1,666 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gochat/database"
+	"gochat/models"
+)
+
+// openFunc creates the repositories of an empty database for one test
+type openFunc func(t *testing.T) *database.Repositories
+
+// runRepositorySuite runs the repository tests shared by every implementation
+func runRepositorySuite(t *testing.T, open openFunc) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repos *database.Repositories)
+	}{
+		{"Users", testUsers},
+		{"Rooms", testRooms},
+		{"Messages", testMessages},
+		{"Revisions", testRevisions},
+		{"Search", testSearch},
+		{"Sessions", testSessions},
+		{"ReadMarkers", testReadMarkers},
+		{"Reactions", testReactions},
+		{"Attachments", testAttachments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, open(t))
+		})
+	}
+}
+
+// createUser stores a user with a derived email address
+func createUser(t *testing.T, repos *database.Repositories, username string) *models.User {
+	t.Helper()
+
+	user := &models.User{Username: username, Email: username + "@example.com", Password: "hash", Status: "offline"}
+	if err := repos.Users.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser(%s): %v", username, err)
+	}
+	return user
+}
+
+// createRoom stores a room created by the first of its members
+func createRoom(t *testing.T, repos *database.Repositories, name string, creator *models.User, members ...*models.User) *models.Room {
+	t.Helper()
+
+	room := &models.Room{Name: name}
+	if err := repos.Rooms.CreateRoom(room, creator.ID); err != nil {
+		t.Fatalf("CreateRoom(%s): %v", name, err)
+	}
+	for _, member := range members {
+		if err := repos.Rooms.AddMember(room.ID, member.ID); err != nil {
+			t.Fatalf("AddMember: %v", err)
+		}
+	}
+	return room
+}
+
+// postMessage stores a message; ids of zero leave the field unset
+func postMessage(t *testing.T, repos *database.Repositories, author *models.User, roomID, recipientID, parentID int64, content string) *models.Message {
+	t.Helper()
+
+	message := &models.Message{UserID: author.ID, RoomID: roomID, RecipientID: recipientID, ParentID: parentID, Content: content}
+	if err := repos.Messages.CreateMessage(message); err != nil {
+		t.Fatalf("CreateMessage(%q): %v", content, err)
+	}
+	return message
+}
+
+// messageIDs returns the IDs of messages in order
+func messageIDs(messages []*models.Message) []int64 {
+	ids := make([]int64, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	return ids
+}
+
+// equalIDs reports whether two ID lists are identical
+func equalIDs(a, b []int64) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func testUsers(t *testing.T, repos *database.Repositories) {
+	alice := createUser(t, repos, "alice")
+	createUser(t, repos, "Alfred")
+	bob := createUser(t, repos, "bob")
+
+	if alice.ID == 0 || alice.ID == bob.ID {
+		t.Fatalf("CreateUser assigned IDs %d and %d", alice.ID, bob.ID)
+	}
+
+	// Unique fields are reported by name
+	var duplicate *models.DuplicateError
+	err := repos.Users.CreateUser(&models.User{Username: "alice", Email: "other@example.com", Password: "hash"})
+	if !errors.As(err, &duplicate) || duplicate.Field != "username" {
+		t.Errorf("CreateUser(duplicate username) error = %v, want a duplicate username", err)
+	}
+	err = repos.Users.CreateUser(&models.User{Username: "alice2", Email: "alice@example.com", Password: "hash"})
+	if !errors.As(err, &duplicate) || duplicate.Field != "email" {
+		t.Errorf("CreateUser(duplicate email) error = %v, want a duplicate email", err)
+	}
+
+	// Uniqueness ignores case
+	err = repos.Users.CreateUser(&models.User{Username: "ALICE", Email: "other@example.com", Password: "hash"})
+	if !errors.As(err, &duplicate) || duplicate.Field != "username" {
+		t.Errorf("CreateUser(username in other case) error = %v, want a duplicate username", err)
+	}
+	err = repos.Users.CreateUser(&models.User{Username: "alice2", Email: "Alice@Example.com", Password: "hash"})
+	if !errors.As(err, &duplicate) || duplicate.Field != "email" {
+		t.Errorf("CreateUser(email in other case) error = %v, want a duplicate email", err)
+	}
+
+	got, err := repos.Users.GetUserByUsername("alice")
+	if err != nil || got.ID != alice.ID || got.Email != "alice@example.com" {
+		t.Errorf("GetUserByUsername = %+v, %v", got, err)
+	}
+	if got, err := repos.Users.GetUserByUsername("Alice"); err != nil || got.ID != alice.ID {
+		t.Errorf("GetUserByUsername(Alice) = %+v, %v", got, err)
+	}
+	if got, err := repos.Users.GetUserByID(bob.ID); err != nil || got.Username != "bob" {
+		t.Errorf("GetUserByID = %+v, %v", got, err)
+	}
+	if got, err := repos.Users.GetUserByEmail("Bob@Example.com"); err != nil || got.ID != bob.ID {
+		t.Errorf("GetUserByEmail = %+v, %v", got, err)
+	}
+	if _, err := repos.Users.GetUserByID(9999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByID(missing) error = %v, want sql.ErrNoRows", err)
+	}
+
+	if err := repos.Users.UpdateUserStatus(alice.ID, "online"); err != nil {
+		t.Fatalf("UpdateUserStatus: %v", err)
+	}
+
+	// Prefix matching ignores case and treats wildcards literally
+	users, total, err := repos.Users.ListUsers(models.UserFilter{UsernamePrefix: "AL", Limit: 10})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if total != 2 || len(users) != 2 || users[0].Username != "Alfred" || users[1].Username != "alice" {
+		t.Errorf("ListUsers(AL) = %d users, total %d", len(users), total)
+	}
+	if _, total, _ := repos.Users.ListUsers(models.UserFilter{UsernamePrefix: "a%", Limit: 10}); total != 0 {
+		t.Errorf("ListUsers(a%%) total = %d, want 0", total)
+	}
+	users, total, err = repos.Users.ListUsers(models.UserFilter{Status: "online", Limit: 10})
+	if err != nil || total != 1 || users[0].ID != alice.ID {
+		t.Errorf("ListUsers(online) = %v users, total %d, %v", len(users), total, err)
+	}
+	users, total, _ = repos.Users.ListUsers(models.UserFilter{Limit: 1, Offset: 1})
+	if total != 3 || len(users) != 1 || users[0].Username != "alice" {
+		t.Errorf("ListUsers(page 2) = %d users, total %d", len(users), total)
+	}
+
+	taken := *bob
+	taken.Username = "Alice"
+	if err := repos.Users.UpdateUserProfile(&taken); !errors.As(err, &duplicate) || duplicate.Field != "username" {
+		t.Errorf("UpdateUserProfile(taken username) error = %v, want a duplicate username", err)
+	}
+
+	bob.Username = "robert"
+	bob.Email = "robert@example.com"
+	if err := repos.Users.UpdateUserProfile(bob); err != nil {
+		t.Fatalf("UpdateUserProfile: %v", err)
+	}
+	if got, err := repos.Users.GetUserByUsername("robert"); err != nil || got.Email != "robert@example.com" {
+		t.Errorf("GetUserByUsername after update = %+v, %v", got, err)
+	}
+
+	// Statuses left behind by a crash are reset at startup
+	if err := repos.Users.UpdateUserStatus(bob.ID, "away"); err != nil {
+		t.Fatalf("UpdateUserStatus: %v", err)
+	}
+	if err := repos.Users.MarkAllOffline(); err != nil {
+		t.Fatalf("MarkAllOffline: %v", err)
+	}
+	if users, total, err := repos.Users.ListUsers(models.UserFilter{Status: "offline", Limit: 10}); err != nil || total != 3 {
+		t.Errorf("ListUsers(offline) after MarkAllOffline = %d users, total %d, %v; want everyone", len(users), total, err)
+	}
+}
+
+func testRooms(t *testing.T, repos *database.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	general := createRoom(t, repos, "general", alice, bob)
+	random := createRoom(t, repos, "random", bob)
+
+	// The creator is the first member
+	if ids, err := repos.Rooms.GetMemberIDs(random.ID); err != nil || len(ids) != 1 || ids[0] != bob.ID {
+		t.Errorf("GetMemberIDs(new room) = %v, %v; want the creator", ids, err)
+	}
+
+	var duplicate *models.DuplicateError
+	if err := repos.Rooms.CreateRoom(&models.Room{Name: "general"}, bob.ID); !errors.As(err, &duplicate) || duplicate.Field != "name" {
+		t.Errorf("CreateRoom(duplicate name) error = %v, want a duplicate name", err)
+	}
+
+	if got, err := repos.Rooms.GetRoomByName("general"); err != nil || got.ID != general.ID {
+		t.Errorf("GetRoomByName = %+v, %v", got, err)
+	}
+	if _, err := repos.Rooms.GetRoomByID(9999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetRoomByID(missing) error = %v, want sql.ErrNoRows", err)
+	}
+	if rooms, err := repos.Rooms.ListRooms(); err != nil || len(rooms) != 2 {
+		t.Errorf("ListRooms = %d rooms, %v", len(rooms), err)
+	}
+
+	// Joining twice is a no-op
+	if err := repos.Rooms.AddMember(general.ID, alice.ID); err != nil {
+		t.Fatalf("AddMember again: %v", err)
+	}
+	ids, err := repos.Rooms.GetMemberIDs(general.ID)
+	if err != nil || len(ids) != 2 {
+		t.Errorf("GetMemberIDs = %v, %v", ids, err)
+	}
+
+	if err := repos.Rooms.RemoveMember(general.ID, bob.ID); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	if member, err := repos.Rooms.IsMember(general.ID, alice.ID); err != nil || !member {
+		t.Errorf("IsMember(alice) = %v, %v", member, err)
+	}
+	if member, err := repos.Rooms.IsMember(general.ID, bob.ID); err != nil || member {
+		t.Errorf("IsMember(bob) = %v, %v", member, err)
+	}
+}
+
+func testMessages(t *testing.T, repos *database.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	room := createRoom(t, repos, "general", alice, bob)
+
+	var global []int64
+	for i := 0; i < 5; i++ {
+		global = append(global, postMessage(t, repos, alice, 0, 0, 0, fmt.Sprintf("global %d", i)).ID)
+	}
+	inRoom := postMessage(t, repos, bob, room.ID, 0, 0, "in the room")
+	direct := postMessage(t, repos, alice, 0, bob.ID, 0, "just for bob")
+	reply := postMessage(t, repos, bob, 0, 0, global[0], "a reply")
+
+	page, err := repos.Messages.ListMessages(0, 0, 0, 3)
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	if want := global[2:]; !equalIDs(messageIDs(page), want) {
+		t.Errorf("ListMessages newest page = %v, want %v", messageIDs(page), want)
+	}
+	if page[0].Username != "alice" || page[0].Content != "global 2" {
+		t.Errorf("ListMessages message = %+v", page[0])
+	}
+
+	page, _ = repos.Messages.ListMessages(0, global[2], 0, 10)
+	if want := global[:2]; !equalIDs(messageIDs(page), want) {
+		t.Errorf("ListMessages before = %v, want %v", messageIDs(page), want)
+	}
+	page, _ = repos.Messages.ListMessages(0, 0, global[1], 2)
+	if want := global[2:4]; !equalIDs(messageIDs(page), want) {
+		t.Errorf("ListMessages after = %v, want %v", messageIDs(page), want)
+	}
+
+	// Cursors are 64-bit IDs
+	page, err = repos.Messages.ListMessages(0, 1<<40, 0, 10)
+	if err != nil {
+		t.Fatalf("ListMessages(large cursor): %v", err)
+	}
+	if !equalIDs(messageIDs(page), global) {
+		t.Errorf("ListMessages before a large cursor = %v, want %v", messageIDs(page), global)
+	}
+	if page, err = repos.Messages.ListMessages(0, 0, 1<<40, 10); err != nil || len(page) != 0 {
+		t.Errorf("ListMessages after a large cursor = %v, %v; want none", messageIDs(page), err)
+	}
+
+	page, _ = repos.Messages.ListMessages(room.ID, 0, 0, 10)
+	if want := []int64{inRoom.ID}; !equalIDs(messageIDs(page), want) {
+		t.Errorf("ListMessages(room) = %v, want %v", messageIDs(page), want)
+	}
+
+	page, _ = repos.Messages.ListDirectMessages(bob.ID, alice.ID, 0, 0, 10)
+	if want := []int64{direct.ID}; !equalIDs(messageIDs(page), want) {
+		t.Errorf("ListDirectMessages = %v, want %v", messageIDs(page), want)
+	}
+
+	// Replies stay out of the channel and update the parent's summary
+	page, _ = repos.Messages.ListReplies(global[0], 0, 0, 10)
+	if want := []int64{reply.ID}; !equalIDs(messageIDs(page), want) {
+		t.Errorf("ListReplies = %v, want %v", messageIDs(page), want)
+	}
+	parent, err := repos.Messages.GetMessageByID(global[0])
+	if err != nil {
+		t.Fatalf("GetMessageByID: %v", err)
+	}
+	if parent.ReplyCount != 1 || parent.LastReplyAt == nil {
+		t.Errorf("parent reply summary = %d, %v", parent.ReplyCount, parent.LastReplyAt)
+	}
+
+	// Deleting replies rolls the summary back to the remaining ones
+	later := postMessage(t, repos, alice, 0, 0, global[0], "a later reply")
+	if _, err := repos.Messages.DeleteMessage(later.ID, alice.ID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	parent, _ = repos.Messages.GetMessageByID(global[0])
+	if parent.ReplyCount != 1 || parent.LastReplyAt == nil || parent.LastReplyAt.Sub(reply.CreatedAt).Abs() > time.Millisecond {
+		t.Errorf("parent reply summary after deleting the later reply = %d, %v; want 1, %v", parent.ReplyCount, parent.LastReplyAt, reply.CreatedAt)
+	}
+	if _, err := repos.Messages.DeleteMessage(reply.ID, bob.ID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	parent, _ = repos.Messages.GetMessageByID(global[0])
+	if parent.ReplyCount != 0 || parent.LastReplyAt != nil {
+		t.Errorf("parent reply summary after deleting every reply = %d, %v", parent.ReplyCount, parent.LastReplyAt)
+	}
+
+	if _, err := repos.Messages.GetMessageByID(9999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetMessageByID(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testRevisions(t *testing.T, repos *database.Repositories) {
+	alice := createUser(t, repos, "alice")
+	moderator := createUser(t, repos, "mod")
+	message := postMessage(t, repos, alice, 0, 0, 0, "first draft")
+
+	edited, err := repos.Messages.EditMessage(message.ID, alice.ID, "second draft")
+	if err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	if edited.Content != "second draft" || edited.EditedAt == nil {
+		t.Errorf("EditMessage = %+v", edited)
+	}
+
+	deleted, err := repos.Messages.DeleteMessage(message.ID, moderator.ID)
+	if err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if deleted.Content != "" || deleted.DeletedAt == nil {
+		t.Errorf("DeleteMessage = %+v", deleted)
+	}
+
+	revisions, err := repos.Messages.ListRevisions(message.ID)
+	if err != nil {
+		t.Fatalf("ListRevisions: %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("ListRevisions = %d revisions, want 2", len(revisions))
+	}
+	if r := revisions[0]; r.Action != models.RevisionEdit || r.Content != "first draft" || r.ChangedBy != alice.ID {
+		t.Errorf("edit revision = %+v", r)
+	}
+	if r := revisions[1]; r.Action != models.RevisionDelete || r.Content != "second draft" || r.ChangedBy != moderator.ID {
+		t.Errorf("delete revision = %+v", r)
+	}
+
+	// A deleted message stays deleted: a second deletion or a late edit,
+	// as when they race the first deletion, fails and adds no revision
+	racy := postMessage(t, repos, alice, 0, 0, 0, "racy")
+	if _, err := repos.Messages.DeleteMessage(racy.ID, alice.ID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if _, err := repos.Messages.DeleteMessage(racy.ID, moderator.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteMessage(deleted) error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := repos.Messages.EditMessage(racy.ID, alice.ID, "resurrected"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("EditMessage(deleted) error = %v, want sql.ErrNoRows", err)
+	}
+	revisions, _ = repos.Messages.ListRevisions(racy.ID)
+	if len(revisions) != 1 || revisions[0].Content != "racy" {
+		t.Errorf("ListRevisions after changing a deleted message = %+v, want the deletion only", revisions)
+	}
+	stored, _ := repos.Messages.GetMessageByID(racy.ID)
+	if stored.Content != "" || stored.DeletedAt == nil || stored.EditedAt != nil {
+		t.Errorf("deleted message after late changes = %+v", stored)
+	}
+
+	if _, err := repos.Messages.EditMessage(9999, alice.ID, "nothing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("EditMessage(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testSearch(t *testing.T, repos *database.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	carol := createUser(t, repos, "carol")
+	room := createRoom(t, repos, "secret", alice)
+
+	public := postMessage(t, repos, alice, 0, 0, 0, "deploying the release tonight")
+	private := postMessage(t, repos, alice, room.ID, 0, 0, "release notes are in the room")
+	direct := postMessage(t, repos, bob, 0, alice.ID, 0, "is the release <ready>?")
+	gone := postMessage(t, repos, alice, 0, 0, 0, "release cancelled")
+	if _, err := repos.Messages.DeleteMessage(gone.ID, alice.ID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+
+	search := func(userID int64, query string) map[int64]string {
+		t.Helper()
+		results, err := repos.Messages.SearchMessages(models.MessageSearch{UserID: userID, Query: query, Limit: 10})
+		if err != nil {
+			t.Fatalf("SearchMessages(%q): %v", query, err)
+		}
+		found := make(map[int64]string, len(results))
+		for _, result := range results {
+			found[result.Message.ID] = result.Snippet
+		}
+		return found
+	}
+
+	// Terms match case-insensitively as word prefixes, within what the user can see
+	found := search(alice.ID, "RELEASE")
+	if len(found) != 3 || found[public.ID] == "" || found[private.ID] == "" || found[direct.ID] == "" {
+		t.Errorf("alice found %v", found)
+	}
+	if found := search(carol.ID, "releas"); len(found) != 1 || found[public.ID] == "" {
+		t.Errorf("carol found %v", found)
+	}
+	if found := search(alice.ID, "release tonight"); len(found) != 1 {
+		t.Errorf("all terms must match, found %v", found)
+	}
+
+	// Snippets are escaped HTML with the matches marked
+	if snippet := found[direct.ID]; !strings.Contains(snippet, "<mark>release</mark>") || strings.Contains(snippet, "<ready>") {
+		t.Errorf("snippet = %q", snippet)
+	}
+
+	// Date bounds compare instants, whatever the offsets of the stored times
+	// and of the bounds
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	early := &models.Message{UserID: alice.ID, Content: "archived early", CreatedAt: base.In(time.FixedZone("east", 5*3600))}
+	late := &models.Message{UserID: alice.ID, Content: "archived late", CreatedAt: base.Add(time.Hour).In(time.FixedZone("west", -8*3600))}
+	for _, message := range []*models.Message{early, late} {
+		if err := repos.Messages.CreateMessage(message); err != nil {
+			t.Fatalf("CreateMessage(%q): %v", message.Content, err)
+		}
+	}
+	bound := base.Add(30 * time.Minute).In(time.FixedZone("bound", 2*3600))
+	for _, tt := range []struct {
+		search models.MessageSearch
+		want   int64
+	}{
+		{models.MessageSearch{From: bound}, late.ID},
+		{models.MessageSearch{To: bound}, early.ID},
+	} {
+		tt.search.UserID, tt.search.Query, tt.search.Limit = alice.ID, "archived", 10
+		results, err := repos.Messages.SearchMessages(tt.search)
+		if err != nil {
+			t.Fatalf("SearchMessages: %v", err)
+		}
+		if len(results) != 1 || results[0].Message.ID != tt.want {
+			t.Errorf("from %v to %v: found %d results, want message %d", tt.search.From, tt.search.To, len(results), tt.want)
+		}
+	}
+}
+
+func testSessions(t *testing.T, repos *database.Repositories) {
+	alice := createUser(t, repos, "alice")
+
+	session := &models.Session{ID: "session-1", UserID: alice.ID}
+	if err := repos.Sessions.CreateSession(session); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	token := &models.RefreshToken{TokenHash: "hash-1", SessionID: session.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repos.Sessions.CreateRefreshToken(token); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	got, err := repos.Sessions.GetRefreshToken("hash-1")
+	if err != nil || got.UserID != alice.ID || got.UsedAt != nil {
+		t.Errorf("GetRefreshToken = %+v, %v", got, err)
+	}
+
+	// A refresh token can be used once
+	if used, err := repos.Sessions.MarkRefreshTokenUsed("hash-1"); err != nil || !used {
+		t.Errorf("first MarkRefreshTokenUsed = %v, %v", used, err)
+	}
+	if used, err := repos.Sessions.MarkRefreshTokenUsed("hash-1"); err != nil || used {
+		t.Errorf("second MarkRefreshTokenUsed = %v, %v", used, err)
+	}
+
+	if err := repos.Sessions.RevokeSession(session.ID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if got, err := repos.Sessions.GetSession(session.ID); err != nil || got.RevokedAt == nil {
+		t.Errorf("GetSession after revoke = %+v, %v", got, err)
+	}
+	if _, err := repos.Sessions.GetSession("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSession(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testReadMarkers(t *testing.T, repos *database.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	room := createRoom(t, repos, "general", alice, bob)
+
+	first := postMessage(t, repos, bob, 0, 0, 0, "one")
+	postMessage(t, repos, bob, 0, 0, 0, "two")
+	postMessage(t, repos, alice, 0, 0, 0, "own messages are never unread")
+	roomMessage := postMessage(t, repos, bob, room.ID, 0, 0, "room")
+	direct := postMessage(t, repos, bob, 0, alice.ID, 0, "direct")
+
+	// Thread replies are not part of the history markers refer to
+	postMessage(t, repos, bob, 0, 0, first.ID, "reply in the channel")
+	postMessage(t, repos, bob, room.ID, 0, roomMessage.ID, "reply in the room")
+	postMessage(t, repos, bob, 0, alice.ID, direct.ID, "reply to the direct message")
+
+	marker, err := repos.ReadMarkers.MarkRead(alice.ID, 0, 0, first.ID)
+	if err != nil || marker.LastReadMessageID != first.ID {
+		t.Fatalf("MarkRead = %+v, %v", marker, err)
+	}
+	if _, err := repos.ReadMarkers.MarkRead(alice.ID, room.ID, 0, roomMessage.ID); err != nil {
+		t.Fatalf("MarkRead(room): %v", err)
+	}
+
+	// Markers never move backwards
+	if marker, err := repos.ReadMarkers.MarkRead(alice.ID, room.ID, 0, 0); err != nil || marker.LastReadMessageID != roomMessage.ID {
+		t.Errorf("MarkRead backwards = %+v, %v", marker, err)
+	}
+
+	// Messages from before carol registered or joined the room are not hers
+	// to read
+	carol := createUser(t, repos, "carol")
+	postMessage(t, repos, bob, 0, 0, 0, "welcome carol")
+	if err := repos.Rooms.AddMember(room.ID, carol.ID); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+	postMessage(t, repos, bob, room.ID, 0, 0, "carol is here")
+
+	unread := func(user *models.User) string {
+		t.Helper()
+		counts, err := repos.ReadMarkers.GetUnreadCounts(user.ID)
+		if err != nil {
+			t.Fatalf("GetUnreadCounts(%s): %v", user.Username, err)
+		}
+		got := make(map[string]int)
+		for _, count := range counts {
+			got[fmt.Sprintf("room %d peer %d", count.RoomID, count.PeerID)] = count.Count
+		}
+		return fmt.Sprint(got)
+	}
+
+	for _, tt := range []struct {
+		user *models.User
+		want map[string]int
+	}{
+		{alice, map[string]int{
+			"room 0 peer 0":                        2,
+			fmt.Sprintf("room %d peer 0", room.ID): 1,
+			fmt.Sprintf("room 0 peer %d", bob.ID):  1,
+		}},
+		{carol, map[string]int{
+			"room 0 peer 0":                        1,
+			fmt.Sprintf("room %d peer 0", room.ID): 1,
+		}},
+	} {
+		if got := unread(tt.user); got != fmt.Sprint(tt.want) {
+			t.Errorf("GetUnreadCounts(%s) = %v, want %v", tt.user.Username, got, tt.want)
+		}
+	}
+}
+
+func testReactions(t *testing.T, repos *database.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+	message := postMessage(t, repos, alice, 0, 0, 0, "react to me")
+	quiet := postMessage(t, repos, alice, 0, 0, 0, "nobody reacts")
+
+	for _, r := range []struct {
+		user  *models.User
+		emoji string
+		added bool
+	}{
+		{alice, "👍", true},
+		{bob, "👍", true},
+		{bob, "🎉", true},
+		{bob, "👍", false},
+	} {
+		if added, err := repos.Reactions.AddReaction(message.ID, r.user.ID, r.emoji); err != nil || added != r.added {
+			t.Errorf("AddReaction(%s, %s) = %v, %v, want %v", r.user.Username, r.emoji, added, err, r.added)
+		}
+	}
+
+	counts, err := repos.Reactions.GetReactionCounts([]int64{message.ID, quiet.ID})
+	if err != nil {
+		t.Fatalf("GetReactionCounts: %v", err)
+	}
+	if got := fmt.Sprint(counts[message.ID]); got != "[{👍 2} {🎉 1}]" {
+		t.Errorf("reaction counts = %s", got)
+	}
+	if _, ok := counts[quiet.ID]; ok {
+		t.Error("message without reactions has counts")
+	}
+
+	if removed, err := repos.Reactions.RemoveReaction(message.ID, bob.ID, "🎉"); err != nil || !removed {
+		t.Errorf("RemoveReaction = %v, %v", removed, err)
+	}
+	if removed, err := repos.Reactions.RemoveReaction(message.ID, bob.ID, "🎉"); err != nil || removed {
+		t.Errorf("RemoveReaction again = %v, %v", removed, err)
+	}
+}
+
+func testAttachments(t *testing.T, repos *database.Repositories) {
+	alice := createUser(t, repos, "alice")
+	bob := createUser(t, repos, "bob")
+
+	upload := func(owner *models.User, name string) *models.Attachment {
+		t.Helper()
+		attachment := &models.Attachment{UserID: owner.ID, Filename: name, ContentType: "text/plain", Size: 5, StorageKey: "key-" + name}
+		if err := repos.Attachments.CreateAttachment(attachment); err != nil {
+			t.Fatalf("CreateAttachment: %v", err)
+		}
+		return attachment
+	}
+	first := upload(alice, "a.txt")
+	second := upload(alice, "b.txt")
+	foreign := upload(bob, "c.txt")
+
+	if got, err := repos.Attachments.GetAttachmentByID(first.ID); err != nil || got.Filename != "a.txt" || got.URL == "" {
+		t.Errorf("GetAttachmentByID = %+v, %v", got, err)
+	}
+
+	// Only the uploader's unsent attachments are linked
+	message := postMessage(t, repos, alice, 0, 0, 0, "files")
+	linked, err := repos.Attachments.AttachToMessage([]int64{first.ID, second.ID, foreign.ID}, alice.ID, message.ID)
+	if err != nil || linked != 2 {
+		t.Errorf("AttachToMessage = %d, %v, want 2", linked, err)
+	}
+	other := postMessage(t, repos, alice, 0, 0, 0, "again")
+	if linked, _ := repos.Attachments.AttachToMessage([]int64{first.ID}, alice.ID, other.ID); linked != 0 {
+		t.Errorf("AttachToMessage relinked %d attachments", linked)
+	}
+
+	attachments, err := repos.Attachments.GetAttachmentsForMessages([]int64{message.ID, other.ID})
+	if err != nil {
+		t.Fatalf("GetAttachmentsForMessages: %v", err)
+	}
+	if got := attachments[message.ID]; len(got) != 2 || got[0].ID != first.ID || got[1].ID != second.ID {
+		t.Errorf("attachments of message = %v", got)
+	}
+	if _, ok := attachments[other.ID]; ok {
+		t.Error("message without attachments has attachments")
+	}
+
+	// Only uploads left unsent since before the cutoff expire
+	cutoff := time.Now()
+	recent := upload(bob, "d.txt")
+	deleted, err := repos.Attachments.DeletePendingAttachments(cutoff)
+	if err != nil || len(deleted) != 1 || deleted[0].ID != foreign.ID || deleted[0].StorageKey != "key-c.txt" {
+		t.Fatalf("DeletePendingAttachments = %v, %v, want c.txt", deleted, err)
+	}
+	if _, err := repos.Attachments.GetAttachmentByID(foreign.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetAttachmentByID(expired) error = %v, want sql.ErrNoRows", err)
+	}
+	for _, kept := range []*models.Attachment{first, second, recent} {
+		if _, err := repos.Attachments.GetAttachmentByID(kept.ID); err != nil {
+			t.Errorf("GetAttachmentByID(%s): %v", kept.Filename, err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gochat/models"
+)
+
+// roomRepository handles database operations for rooms and their members
+type roomRepository struct {
+	db *DB
+	mu sync.RWMutex // for thread safety
+}
+
+// NewRoomRepository creates a new room repository
+func NewRoomRepository(db *DB) RoomRepository {
+	return &roomRepository{
+		db: db,
+	}
+}
+
+// CreateRoom creates a new room with creatorID as its first member in one
+// transaction, so a room is never left without members
+func (r *roomRepository) CreateRoom(room *models.Room, creatorID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO rooms (name, created_at)
+		VALUES (?, ?)
+		RETURNING id
+	`, room.Name, now).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("execute insert room: %w", duplicateField(err, "name"))
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO room_members (user_id, room_id, joined_at)
+		VALUES (?, ?, ?)
+	`, creatorID, id, now)
+	if err != nil {
+		return fmt.Errorf("insert room member: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	room.ID = id
+	room.CreatedAt = now
+
+	return nil
+}
+
+// GetRoomByID retrieves a room by ID
+func (r *roomRepository) GetRoomByID(id int64) (*models.Room, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var room models.Room
+	err := r.db.QueryRow(`
+		SELECT id, name, created_at
+		FROM rooms
+		WHERE id = ?
+	`, id).Scan(&room.ID, &room.Name, &room.CreatedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("query room by id: %w", err)
+	}
+
+	return &room, nil
+}
+
+// GetRoomByName retrieves a room by name
+func (r *roomRepository) GetRoomByName(name string) (*models.Room, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var room models.Room
+	err := r.db.QueryRow(`
+		SELECT id, name, created_at
+		FROM rooms
+		WHERE name = ?
+	`, name).Scan(&room.ID, &room.Name, &room.CreatedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("query room by name: %w", err)
+	}
+
+	return &room, nil
+}
+
+// ListRooms retrieves all rooms ordered by name
+func (r *roomRepository) ListRooms() ([]*models.Room, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rows, err := r.db.Query(`
+		SELECT id, name, created_at
+		FROM rooms
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query rooms: %w", err)
+	}
+	defer rows.Close()
+
+	rooms := []*models.Room{}
+	for rows.Next() {
+		var room models.Room
+		if err := rows.Scan(&room.ID, &room.Name, &room.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan room: %w", err)
+		}
+		rooms = append(rooms, &room)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate rooms: %w", err)
+	}
+
+	return rooms, nil
+}
+
+// AddMember adds a user to a room; joining twice is a no-op
+func (r *roomRepository) AddMember(roomID, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.db.Exec(`
+		INSERT INTO room_members (user_id, room_id, joined_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`, userID, roomID, time.Now())
+
+	if err != nil {
+		return fmt.Errorf("insert room member: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveMember removes a user from a room
+func (r *roomRepository) RemoveMember(roomID, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.db.Exec(`
+		DELETE FROM room_members
+		WHERE user_id = ? AND room_id = ?
+	`, userID, roomID)
+
+	if err != nil {
+		return fmt.Errorf("delete room member: %w", err)
+	}
+
+	return nil
+}
+
+// IsMember reports whether a user belongs to a room
+func (r *roomRepository) IsMember(roomID, userID int64) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var exists bool
+	err := r.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM room_members
+			WHERE user_id = ? AND room_id = ?
+		)
+	`, userID, roomID).Scan(&exists)
+
+	if err != nil {
+		return false, fmt.Errorf("query room membership: %w", err)
+	}
+
+	return exists, nil
+}
+
+// GetMemberIDs retrieves the IDs of all users in a room
+func (r *roomRepository) GetMemberIDs(roomID int64) ([]int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rows, err := r.db.Query(`
+		SELECT user_id
+		FROM room_members
+		WHERE room_id = ?
+	`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("query room members: %w", err)
+	}
+	defer rows.Close()
+
+	userIDs := []int64{}
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("scan room member: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate room members: %w", err)
+	}
+
+	return userIDs, nil
+}
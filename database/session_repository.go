@@ -0,0 +1,150 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"gochat/models"
+)
+
+// sessionRepository handles database operations for login sessions and refresh tokens
+type sessionRepository struct {
+	db *DB
+	mu sync.RWMutex // for thread safety
+}
+
+// NewSessionRepository creates a new session repository
+func NewSessionRepository(db *DB) SessionRepository {
+	return &sessionRepository{
+		db: db,
+	}
+}
+
+// CreateSession stores a new session
+func (r *sessionRepository) CreateSession(session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	_, err := r.db.Exec(`
+		INSERT INTO sessions (id, user_id, created_at)
+		VALUES (?, ?, ?)
+	`, session.ID, session.UserID, now)
+	if err != nil {
+		return fmt.Errorf("insert session: %w", err)
+	}
+
+	session.CreatedAt = now
+	return nil
+}
+
+// GetSession retrieves a session by ID
+func (r *sessionRepository) GetSession(id string) (*models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var session models.Session
+	var revokedAt sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT id, user_id, created_at, revoked_at
+		FROM sessions
+		WHERE id = ?
+	`, id).Scan(&session.ID, &session.UserID, &session.CreatedAt, &revokedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("query session by id: %w", err)
+	}
+
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+
+	return &session, nil
+}
+
+// RevokeSession marks a session as revoked; revoking twice keeps the first time
+func (r *sessionRepository) RevokeSession(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.db.Exec(`
+		UPDATE sessions
+		SET revoked_at = ?
+		WHERE id = ? AND revoked_at IS NULL
+	`, time.Now(), id)
+
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+
+	return nil
+}
+
+// CreateRefreshToken stores a refresh token hash for a session
+func (r *sessionRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	_, err := r.db.Exec(`
+		INSERT INTO refresh_tokens (token_hash, session_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?)
+	`, token.TokenHash, token.SessionID, token.ExpiresAt, now)
+	if err != nil {
+		return fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	token.CreatedAt = now
+	return nil
+}
+
+// GetRefreshToken retrieves a refresh token by its hash, including the
+// user of its session
+func (r *sessionRepository) GetRefreshToken(tokenHash string) (*models.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var token models.RefreshToken
+	var usedAt sql.NullTime
+	err := r.db.QueryRow(`
+		SELECT t.token_hash, t.session_id, s.user_id, t.expires_at, t.used_at, t.created_at
+		FROM refresh_tokens t
+		JOIN sessions s ON s.id = t.session_id
+		WHERE t.token_hash = ?
+	`, tokenHash).Scan(&token.TokenHash, &token.SessionID, &token.UserID, &token.ExpiresAt, &usedAt, &token.CreatedAt)
+
+	if err != nil {
+		return nil, fmt.Errorf("query refresh token: %w", err)
+	}
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed consumes a refresh token. It reports false when the
+// token had already been used, so two concurrent refreshes cannot both win.
+func (r *sessionRepository) MarkRefreshTokenUsed(tokenHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result, err := r.db.Exec(`
+		UPDATE refresh_tokens
+		SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL
+	`, time.Now(), tokenHash)
+	if err != nil {
+		return false, fmt.Errorf("mark refresh token used: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get rows affected: %w", err)
+	}
+
+	return affected == 1, nil
+}
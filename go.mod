@@ -2,11 +2,22 @@ module gochat
 
 go 1.24.1
 
-require golang.org/x/crypto v0.36.0
+require (
+	github.com/fasthttp/websocket v1.5.3
+	github.com/fergusstrange/embedded-postgres v1.30.0
+	github.com/jackc/pgx/v5 v5.7.2
+	golang.org/x/crypto v0.36.0
+)
 
 require (
-	github.com/fasthttp/websocket v1.5.3 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/jackc/puddle/v2 v2.2.2 // indirect
+	github.com/lib/pq v1.10.9 // indirect
 	github.com/savsgio/gotils v0.0.0-20230208104028-c358bd845dee // indirect
+	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
+	golang.org/x/sync v0.12.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
 )
 
 require (
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/logging"
+	"gochat/models"
+	"gochat/storage"
+)
+
+const (
+	// maxFilenameLength caps the stored name of an upload in bytes
+	maxFilenameLength = 255
+
+	// uploadSweepInterval is how often uploads that were never sent are
+	// looked for by ExpireUploads
+	uploadSweepInterval = 10 * time.Minute
+)
+
+// allowedAttachmentTypes are the sniffed content types accepted for upload
+var allowedAttachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// AttachmentRepository defines the interface for attachment database operations
+type AttachmentRepository interface {
+	CreateAttachment(attachment *models.Attachment) error
+	GetAttachmentByID(id int64) (*models.Attachment, error)
+	DeletePendingAttachments(before time.Time) ([]*models.Attachment, error)
+}
+
+// MessageLookup retrieves messages by ID
+type MessageLookup interface {
+	GetMessageByID(id int64) (*models.Message, error)
+}
+
+// AttachmentHandler handles file upload and download requests
+type AttachmentHandler struct {
+	attachmentRepo AttachmentRepository
+	messageRepo    MessageLookup
+	roomRepo       MembershipChecker
+	store          storage.Storage
+	maxSize        int64
+}
+
+// NewAttachmentHandler creates a new attachment handler
+func NewAttachmentHandler(attachmentRepo AttachmentRepository, messageRepo MessageLookup, roomRepo MembershipChecker, store storage.Storage, maxSize int64) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentRepo: attachmentRepo,
+		messageRepo:    messageRepo,
+		roomRepo:       roomRepo,
+		store:          store,
+		maxSize:        maxSize,
+	}
+}
+
+// Upload stores a file sent as the "file" field of a multipart form. The
+// returned attachment can then be sent with a chat message.
+func (h *AttachmentHandler) Upload(c *fiber.Ctx) error {
+	userID := CurrentPrincipal(c).UserID
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A file is required in the \"file\" form field",
+		})
+	}
+	if header.Size == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File is empty",
+		})
+	}
+	if header.Size > h.maxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "File is too large",
+		})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		logging.Errorf("Error opening upload: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store file",
+		})
+	}
+	defer file.Close()
+
+	// Trust the content, not the type declared by the client
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		logging.Errorf("Error reading upload: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store file",
+		})
+	}
+	contentType := http.DetectContentType(head[:n])
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !allowedAttachmentTypes[mediaType] {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "File type " + mediaType + " is not allowed",
+		})
+	}
+
+	key, err := randomToken(24)
+	if err != nil {
+		logging.Errorf("Error generating storage key: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store file",
+		})
+	}
+
+	attachment := &models.Attachment{
+		UserID:      userID,
+		Filename:    cleanFilename(header.Filename),
+		ContentType: contentType,
+		Size:        header.Size,
+		StorageKey:  key,
+	}
+
+	if err := h.store.Put(key, io.MultiReader(bytes.NewReader(head[:n]), file)); err != nil {
+		logging.Errorf("Error storing upload: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store file",
+		})
+	}
+
+	// Record the dimensions of images and add a thumbnail when possible; a
+	// failure here does not reject the upload
+	if thumbnailFormats[mediaType] {
+		h.addThumbnail(attachment, file)
+	}
+
+	if err := h.attachmentRepo.CreateAttachment(attachment); err != nil {
+		logging.Errorf("Error creating attachment: %v", err)
+		h.deleteBlobs(attachment)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store file",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(attachment)
+}
+
+// addThumbnail reads the dimensions of an uploaded image from the start of
+// file and stores a scaled-down copy next to it
+func (h *AttachmentHandler) addThumbnail(attachment *models.Attachment, file io.ReadSeeker) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		logging.Errorf("Error rewinding upload: %v", err)
+		return
+	}
+	width, height, err := imageSize(file)
+	if err != nil {
+		logging.Errorf("Error reading image size: %v", err)
+		return
+	}
+	attachment.Width, attachment.Height = width, height
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		logging.Errorf("Error rewinding upload: %v", err)
+		return
+	}
+	thumb, err := makeThumbnail(file, attachment.ContentType, width, height)
+	if err != nil {
+		logging.Debugf("Skipping thumbnail: %v", err)
+		return
+	}
+
+	key := attachment.StorageKey + "_thumb"
+	if err := h.store.Put(key, bytes.NewReader(thumb)); err != nil {
+		logging.Errorf("Error storing thumbnail: %v", err)
+		return
+	}
+	attachment.ThumbnailKey = key
+}
+
+// Download sends an attachment the caller may see
+func (h *AttachmentHandler) Download(c *fiber.Ctx) error {
+	attachment, ok, err := h.loadAttachment(c)
+	if !ok {
+		return err
+	}
+
+	// Images may be shown inline; anything else is always downloaded
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		disposition = "inline"
+	}
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{
+		"filename": attachment.Filename,
+	}))
+
+	return h.sendBlob(c, attachment.StorageKey, attachment.ContentType)
+}
+
+// Thumbnail sends the thumbnail of an image attachment the caller may see
+func (h *AttachmentHandler) Thumbnail(c *fiber.Ctx) error {
+	attachment, ok, err := h.loadAttachment(c)
+	if !ok {
+		return err
+	}
+
+	if attachment.ThumbnailKey == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Attachment has no thumbnail",
+		})
+	}
+
+	return h.sendBlob(c, attachment.ThumbnailKey, thumbnailContentType(attachment.ContentType))
+}
+
+// loadAttachment reads the attachment named by the :id parameter. The
+// uploader may always see it; anyone else only once it was sent with a
+// message they can see. When ok is false the error response has been written.
+func (h *AttachmentHandler) loadAttachment(c *fiber.Ctx) (*models.Attachment, bool, error) {
+	userID := CurrentPrincipal(c).UserID
+
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return nil, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid attachment ID",
+		})
+	}
+
+	notFound := func() (*models.Attachment, bool, error) {
+		return nil, false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Attachment not found",
+		})
+	}
+	failed := func(err error) (*models.Attachment, bool, error) {
+		logging.Errorf("Error loading attachment %d: %v", id, err)
+		return nil, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load attachment",
+		})
+	}
+
+	attachment, err := h.attachmentRepo.GetAttachmentByID(int64(id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return notFound()
+		}
+		return failed(err)
+	}
+
+	if attachment.UserID == userID {
+		return attachment, true, nil
+	}
+	if attachment.MessageID == 0 {
+		return notFound()
+	}
+
+	message, err := h.messageRepo.GetMessageByID(attachment.MessageID)
+	if err != nil {
+		return failed(err)
+	}
+	if message.DeletedAt != nil {
+		return notFound()
+	}
+
+	canSee, err := message.VisibleTo(userID, h.roomRepo)
+	if err != nil {
+		return failed(err)
+	}
+	if !canSee {
+		return notFound()
+	}
+
+	return attachment, true, nil
+}
+
+// sendBlob streams a stored blob as the response body
+func (h *AttachmentHandler) sendBlob(c *fiber.Ctx, key, contentType string) error {
+	blob, err := h.store.Open(key)
+	if err != nil {
+		logging.Errorf("Error opening blob %s: %v", key, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load attachment",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+
+	// The response closes the blob once it has been sent
+	return c.SendStream(blob)
+}
+
+// deleteBlobs removes the stored files of an attachment that could not be recorded
+func (h *AttachmentHandler) deleteBlobs(attachment *models.Attachment) {
+	for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := h.store.Delete(key); err != nil {
+			logging.Errorf("Error deleting blob %s: %v", key, err)
+		}
+	}
+}
+
+// ExpireUploads deletes the uploads that were not sent with a message within
+// ttl, along with their blobs, until ctx is done
+func (h *AttachmentHandler) ExpireUploads(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(uploadSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		h.deleteExpiredUploads(time.Now().Add(-ttl))
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deleteExpiredUploads deletes the uploads still unsent since before and
+// their blobs
+func (h *AttachmentHandler) deleteExpiredUploads(before time.Time) {
+	expired, err := h.attachmentRepo.DeletePendingAttachments(before)
+	if err != nil {
+		logging.Errorf("Error deleting expired uploads: %v", err)
+		return
+	}
+
+	for _, attachment := range expired {
+		h.deleteBlobs(attachment)
+	}
+	if len(expired) > 0 {
+		logging.Infof("Deleted %d uploads that were never sent", len(expired))
+	}
+}
+
+// cleanFilename keeps the base name of an uploaded file without control
+// characters, shortened to maxFilenameLength bytes
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/models"
+	"gochat/storage"
+)
+
+// testUploadLimit is the largest upload accepted by newAttachmentApp
+const testUploadLimit = 64 << 10
+
+// attachmentFixture adds upload and download helpers to a handlerFixture
+type attachmentFixture struct {
+	*handlerFixture
+	handler *AttachmentHandler
+}
+
+// newAttachmentApp mounts the upload, download and thumbnail routes, with
+// blobs kept in a temporary directory
+func newAttachmentApp(t *testing.T) *attachmentFixture {
+	t.Helper()
+
+	f := &attachmentFixture{handlerFixture: newHandlerFixture(t)}
+	blobs, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	f.handler = NewAttachmentHandler(f.store, f.store, f.store, blobs, testUploadLimit)
+
+	f.app.Post("/attachments", f.handler.Upload)
+	f.app.Get("/attachments/:id", f.handler.Download)
+	f.app.Get("/attachments/:id/thumbnail", f.handler.Thumbnail)
+	return f
+}
+
+// upload sends content as the "file" field of a multipart form, declared as
+// an image so only sniffing can reject it, and decodes the response
+func (f *attachmentFixture) upload(t *testing.T, user *models.User, filename string, content []byte) (int, map[string]interface{}) {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := make(map[string][]string)
+	header["Content-Disposition"] = []string{fmt.Sprintf(`form-data; name="file"; filename=%q`, filename)}
+	header["Content-Type"] = []string{"image/png"}
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req := httptest.NewRequest(fiber.MethodPost, "/attachments", &body)
+	req.Header.Set(fiber.HeaderContentType, form.FormDataContentType())
+	resp := f.do(t, user, req)
+	return resp.StatusCode, decodeJSON(t, resp)
+}
+
+// get requests path as user and returns the response and its body
+func (f *attachmentFixture) get(t *testing.T, user *models.User, path string) (*http.Response, []byte) {
+	t.Helper()
+
+	resp := f.do(t, user, httptest.NewRequest(fiber.MethodGet, path, nil))
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	return resp, data
+}
+
+// pngImage encodes a width x height PNG
+func pngImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUpload(t *testing.T) {
+	f := newAttachmentApp(t)
+
+	const text = "text/plain; charset=utf-8"
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		wantCode int
+		wantName string // Stored name and sniffed type of accepted uploads
+		wantType string
+	}{
+		{"text", "notes.txt", []byte("meeting at noon"), fiber.StatusCreated, "notes.txt", text},
+		{"image", "cat.png", pngImage(t, 8, 8), fiber.StatusCreated, "cat.png", "image/png"},
+		{"path in the name", `C:\Users\alice\notes.txt`, []byte("meeting at noon"), fiber.StatusCreated, "notes.txt", text},
+		{"empty", "empty.txt", nil, fiber.StatusBadRequest, "", ""},
+		{"at the size limit", "big.txt", bytes.Repeat([]byte("a"), testUploadLimit), fiber.StatusCreated, "big.txt", text},
+		{"over the size limit", "huge.txt", bytes.Repeat([]byte("a"), testUploadLimit+1), fiber.StatusRequestEntityTooLarge, "", ""},
+		{"HTML disguised as an image", "cat.png", []byte("<html><script>alert(1)</script></html>"), fiber.StatusUnsupportedMediaType, "", ""},
+		{"executable disguised as an image", "cat.png", append([]byte("\x7fELF"), make([]byte, 64)...), fiber.StatusUnsupportedMediaType, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := f.upload(t, f.alice, tt.filename, tt.content)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if code != fiber.StatusCreated {
+				if _, ok := body["id"]; ok {
+					t.Errorf("rejected upload returned an attachment: %v", body)
+				}
+				return
+			}
+
+			if body["filename"] != tt.wantName || body["content_type"] != tt.wantType || body["size"] != float64(len(tt.content)) {
+				t.Errorf("attachment = %v, want %q of type %q and size %d", body, tt.wantName, tt.wantType, len(tt.content))
+			}
+
+			// The stored file is what was uploaded
+			resp, data := f.get(t, f.alice, fmt.Sprintf("/attachments/%v", body["id"]))
+			if resp.StatusCode != fiber.StatusOK || !bytes.Equal(data, tt.content) {
+				t.Errorf("download: status %d, %d bytes", resp.StatusCode, len(data))
+			}
+			if resp.Header.Get(fiber.HeaderXContentTypeOptions) != "nosniff" {
+				t.Error("download allows sniffing")
+			}
+		})
+	}
+
+	// The file must come in the "file" field
+	req := httptest.NewRequest(fiber.MethodPost, "/attachments", strings.NewReader("a=b"))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	if resp := f.do(t, f.alice, req); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("upload without a file: status %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+}
+
+func TestUploadThumbnail(t *testing.T) {
+	f := newAttachmentApp(t)
+
+	// Large images get a thumbnail that fits within thumbnailSize
+	code, body := f.upload(t, f.alice, "wide.png", pngImage(t, 2*thumbnailSize, thumbnailSize))
+	if code != fiber.StatusCreated {
+		t.Fatalf("upload: status %d (body %v)", code, body)
+	}
+	if body["width"] != float64(2*thumbnailSize) || body["height"] != float64(thumbnailSize) {
+		t.Errorf("dimensions = %v x %v", body["width"], body["height"])
+	}
+	thumbnailURL, _ := body["thumbnail_url"].(string)
+	if thumbnailURL == "" {
+		t.Fatalf("no thumbnail URL in %v", body)
+	}
+
+	resp, data := f.get(t, f.alice, strings.TrimPrefix(thumbnailURL, "/api"))
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get(fiber.HeaderContentType) != "image/png" {
+		t.Fatalf("thumbnail: status %d, type %q", resp.StatusCode, resp.Header.Get(fiber.HeaderContentType))
+	}
+	thumb, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode thumbnail: %v", err)
+	}
+	if thumb.Width != thumbnailSize || thumb.Height != thumbnailSize/2 {
+		t.Errorf("thumbnail is %d x %d, want %d x %d", thumb.Width, thumb.Height, thumbnailSize, thumbnailSize/2)
+	}
+
+	// Other files have none
+	code, body = f.upload(t, f.alice, "notes.txt", []byte("meeting at noon"))
+	if code != fiber.StatusCreated || body["thumbnail_url"] != nil || body["width"] != nil {
+		t.Fatalf("text upload: status %d, body %v", code, body)
+	}
+	if resp, _ := f.get(t, f.alice, fmt.Sprintf("/attachments/%v/thumbnail", body["id"])); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("text thumbnail: status %d, want %d", resp.StatusCode, fiber.StatusNotFound)
+	}
+}
+
+// Attachments are visible to their uploader and, once sent, to whoever can
+// see the message
+func TestAttachmentVisibility(t *testing.T) {
+	f := newAttachmentApp(t)
+
+	// send uploads a file as alice and sends it with a message
+	send := func(message *models.Message) int64 {
+		t.Helper()
+		code, body := f.upload(t, f.alice, "notes.txt", []byte("meeting at noon"))
+		if code != fiber.StatusCreated {
+			t.Fatalf("upload: status %d (body %v)", code, body)
+		}
+		id := int64(body["id"].(float64))
+		if message == nil {
+			return id
+		}
+
+		message.UserID, message.Content = f.alice.ID, "see attached"
+		if err := f.store.CreateMessage(message); err != nil {
+			t.Fatalf("CreateMessage: %v", err)
+		}
+		if linked, err := f.store.AttachToMessage([]int64{id}, f.alice.ID, message.ID); err != nil || linked != 1 {
+			t.Fatalf("AttachToMessage = %d, %v", linked, err)
+		}
+		return id
+	}
+
+	channel := send(&models.Message{})
+	room := send(&models.Message{RoomID: f.room.ID})
+	direct := send(&models.Message{RecipientID: f.bob.ID})
+	unsent := send(nil)
+
+	deletedMessage := &models.Message{}
+	deleted := send(deletedMessage)
+	if _, err := f.store.DeleteMessage(deletedMessage.ID, f.alice.ID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		attachment int64
+		user       *models.User
+		wantCode   int
+	}{
+		{"channel attachment", channel, f.carol, fiber.StatusOK},
+		{"room attachment to a member", room, f.bob, fiber.StatusOK},
+		{"room attachment to a non-member", room, f.carol, fiber.StatusNotFound},
+		{"direct attachment to the recipient", direct, f.bob, fiber.StatusOK},
+		{"direct attachment to someone else", direct, f.carol, fiber.StatusNotFound},
+		{"unsent attachment to the uploader", unsent, f.alice, fiber.StatusOK},
+		{"unsent attachment to someone else", unsent, f.bob, fiber.StatusNotFound},
+		{"attachment of a deleted message", deleted, f.bob, fiber.StatusNotFound},
+		{"missing attachment", 9999, f.alice, fiber.StatusNotFound},
+		{"invalid ID", 0, f.alice, fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, data := f.get(t, tt.user, fmt.Sprintf("/attachments/%d", tt.attachment))
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantCode, data)
+			}
+			if tt.wantCode == fiber.StatusOK && string(data) != "meeting at noon" {
+				t.Errorf("body = %q", data)
+			}
+			if tt.wantCode != fiber.StatusOK && bytes.Contains(data, []byte("meeting")) {
+				t.Errorf("refused download leaked the file: %s", data)
+			}
+		})
+	}
+}
+
+// Uploads that are never sent expire along with their blobs
+func TestExpireUploads(t *testing.T) {
+	f := newAttachmentApp(t)
+
+	upload := func(filename string, content []byte) *models.Attachment {
+		t.Helper()
+		code, body := f.upload(t, f.alice, filename, content)
+		if code != fiber.StatusCreated {
+			t.Fatalf("upload %s: status %d (body %v)", filename, code, body)
+		}
+		attachment, err := f.store.GetAttachmentByID(int64(body["id"].(float64)))
+		if err != nil {
+			t.Fatalf("GetAttachmentByID: %v", err)
+		}
+		return attachment
+	}
+	abandoned := upload("wide.png", pngImage(t, 2*thumbnailSize, thumbnailSize))
+	sent := upload("notes.txt", []byte("meeting at noon"))
+	message := &models.Message{UserID: f.alice.ID, Content: "see attached"}
+	if err := f.store.CreateMessage(message); err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	f.store.AttachToMessage([]int64{sent.ID}, f.alice.ID, message.ID)
+	cutoff := time.Now()
+	recent := upload("later.txt", []byte("not yet"))
+
+	f.handler.deleteExpiredUploads(cutoff)
+
+	if resp, _ := f.get(t, f.alice, fmt.Sprintf("/attachments/%d", abandoned.ID)); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expired upload: status %d, want %d", resp.StatusCode, fiber.StatusNotFound)
+	}
+	for _, key := range []string{abandoned.StorageKey, abandoned.ThumbnailKey} {
+		if blob, err := f.handler.store.Open(key); !errors.Is(err, storage.ErrNotFound) {
+			if err == nil {
+				blob.Close()
+			}
+			t.Errorf("blob %s: error %v, want storage.ErrNotFound", key, err)
+		}
+	}
+
+	for _, kept := range []*models.Attachment{sent, recent} {
+		if resp, _ := f.get(t, f.alice, fmt.Sprintf("/attachments/%d", kept.ID)); resp.StatusCode != fiber.StatusOK {
+			t.Errorf("%s: status %d, want %d", kept.Filename, resp.StatusCode, fiber.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+
+	"gochat/config"
+	"gochat/models"
+)
+
+// SessionStore defines the session lookups needed to authenticate requests
+type SessionStore interface {
+	GetSession(id string) (*models.Session, error)
+}
+
+// jwtConfig holds the token settings shared by login and authentication
+var jwtConfig = config.Default().JWT
+
+// sessionStore is used to reject tokens of revoked sessions
+var sessionStore SessionStore
+
+// InitAuth sets the token settings used to sign and verify JWTs and the
+// store used to check that their sessions have not been revoked
+func InitAuth(cfg config.JWTConfig, sessions SessionStore) {
+	jwtConfig = cfg
+	sessionStore = sessions
+}
+
+// principalKey is the c.Locals key holding the authenticated *Principal
+const principalKey = "principal"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID    int64
+	SessionID string
+}
+
+// tokenClaims are the claims carried by access tokens
+type tokenClaims struct {
+	UserID    int64  `json:"user_id"`
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// RequireAuth is a middleware that authenticates the request with an access
+// token from the "Authorization: Bearer" header or the ?token= query and
+// stores the caller's *Principal in c.Locals
+func RequireAuth(c *fiber.Ctx) error {
+	token := bearerToken(c)
+	if token == "" {
+		token = c.Query("token")
+	}
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "No authentication token provided",
+		})
+	}
+
+	principal, err := authenticateToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	c.Locals(principalKey, principal)
+	return c.Next()
+}
+
+// CurrentPrincipal returns the principal stored by RequireAuth, or nil
+func CurrentPrincipal(c *fiber.Ctx) *Principal {
+	principal, _ := c.Locals(principalKey).(*Principal)
+	return principal
+}
+
+// bearerToken extracts the token from the Authorization header, if any
+func bearerToken(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// authenticateToken validates an access token and checks that its session
+// is still active
+func authenticateToken(tokenString string) (*Principal, error) {
+	// Only accept tokens signed with our HMAC key
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	var claims tokenClaims
+	parsedToken, err := parser.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(jwtConfig.Secret), nil
+	})
+	if err != nil || !parsedToken.Valid {
+		return nil, errors.New("Invalid authentication token")
+	}
+
+	// The parser only checks exp when present, so require it explicitly
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("Invalid authentication token")
+	}
+	if !claims.VerifyIssuer(jwtConfig.Issuer, true) || !claims.VerifyAudience(jwtConfig.Audience, true) {
+		return nil, errors.New("Invalid token claims")
+	}
+	if claims.UserID <= 0 || claims.SessionID == "" {
+		return nil, errors.New("Invalid token claims")
+	}
+
+	// Tokens of logged-out or revoked sessions are no longer accepted
+	session, err := sessionStore.GetSession(claims.SessionID)
+	if err != nil || session.UserID != claims.UserID || session.RevokedAt != nil {
+		return nil, errors.New("Session has been revoked")
+	}
+
+	return &Principal{
+		UserID:    claims.UserID,
+		SessionID: claims.SessionID,
+	}, nil
+}
+
+// issueAccessToken signs a short-lived access token for a session
+func issueAccessToken(user *models.User, sessionID string) (string, time.Time, error) {
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(jwtConfig.TTL.Duration)
+	claims := tokenClaims{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    jwtConfig.Issuer,
+			Audience:  jwt.ClaimStrings{jwtConfig.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(jwtConfig.Secret))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("sign access token: %w", err)
+	}
+
+	return tokenString, expirationTime, nil
+}
+
+// randomToken returns n random bytes encoded for use in URLs
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate random token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hex SHA-256 of a token, the form refresh tokens are stored in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
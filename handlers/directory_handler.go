@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/logging"
+	"gochat/models"
+	"gochat/validation"
+)
+
+const (
+	// defaultUserPageSize is the page size used when the client does not ask for one
+	defaultUserPageSize = 20
+	// maxUserPageSize caps the page size a client may request
+	maxUserPageSize = 100
+)
+
+// PublicUser is the profile of a user as shown to other users
+type PublicUser struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newPublicUser strips private fields from a user
+func newPublicUser(user *models.User) *PublicUser {
+	return &PublicUser{
+		ID:        user.ID,
+		Username:  user.Username,
+		Status:    user.Status,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+// UpdateProfileRequest represents a profile update; omitted fields are left unchanged
+type UpdateProfileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+}
+
+// ListUsers returns a page of users, optionally filtered by username prefix and status
+func (h *UserHandler) ListUsers(c *fiber.Ctx) error {
+	filter := models.UserFilter{
+		UsernamePrefix: strings.TrimSpace(c.Query("q")),
+		Status:         c.Query("status"),
+		Limit:          c.QueryInt("limit", defaultUserPageSize),
+		Offset:         c.QueryInt("offset"),
+	}
+
+	switch filter.Status {
+	case "", "online", "offline", "away":
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Status must be online, offline or away",
+		})
+	}
+	if filter.Limit <= 0 || filter.Limit > maxUserPageSize {
+		filter.Limit = defaultUserPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	users, total, err := h.userRepo.ListUsers(filter)
+	if err != nil {
+		logging.Errorf("Error listing users: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list users",
+		})
+	}
+
+	publicUsers := make([]*PublicUser, 0, len(users))
+	for _, user := range users {
+		publicUsers = append(publicUsers, newPublicUser(user))
+	}
+
+	return c.JSON(fiber.Map{
+		"users":  publicUsers,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
+}
+
+// GetUser returns the public profile of a user
+func (h *UserHandler) GetUser(c *fiber.Ctx) error {
+	userID, err := c.ParamsInt("id")
+	if err != nil || userID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	user, err := h.userRepo.GetUserByID(int64(userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		logging.Errorf("Error getting user %d: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load user",
+		})
+	}
+
+	return c.JSON(newPublicUser(user))
+}
+
+// GetMe returns the full profile of the authenticated user
+func (h *UserHandler) GetMe(c *fiber.Ctx) error {
+	user, err := h.userRepo.GetUserByID(CurrentPrincipal(c).UserID)
+	if err != nil {
+		logging.Errorf("Error getting user %d: %v", CurrentPrincipal(c).UserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load user",
+		})
+	}
+
+	// The password hash is excluded by the model's JSON tags
+	return c.JSON(user)
+}
+
+// UpdateMe updates the authenticated user's username and email
+func (h *UserHandler) UpdateMe(c *fiber.Ctx) error {
+	// Parse request body
+	var req UpdateProfileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request data",
+		})
+	}
+
+	user, err := h.userRepo.GetUserByID(CurrentPrincipal(c).UserID)
+	if err != nil {
+		logging.Errorf("Error getting user %d: %v", CurrentPrincipal(c).UserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update profile",
+		})
+	}
+	previousUsername := user.Username
+
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if err := validation.Username(username); err != nil {
+			return invalidFields(c, validation.Errors{err})
+		}
+
+		// Moderators are resolved by name at startup, so neither their
+		// names nor their accounts' names may change hands
+		if username != user.Username && (h.isModeratorName(username) || h.isModeratorName(user.Username)) {
+			return fieldReserved(c, "username", "Username is reserved")
+		}
+
+		// Check if username is taken by someone else
+		existingUser, err := h.userRepo.GetUserByUsername(username)
+		if err == nil && existingUser.ID != user.ID {
+			return userFieldTaken(c, "username")
+		}
+		user.Username = username
+	}
+
+	if req.Email != nil {
+		// Emails are stored and compared in lower case
+		email := strings.ToLower(strings.TrimSpace(*req.Email))
+		if err := validation.Email(email); err != nil {
+			return invalidFields(c, validation.Errors{err})
+		}
+
+		// Check if email is taken by someone else
+		existingUser, err := h.userRepo.GetUserByEmail(email)
+		if err == nil && existingUser.ID != user.ID {
+			return userFieldTaken(c, "email")
+		}
+		user.Email = email
+	}
+
+	if err := h.userRepo.UpdateUserProfile(user); err != nil {
+		// Another request may have claimed the username or email since the
+		// checks above
+		var duplicate *models.DuplicateError
+		if errors.As(err, &duplicate) {
+			return userFieldTaken(c, duplicate.Field)
+		}
+		logging.Errorf("Error updating user %d: %v", user.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update profile",
+		})
+	}
+
+	// Relabel the user for connected clients
+	if user.Username != previousUsername && ChatHub != nil {
+		ChatHub.RenameUser(user.ID, user.Username)
+	}
+
+	return c.JSON(user)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/models"
+	"gochat/validation"
+)
+
+func TestUpdateMe(t *testing.T) {
+	tests := []struct {
+		name       string
+		caller     string
+		body       string
+		wantCode   int
+		wantFields map[string]string // Rejected field and error code
+	}{
+		{"rename", "alice", `{"username":"alicia"}`, fiber.StatusOK, nil},
+		{"unchanged moderator name", "mod", `{"username":"mod","email":"mod@example.org"}`, fiber.StatusOK, nil},
+		{"taken name", "alice", `{"username":"bob"}`, fiber.StatusConflict, map[string]string{"username": validation.CodeTaken}},
+		{"rename to a moderator name", "alice", `{"username":"mod"}`, fiber.StatusForbidden, map[string]string{"username": validation.CodeReserved}},
+		{"rename to a moderator name in other case", "alice", `{"username":"MOD"}`, fiber.StatusForbidden, map[string]string{"username": validation.CodeReserved}},
+		{"taken email in other case", "alice", `{"email":"BOB@example.com"}`, fiber.StatusConflict, map[string]string{"email": validation.CodeTaken}},
+		{"moderator renaming", "mod", `{"username":"alice2"}`, fiber.StatusForbidden, map[string]string{"username": validation.CodeReserved}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newHandlerFixture(t)
+			mod := f.createUser(t, "mod")
+			handler := NewUserHandler(f.store, f.store)
+			handler.SetModerators([]string{"mod"})
+			f.app.Patch("/me", handler.UpdateMe)
+
+			caller, err := f.store.GetUserByUsername(tt.caller)
+			if err != nil {
+				t.Fatalf("GetUserByUsername: %v", err)
+			}
+
+			code, body := f.sendJSON(t, caller, fiber.MethodPatch, "/me", tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if fields := fieldCodes(body); !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+			}
+
+			// The moderator's name stays with its account
+			if user, err := f.store.GetUserByUsername("mod"); err != nil || user.ID != mod.ID {
+				t.Errorf("user named mod = %+v, %v; want the moderator", user, err)
+			}
+		})
+	}
+}
+
+// createDirectoryUser stores a user with the given status and a password
+// hash, which the directory must never show
+func createDirectoryUser(t *testing.T, f *handlerFixture, username, status string) *models.User {
+	t.Helper()
+
+	user := &models.User{Username: username, Email: username + "@example.com", Password: "hash-of-" + username, Status: status}
+	if err := f.store.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// checkPublicUser fails unless a user entry holds only the public fields
+func checkPublicUser(t *testing.T, entry interface{}) {
+	t.Helper()
+
+	user, _ := entry.(map[string]interface{})
+	keys := make([]string, 0, len(user))
+	for key := range user {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if want := []string{"created_at", "id", "status", "username"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("user %v has fields %v, want %v", entry, keys, want)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	f := newHandlerFixture(t)
+	createDirectoryUser(t, f, "alina", "online")
+	createDirectoryUser(t, f, "dave", "away")
+	f.app.Get("/users", NewUserHandler(f.store, f.store).ListUsers)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantCode   int
+		wantUsers  []string // Usernames in order
+		wantTotal  int
+		wantLimit  int
+		wantOffset int
+	}{
+		{"everyone", "", fiber.StatusOK, []string{"alice", "alina", "bob", "carol", "dave"}, 5, defaultUserPageSize, 0},
+		{"username prefix", "q=al", fiber.StatusOK, []string{"alice", "alina"}, 2, defaultUserPageSize, 0},
+		{"prefix in other case", "q=AL", fiber.StatusOK, []string{"alice", "alina"}, 2, defaultUserPageSize, 0},
+		{"prefix with spaces", "q=%20bo%20", fiber.StatusOK, []string{"bob"}, 1, defaultUserPageSize, 0},
+		{"prefix in the middle", "q=ice", fiber.StatusOK, []string{}, 0, defaultUserPageSize, 0},
+		{"online", "status=online", fiber.StatusOK, []string{"alina"}, 1, defaultUserPageSize, 0},
+		{"away", "status=away", fiber.StatusOK, []string{"dave"}, 1, defaultUserPageSize, 0},
+		{"prefix and status", "q=al&status=offline", fiber.StatusOK, []string{"alice"}, 1, defaultUserPageSize, 0},
+		{"unknown status", "status=busy", fiber.StatusBadRequest, nil, 0, 0, 0},
+		{"page", "limit=2&offset=1", fiber.StatusOK, []string{"alina", "bob"}, 5, 2, 1},
+		{"last page", "limit=2&offset=4", fiber.StatusOK, []string{"dave"}, 5, 2, 4},
+		{"past the end", "offset=10", fiber.StatusOK, []string{}, 5, defaultUserPageSize, 10},
+		{"zero limit uses the default", "limit=0", fiber.StatusOK, []string{"alice", "alina", "bob", "carol", "dave"}, 5, defaultUserPageSize, 0},
+		{"limit over the maximum uses the default", fmt.Sprintf("limit=%d", maxUserPageSize+1), fiber.StatusOK, []string{"alice", "alina", "bob", "carol", "dave"}, 5, defaultUserPageSize, 0},
+		{"largest limit", fmt.Sprintf("limit=%d", maxUserPageSize), fiber.StatusOK, []string{"alice", "alina", "bob", "carol", "dave"}, 5, maxUserPageSize, 0},
+		{"negative offset starts at the beginning", "limit=1&offset=-1", fiber.StatusOK, []string{"alice"}, 5, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := f.sendJSON(t, f.alice, fiber.MethodGet, "/users?"+tt.query, "")
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if code != fiber.StatusOK {
+				return
+			}
+
+			entries, _ := body["users"].([]interface{})
+			usernames := make([]string, len(entries))
+			for i, entry := range entries {
+				checkPublicUser(t, entry)
+				usernames[i], _ = entry.(map[string]interface{})["username"].(string)
+			}
+			if !reflect.DeepEqual(usernames, tt.wantUsers) {
+				t.Errorf("users = %v, want %v", usernames, tt.wantUsers)
+			}
+			if body["total"] != float64(tt.wantTotal) || body["limit"] != float64(tt.wantLimit) || body["offset"] != float64(tt.wantOffset) {
+				t.Errorf("total, limit, offset = %v, %v, %v; want %d, %d, %d",
+					body["total"], body["limit"], body["offset"], tt.wantTotal, tt.wantLimit, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	f := newHandlerFixture(t)
+	alina := createDirectoryUser(t, f, "alina", "online")
+	f.app.Get("/users/:id", NewUserHandler(f.store, f.store).GetUser)
+
+	tests := []struct {
+		name         string
+		path         string
+		wantCode     int
+		wantUsername string
+	}{
+		{"other user", fmt.Sprintf("/users/%d", alina.ID), fiber.StatusOK, "alina"},
+		{"unknown user", "/users/999", fiber.StatusNotFound, ""},
+		{"zero id", "/users/0", fiber.StatusBadRequest, ""},
+		{"negative id", "/users/-1", fiber.StatusBadRequest, ""},
+		{"malformed id", "/users/alina", fiber.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := f.sendJSON(t, f.bob, fiber.MethodGet, tt.path, "")
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if code != fiber.StatusOK {
+				return
+			}
+
+			// Other users see neither the email nor the password hash
+			checkPublicUser(t, body)
+			if body["username"] != tt.wantUsername || body["status"] != "online" {
+				t.Errorf("user = %v, want %s online", body, tt.wantUsername)
+			}
+		})
+	}
+}
+
+func TestGetMe(t *testing.T) {
+	f := newHandlerFixture(t)
+	dave := createDirectoryUser(t, f, "dave", "away")
+	f.app.Get("/users/me", NewUserHandler(f.store, f.store).GetMe)
+
+	code, body := f.sendJSON(t, dave, fiber.MethodGet, "/users/me", "")
+	if code != fiber.StatusOK {
+		t.Fatalf("status = %d (body %v)", code, body)
+	}
+
+	// The owner sees their email, but never the password hash
+	if body["id"] != float64(dave.ID) || body["username"] != "dave" || body["email"] != "dave@example.com" || body["status"] != "away" {
+		t.Errorf("profile = %v, want dave's", body)
+	}
+	if _, ok := body["password"]; ok || strings.Contains(fmt.Sprint(body), "hash-of-dave") {
+		t.Errorf("profile %v exposes the password hash", body)
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/database/memory"
+	"gochat/models"
+)
+
+// handlerFixture holds alice, bob and carol in an in-memory store, with a
+// room "secret" of alice and bob. Requests to app act as the user whose ID
+// is in the X-User-ID header; each test mounts the routes it needs.
+type handlerFixture struct {
+	app               *fiber.App
+	store             *memory.Store
+	alice, bob, carol *models.User
+	room              *models.Room
+}
+
+// newHandlerFixture creates the users, room and app of a handlerFixture
+func newHandlerFixture(t *testing.T) *handlerFixture {
+	t.Helper()
+
+	f := &handlerFixture{store: memory.NewStore()}
+	f.alice, f.bob, f.carol = f.createUser(t, "alice"), f.createUser(t, "bob"), f.createUser(t, "carol")
+	f.room = &models.Room{Name: "secret"}
+	if err := f.store.CreateRoom(f.room, f.alice.ID); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if err := f.store.AddMember(f.room.ID, f.bob.ID); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+
+	f.app = fiber.New()
+	f.app.Use(func(c *fiber.Ctx) error {
+		userID, _ := strconv.ParseInt(c.Get("X-User-ID"), 10, 64)
+		c.Locals(principalKey, &Principal{UserID: userID})
+		return c.Next()
+	})
+	return f
+}
+
+// createUser stores an offline user with an example.com address
+func (f *handlerFixture) createUser(t *testing.T, username string) *models.User {
+	t.Helper()
+
+	user := &models.User{Username: username, Email: username + "@example.com", Status: "offline"}
+	if err := f.store.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// do sends req as user and returns the response
+func (f *handlerFixture) do(t *testing.T, user *models.User, req *http.Request) *http.Response {
+	t.Helper()
+
+	req.Header.Set("X-User-ID", fmt.Sprint(user.ID))
+	resp, err := f.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// sendJSON sends body to path as user and decodes the JSON response
+func (f *handlerFixture) sendJSON(t *testing.T, user *models.User, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp := f.do(t, user, req)
+	return resp.StatusCode, decodeJSON(t, resp)
+}
+
+// postJSON sends body to path and decodes the JSON response
+func postJSON(t *testing.T, app *fiber.App, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	return sendJSON(t, app, fiber.MethodPost, path, body)
+}
+
+// sendJSON sends body to path with the given method and decodes the JSON
+// response
+func sendJSON(t *testing.T, app *fiber.App, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, decodeJSON(t, resp)
+}
+
+// decodeJSON reads a JSON object from the body of resp
+func decodeJSON(t *testing.T, resp *http.Response) map[string]interface{} {
+	t.Helper()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("decode response %q: %v", data, err)
+	}
+	return decoded
+}
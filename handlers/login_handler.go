@@ -2,19 +2,32 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
+
+	"gochat/logging"
+	"gochat/models"
 )
 
+// dummyPasswordHash is a bcrypt hash at the default cost that logins for
+// unknown usernames are checked against, so they take as long as logins
+// with a wrong password and do not reveal which usernames exist
+const dummyPasswordHash = "$2a$10$LA9eV6UDu9ErceE7tHiOUeP9jVg6z1gEHWbNqgIo3z0I/DWGBMIym"
+
 // LoginRequest represents a login request
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
+// RefreshRequest represents a token refresh or logout request
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 // Login handles user login
 func (h *UserHandler) Login(c *fiber.Ctx) error {
 	// Parse request body
@@ -25,10 +38,17 @@ func (h *UserHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
+	// Refuse clients and usernames with too many failed attempts
+	if retryAfter, locked := h.loginThrottle.locked(c.IP(), req.Username); locked {
+		return tooManyAttempts(c, retryAfter)
+	}
+
 	// Get user from database
 	user, err := h.userRepo.GetUserByUsername(req.Username)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
+			bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(req.Password))
+			h.loginThrottle.record(c.IP(), req.Username)
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid credentials",
 			})
@@ -40,22 +60,154 @@ func (h *UserHandler) Login(c *fiber.Ctx) error {
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		h.loginThrottle.record(c.IP(), req.Username)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid credentials",
 		})
 	}
+	h.loginThrottle.reset(req.Username)
+
+	// Start a new session for this login
+	sessionID, err := randomToken(16)
+	if err != nil {
+		logging.Errorf("Error creating session ID: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Authentication failed",
+		})
+	}
+
+	if err := h.sessionRepo.CreateSession(&models.Session{ID: sessionID, UserID: user.ID}); err != nil {
+		logging.Errorf("Error creating session: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Authentication failed",
+		})
+	}
+
+	return h.sendTokens(c, user, sessionID)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token can be used once; presenting a used
+// one revokes the whole session, since it may have been stolen.
+func (h *UserHandler) Refresh(c *fiber.Ctx) error {
+	// Parse request body
+	var req RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Refresh token is required",
+		})
+	}
+
+	tokenHash := hashToken(req.RefreshToken)
+	token, err := h.sessionRepo.GetRefreshToken(tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid refresh token",
+			})
+		}
+		logging.Errorf("Error getting refresh token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh token",
+		})
+	}
+
+	session, err := h.sessionRepo.GetSession(token.SessionID)
+	if err != nil {
+		logging.Errorf("Error getting session: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh token",
+		})
+	}
+
+	if session.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+
+	// Consume the token; losing this race means it was replayed
+	consumed, err := h.sessionRepo.MarkRefreshTokenUsed(tokenHash)
+	if err != nil {
+		logging.Errorf("Error consuming refresh token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh token",
+		})
+	}
+	if !consumed {
+		logging.Warnf("Refresh token reuse detected for session %s, revoking it", session.ID)
+		h.revokeSession(session.ID)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+
+	user, err := h.userRepo.GetUserByID(session.UserID)
+	if err != nil {
+		logging.Errorf("Error getting user %d: %v", session.UserID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh token",
+		})
+	}
+
+	return h.sendTokens(c, user, session.ID)
+}
+
+// Logout revokes the session of the access token, closing its WebSocket connections
+func (h *UserHandler) Logout(c *fiber.Ctx) error {
+	principal := CurrentPrincipal(c)
+
+	if err := h.revokeSession(principal.SessionID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log out",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// revokeSession revokes a session and disconnects its open WebSockets
+func (h *UserHandler) revokeSession(sessionID string) error {
+	if err := h.sessionRepo.RevokeSession(sessionID); err != nil {
+		logging.Errorf("Error revoking session %s: %v", sessionID, err)
+		return err
+	}
+
+	if ChatHub != nil {
+		ChatHub.DisconnectSession(sessionID)
+	}
+
+	return nil
+}
+
+// sendTokens issues an access token and a refresh token for a session and
+// writes them as the response
+func (h *UserHandler) sendTokens(c *fiber.Ctx, user *models.User, sessionID string) error {
+	accessToken, expirationTime, err := issueAccessToken(user, sessionID)
+	if err != nil {
+		logging.Errorf("Error issuing access token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Authentication failed",
+		})
+	}
 
-	// Create JWT token
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"exp":      expirationTime.Unix(),
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		logging.Errorf("Error generating refresh token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Authentication failed",
+		})
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte("your-secret-key"))
+	// Only the hash of the refresh token is stored
+	refreshExpiration := time.Now().Add(jwtConfig.RefreshTTL.Duration)
+	err = h.sessionRepo.CreateRefreshToken(&models.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		SessionID: sessionID,
+		ExpiresAt: refreshExpiration,
+	})
 	if err != nil {
+		logging.Errorf("Error storing refresh token: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Authentication failed",
 		})
@@ -63,9 +215,11 @@ func (h *UserHandler) Login(c *fiber.Ctx) error {
 
 	// Return response
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"token":      tokenString,
-		"expires_at": expirationTime.Unix(),
-		"user_id":    user.ID,
-		"username":   user.Username,
+		"token":              accessToken,
+		"expires_at":         expirationTime.Unix(),
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": refreshExpiration.Unix(),
+		"user_id":            user.ID,
+		"username":           user.Username,
 	})
 }
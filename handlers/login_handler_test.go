@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+
+	"gochat/config"
+	"gochat/database/memory"
+	"gochat/models"
+)
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"valid", `{"username":"alice","password":"secret123"}`, fiber.StatusOK},
+		{"wrong password", `{"username":"alice","password":"secret124"}`, fiber.StatusUnauthorized},
+		{"unknown user", `{"username":"mallory","password":"secret123"}`, fiber.StatusUnauthorized},
+		{"empty password", `{"username":"alice","password":""}`, fiber.StatusUnauthorized},
+		{"malformed body", `{"username":`, fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, store := newAuthApp(t)
+			InitAuth(config.Default().JWT, store)
+
+			if code, _ := postJSON(t, app, "/register", `{"username":"alice","email":"alice@example.com","password":"secret123"}`); code != fiber.StatusCreated {
+				t.Fatalf("registering alice: status %d", code)
+			}
+
+			code, body := postJSON(t, app, "/login", tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if code != fiber.StatusOK {
+				if _, ok := body["token"]; ok {
+					t.Error("failed login returned a token")
+				}
+				return
+			}
+
+			// The access token authenticates alice in a live session
+			token, _ := body["token"].(string)
+			principal, err := authenticateToken(token)
+			if err != nil {
+				t.Fatalf("authenticateToken: %v", err)
+			}
+			alice, _ := store.GetUserByUsername("alice")
+			if principal.UserID != alice.ID || body["username"] != "alice" {
+				t.Errorf("principal = %+v, response %v", principal, body)
+			}
+			if _, err := store.GetSession(principal.SessionID); err != nil {
+				t.Errorf("session %q not stored: %v", principal.SessionID, err)
+			}
+
+			// The refresh token is stored by hash only
+			refreshToken, _ := body["refresh_token"].(string)
+			if refreshToken == "" {
+				t.Fatal("no refresh token")
+			}
+			stored, err := store.GetRefreshToken(hashToken(refreshToken))
+			if err != nil || stored.SessionID != principal.SessionID {
+				t.Errorf("refresh token = %+v, %v", stored, err)
+			}
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string // Attempted with a wrong password
+		lockedBody string
+	}{
+		{"known username", "alice", `{"username":"ALICE","password":"secret123"}`},
+		{"unknown username", "mallory", `{"username":"mallory","password":"secret123"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, store := newAuthApp(t)
+			InitAuth(config.Default().JWT, store)
+			limits := config.Default().RateLimit.Login
+
+			if code, _ := postJSON(t, app, "/register", `{"username":"alice","email":"alice@example.com","password":"secret123"}`); code != fiber.StatusCreated {
+				t.Fatalf("registering alice: status %d", code)
+			}
+
+			// Failed attempts up to the limit are refused as usual
+			for i := 0; i < limits.MaxPerUsername; i++ {
+				if code, _ := postJSON(t, app, "/login", `{"username":"`+tt.username+`","password":"guess"}`); code != fiber.StatusUnauthorized {
+					t.Fatalf("attempt %d: status %d, want %d", i+1, code, fiber.StatusUnauthorized)
+				}
+			}
+
+			// Then the username is locked, even with the right password and
+			// whatever the case
+			code, body := postJSON(t, app, "/login", tt.lockedBody)
+			if code != fiber.StatusTooManyRequests {
+				t.Fatalf("status = %d, want %d (body %v)", code, fiber.StatusTooManyRequests, body)
+			}
+			if _, ok := body["token"]; ok {
+				t.Error("locked-out login returned a token")
+			}
+		})
+	}
+}
+
+// newSessionApp is an auth app that also refreshes tokens, logs out and
+// serves /me to authenticated users, with alice registered and logged in.
+// It returns her login response.
+func newSessionApp(t *testing.T) (*fiber.App, *memory.Store, map[string]interface{}) {
+	t.Helper()
+
+	app, store := newAuthApp(t)
+	InitAuth(config.Default().JWT, store)
+	handler := NewUserHandler(store, store)
+	app.Post("/refresh", handler.Refresh)
+	app.Post("/logout", RequireAuth, handler.Logout)
+	app.Get("/me", RequireAuth, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"user_id": CurrentPrincipal(c).UserID})
+	})
+
+	if code, _ := postJSON(t, app, "/register", `{"username":"alice","email":"alice@example.com","password":"secret123"}`); code != fiber.StatusCreated {
+		t.Fatalf("registering alice: status %d", code)
+	}
+	code, login := postJSON(t, app, "/login", `{"username":"alice","password":"secret123"}`)
+	if code != fiber.StatusOK {
+		t.Fatalf("logging in: status %d (body %v)", code, login)
+	}
+	return app, store, login
+}
+
+// refresh exchanges a refresh token
+func refresh(t *testing.T, app *fiber.App, refreshToken interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	return postJSON(t, app, "/refresh", `{"refresh_token":"`+refreshToken.(string)+`"}`)
+}
+
+// authorized sends a request with an access token and returns the status
+func authorized(t *testing.T, app *fiber.App, method, path string, token interface{}) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token.(string))
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRefresh(t *testing.T) {
+	app, _, login := newSessionApp(t)
+
+	code, rotated := refresh(t, app, login["refresh_token"])
+	if code != fiber.StatusOK {
+		t.Fatalf("status = %d, want %d (body %v)", code, fiber.StatusOK, rotated)
+	}
+	if rotated["refresh_token"] == login["refresh_token"] || rotated["username"] != "alice" {
+		t.Errorf("refresh response = %v, want a new refresh token for alice", rotated)
+	}
+	if code := authorized(t, app, fiber.MethodGet, "/me", rotated["token"]); code != fiber.StatusOK {
+		t.Errorf("new access token: status %d, want %d", code, fiber.StatusOK)
+	}
+
+	// The new refresh token can be used in turn
+	if code, body := refresh(t, app, rotated["refresh_token"]); code != fiber.StatusOK {
+		t.Errorf("refreshing again: status %d (body %v)", code, body)
+	}
+
+	for _, body := range []string{`{}`, `{"refresh_token":""}`, `{"refresh_token":`} {
+		if code, _ := postJSON(t, app, "/refresh", body); code != fiber.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", body, code, fiber.StatusBadRequest)
+		}
+	}
+	if code, _ := refresh(t, app, "not-a-token"); code != fiber.StatusUnauthorized {
+		t.Errorf("unknown token: status %d, want %d", code, fiber.StatusUnauthorized)
+	}
+}
+
+// Presenting a rotated refresh token again means it may have been stolen,
+// so the whole session is revoked
+func TestRefreshReuse(t *testing.T) {
+	app, _, login := newSessionApp(t)
+
+	code, rotated := refresh(t, app, login["refresh_token"])
+	if code != fiber.StatusOK {
+		t.Fatalf("status = %d (body %v)", code, rotated)
+	}
+
+	if code, body := refresh(t, app, login["refresh_token"]); code != fiber.StatusUnauthorized {
+		t.Fatalf("reused token: status %d, want %d (body %v)", code, fiber.StatusUnauthorized, body)
+	}
+
+	// Neither the tokens issued before nor after the rotation work any more
+	for name, token := range map[string]interface{}{"first": login["token"], "rotated": rotated["token"]} {
+		if code := authorized(t, app, fiber.MethodGet, "/me", token); code != fiber.StatusUnauthorized {
+			t.Errorf("%s access token: status %d, want %d", name, code, fiber.StatusUnauthorized)
+		}
+	}
+	if code, _ := refresh(t, app, rotated["refresh_token"]); code != fiber.StatusUnauthorized {
+		t.Errorf("rotated refresh token: status %d, want %d", code, fiber.StatusUnauthorized)
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	app, store, login := newSessionApp(t)
+
+	principal, err := authenticateToken(login["token"].(string))
+	if err != nil {
+		t.Fatalf("authenticateToken: %v", err)
+	}
+	expired := &models.RefreshToken{
+		TokenHash: hashToken("expired-token"),
+		SessionID: principal.SessionID,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	if err := store.CreateRefreshToken(expired); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	if code, body := refresh(t, app, "expired-token"); code != fiber.StatusUnauthorized {
+		t.Errorf("status = %d, want %d (body %v)", code, fiber.StatusUnauthorized, body)
+	}
+
+	// Refusing an expired token leaves the session alone
+	if code := authorized(t, app, fiber.MethodGet, "/me", login["token"]); code != fiber.StatusOK {
+		t.Errorf("access token: status %d, want %d", code, fiber.StatusOK)
+	}
+}
+
+func TestRefreshAfterLogout(t *testing.T) {
+	app, _, login := newSessionApp(t)
+
+	if code := authorized(t, app, fiber.MethodPost, "/logout", login["token"]); code != fiber.StatusNoContent {
+		t.Fatalf("logout: status %d, want %d", code, fiber.StatusNoContent)
+	}
+
+	if code, body := refresh(t, app, login["refresh_token"]); code != fiber.StatusUnauthorized {
+		t.Errorf("status = %d, want %d (body %v)", code, fiber.StatusUnauthorized, body)
+	}
+	if code := authorized(t, app, fiber.MethodGet, "/me", login["token"]); code != fiber.StatusUnauthorized {
+		t.Errorf("access token: status %d, want %d", code, fiber.StatusUnauthorized)
+	}
+}
+
+// Unknown usernames are checked against a hash as costly as a real one
+func TestDummyPasswordHash(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("cost = %d, %v; want %d", cost, err, bcrypt.DefaultCost)
+	}
+}
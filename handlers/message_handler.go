@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/chat"
+	"gochat/logging"
+	"gochat/models"
+)
+
+const (
+	// defaultHistoryLimit is the page size used when the client does not ask for one
+	defaultHistoryLimit = 50
+	// maxHistoryLimit caps the page size a client may request
+	maxHistoryLimit = 100
+)
+
+// MessageRepository defines the interface for message database operations
+type MessageRepository interface {
+	ListMessages(roomID, beforeID, afterID int64, limit int) ([]*models.Message, error)
+	ListDirectMessages(userID, peerID, beforeID, afterID int64, limit int) ([]*models.Message, error)
+	ListReplies(parentID, beforeID, afterID int64, limit int) ([]*models.Message, error)
+	GetMessageByID(id int64) (*models.Message, error)
+}
+
+// pageLister loads a page of messages for the given cursors
+type pageLister func(beforeID, afterID int64, limit int) ([]*models.Message, error)
+
+// MembershipChecker reports whether a user belongs to a room
+type MembershipChecker interface {
+	IsMember(roomID, userID int64) (bool, error)
+}
+
+// UserLookup retrieves users by ID
+type UserLookup interface {
+	GetUserByID(id int64) (*models.User, error)
+}
+
+// ReactionCounter aggregates the reactions of messages
+type ReactionCounter interface {
+	GetReactionCounts(messageIDs []int64) (map[int64][]models.ReactionCount, error)
+}
+
+// AttachmentLister retrieves the attachments of messages
+type AttachmentLister interface {
+	GetAttachmentsForMessages(messageIDs []int64) (map[int64][]*models.Attachment, error)
+}
+
+// MessageHandler handles message history HTTP requests
+type MessageHandler struct {
+	messageRepo    MessageRepository
+	roomRepo       MembershipChecker
+	userRepo       UserLookup
+	reactionRepo   ReactionCounter
+	attachmentRepo AttachmentLister
+}
+
+// NewMessageHandler creates a new message handler
+func NewMessageHandler(messageRepo MessageRepository, roomRepo MembershipChecker, userRepo UserLookup, reactionRepo ReactionCounter, attachmentRepo AttachmentLister) *MessageHandler {
+	return &MessageHandler{
+		messageRepo:    messageRepo,
+		roomRepo:       roomRepo,
+		userRepo:       userRepo,
+		reactionRepo:   reactionRepo,
+		attachmentRepo: attachmentRepo,
+	}
+}
+
+// GlobalHistory returns a page of messages from the global channel
+func (h *MessageHandler) GlobalHistory(c *fiber.Ctx) error {
+	return h.history(c, func(beforeID, afterID int64, limit int) ([]*models.Message, error) {
+		return h.messageRepo.ListMessages(0, beforeID, afterID, limit)
+	})
+}
+
+// RoomHistory returns a page of messages from a room the caller belongs to
+func (h *MessageHandler) RoomHistory(c *fiber.Ctx) error {
+	userID := CurrentPrincipal(c).UserID
+
+	roomID, err := c.ParamsInt("id")
+	if err != nil || roomID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room ID",
+		})
+	}
+
+	// Only members may read a room's history
+	isMember, err := h.roomRepo.IsMember(int64(roomID), userID)
+	if err != nil {
+		logging.Errorf("Error checking membership of room %d: %v", roomID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load messages",
+		})
+	}
+	if !isMember {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Not a member of this room",
+		})
+	}
+
+	return h.history(c, func(beforeID, afterID int64, limit int) ([]*models.Message, error) {
+		return h.messageRepo.ListMessages(int64(roomID), beforeID, afterID, limit)
+	})
+}
+
+// DirectHistory returns a page of the caller's direct conversation with another user
+func (h *MessageHandler) DirectHistory(c *fiber.Ctx) error {
+	userID := CurrentPrincipal(c).UserID
+
+	peerID, err := c.ParamsInt("userId")
+	if err != nil || peerID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	if _, err := h.userRepo.GetUserByID(int64(peerID)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		logging.Errorf("Error getting user %d: %v", peerID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load messages",
+		})
+	}
+
+	return h.history(c, func(beforeID, afterID int64, limit int) ([]*models.Message, error) {
+		return h.messageRepo.ListDirectMessages(userID, int64(peerID), beforeID, afterID, limit)
+	})
+}
+
+// Thread returns a page of the replies to a message the caller can see
+func (h *MessageHandler) Thread(c *fiber.Ctx) error {
+	userID := CurrentPrincipal(c).UserID
+
+	messageID, err := c.ParamsInt("id")
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	parent, err := h.messageRepo.GetMessageByID(int64(messageID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Message not found",
+			})
+		}
+		logging.Errorf("Error getting message %d: %v", messageID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load messages",
+		})
+	}
+
+	// Threads are visible to whoever can see the parent; hidden messages
+	// are reported as missing
+	canSee, err := parent.VisibleTo(userID, h.roomRepo)
+	if err != nil {
+		logging.Errorf("Error checking access to message %d: %v", messageID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load messages",
+		})
+	}
+	if !canSee {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Message not found",
+		})
+	}
+
+	return h.history(c, func(beforeID, afterID int64, limit int) ([]*models.Message, error) {
+		return h.messageRepo.ListReplies(parent.ID, beforeID, afterID, limit)
+	})
+}
+
+// queryCursor parses a message ID cursor from the query string; a missing
+// cursor is 0, while a malformed one is an error rather than the latest page
+func queryCursor(c *fiber.Ctx, key string) (int64, error) {
+	value := c.Query(key)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// history parses the pagination cursor and writes a page of messages
+func (h *MessageHandler) history(c *fiber.Ctx, list pageLister) error {
+	beforeID, beforeErr := queryCursor(c, "before")
+	afterID, afterErr := queryCursor(c, "after")
+	limit := c.QueryInt("limit", defaultHistoryLimit)
+
+	if beforeErr != nil || afterErr != nil || beforeID < 0 || afterID < 0 || (beforeID > 0 && afterID > 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Use either a positive before or after cursor, not both",
+		})
+	}
+	if limit <= 0 || limit > maxHistoryLimit {
+		limit = defaultHistoryLimit
+	}
+
+	// Fetch one extra message to know whether another page exists
+	messages, err := list(beforeID, afterID, limit+1)
+	if err != nil {
+		logging.Errorf("Error listing messages: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load messages",
+		})
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		// Drop the extra message from the end furthest from the cursor
+		if afterID > 0 {
+			messages = messages[:limit]
+		} else {
+			messages = messages[1:]
+		}
+	}
+
+	// Attach the aggregated reactions and the uploads of the page
+	ids := make([]int64, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	reactions, err := h.reactionRepo.GetReactionCounts(ids)
+	if err != nil {
+		logging.Errorf("Error getting reaction counts: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load messages",
+		})
+	}
+	attachments, err := h.attachmentRepo.GetAttachmentsForMessages(ids)
+	if err != nil {
+		logging.Errorf("Error getting attachments: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load messages",
+		})
+	}
+
+	frames := make([]*chat.ChatMessage, 0, len(messages))
+	for _, message := range messages {
+		message.Reactions = reactions[message.ID]
+		// Deleted messages keep no visible content
+		if message.DeletedAt == nil {
+			message.Attachments = attachments[message.ID]
+		}
+		frames = append(frames, chat.NewChatMessage(message))
+	}
+
+	return c.JSON(fiber.Map{
+		"messages": frames,
+		"has_more": hasMore,
+	})
+}
+
+// EditMessageRequest represents a request to change a message's content
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// EditMessage replaces the content of a message written by the caller, or any
+// message when the caller is a moderator
+func (h *MessageHandler) EditMessage(c *fiber.Ctx) error {
+	userID := CurrentPrincipal(c).UserID
+
+	messageID, err := c.ParamsInt("id")
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	var req EditMessageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// The hub checks permissions and notifies connected clients
+	message, err := ChatHub.EditMessage(userID, int64(messageID), req.Content)
+	if err != nil {
+		return hubError(c, err, "Failed to edit message")
+	}
+
+	return c.JSON(chat.NewChatMessage(message))
+}
+
+// DeleteMessage deletes a message written by the caller, or any message when
+// the caller is a moderator
+func (h *MessageHandler) DeleteMessage(c *fiber.Ctx) error {
+	userID := CurrentPrincipal(c).UserID
+
+	messageID, err := c.ParamsInt("id")
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	if _, err := ChatHub.DeleteMessage(userID, int64(messageID)); err != nil {
+		return hubError(c, err, "Failed to delete message")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// MessageRevisions returns the edit and deletion history of a message to its
+// author or a moderator who can see it
+func (h *MessageHandler) MessageRevisions(c *fiber.Ctx) error {
+	userID := CurrentPrincipal(c).UserID
+
+	messageID, err := c.ParamsInt("id")
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	revisions, err := ChatHub.MessageRevisions(userID, int64(messageID))
+	if err != nil {
+		return hubError(c, err, "Failed to load message revisions")
+	}
+
+	return c.JSON(fiber.Map{
+		"revisions": revisions,
+	})
+}
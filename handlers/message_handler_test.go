@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/models"
+)
+
+func TestHistoryPagination(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.app.Get("/messages", NewMessageHandler(f.store, f.store, f.store, f.store, f.store).GlobalHistory)
+
+	// Messages one to five, oldest first
+	ids := make([]int64, 5)
+	for i := range ids {
+		message := &models.Message{UserID: f.bob.ID, Content: fmt.Sprintf("message %d", i+1)}
+		if err := f.store.CreateMessage(message); err != nil {
+			t.Fatalf("CreateMessage: %v", err)
+		}
+		ids[i] = message.ID
+	}
+
+	tests := []struct {
+		name        string
+		query       string // %d verbs are replaced by the IDs of the messages given by cursor
+		cursor      []int  // 1-based message numbers
+		wantCode    int
+		wantNumbers []int // Returned message numbers, in order
+		wantMore    bool
+	}{
+		{name: "latest page", query: "limit=2", wantCode: fiber.StatusOK, wantNumbers: []int{4, 5}, wantMore: true},
+		{name: "before a cursor", query: "before=%d&limit=2", cursor: []int{4}, wantCode: fiber.StatusOK, wantNumbers: []int{2, 3}, wantMore: true},
+		{name: "before reaching the start", query: "before=%d&limit=2", cursor: []int{3}, wantCode: fiber.StatusOK, wantNumbers: []int{1, 2}},
+		{name: "after a cursor", query: "after=%d&limit=2", cursor: []int{2}, wantCode: fiber.StatusOK, wantNumbers: []int{3, 4}, wantMore: true},
+		{name: "after reaching the end", query: "after=%d&limit=2", cursor: []int{3}, wantCode: fiber.StatusOK, wantNumbers: []int{4, 5}},
+		{name: "after the newest", query: "after=%d", cursor: []int{5}, wantCode: fiber.StatusOK, wantNumbers: []int{}},
+		{name: "limit equal to the total", query: "limit=5", wantCode: fiber.StatusOK, wantNumbers: []int{1, 2, 3, 4, 5}},
+		{name: "zero limit uses the default", query: "limit=0", wantCode: fiber.StatusOK, wantNumbers: []int{1, 2, 3, 4, 5}},
+		{name: "negative limit uses the default", query: "limit=-3", wantCode: fiber.StatusOK, wantNumbers: []int{1, 2, 3, 4, 5}},
+		{name: "limit over the maximum uses the default", query: fmt.Sprintf("limit=%d", maxHistoryLimit+1), wantCode: fiber.StatusOK, wantNumbers: []int{1, 2, 3, 4, 5}},
+		{name: "malformed limit uses the default", query: "limit=ten", wantCode: fiber.StatusOK, wantNumbers: []int{1, 2, 3, 4, 5}},
+		{name: "negative before", query: "before=-1", wantCode: fiber.StatusBadRequest},
+		{name: "negative after", query: "after=-1", wantCode: fiber.StatusBadRequest},
+		{name: "malformed before", query: "before=abc", wantCode: fiber.StatusBadRequest},
+		{name: "malformed after", query: "after=2x", wantCode: fiber.StatusBadRequest},
+		{name: "both cursors", query: "before=%d&after=%d", cursor: []int{4, 2}, wantCode: fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := make([]interface{}, len(tt.cursor))
+			for i, number := range tt.cursor {
+				args[i] = ids[number-1]
+			}
+
+			code, body := f.sendJSON(t, f.alice, fiber.MethodGet, "/messages?"+fmt.Sprintf(tt.query, args...), "")
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if code != fiber.StatusOK {
+				return
+			}
+
+			messages, _ := body["messages"].([]interface{})
+			numbers := make([]int, len(messages))
+			for i, message := range messages {
+				id := int64(message.(map[string]interface{})["id"].(float64))
+				for number, want := range ids {
+					if id == want {
+						numbers[i] = number + 1
+					}
+				}
+			}
+			if !reflect.DeepEqual(numbers, tt.wantNumbers) {
+				t.Errorf("messages = %v, want %v", numbers, tt.wantNumbers)
+			}
+			if body["has_more"] != tt.wantMore {
+				t.Errorf("has_more = %v, want %v", body["has_more"], tt.wantMore)
+			}
+		})
+	}
+}
+
+// createConversation stores five direct messages between alice and bob,
+// alternating from alice, with a message from alice to carol in between, and
+// returns the IDs of the alice and bob messages, oldest first
+func createConversation(t *testing.T, f *handlerFixture) []int64 {
+	t.Helper()
+
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		message := &models.Message{UserID: f.alice.ID, RecipientID: f.bob.ID, Content: fmt.Sprintf("message %d", i+1)}
+		if i%2 == 1 {
+			message.UserID, message.RecipientID = f.bob.ID, f.alice.ID
+		}
+		if err := f.store.CreateMessage(message); err != nil {
+			t.Fatalf("CreateMessage: %v", err)
+		}
+		ids = append(ids, message.ID)
+
+		if i == 2 {
+			aside := &models.Message{UserID: f.alice.ID, RecipientID: f.carol.ID, Content: "just between us"}
+			if err := f.store.CreateMessage(aside); err != nil {
+				t.Fatalf("CreateMessage: %v", err)
+			}
+		}
+	}
+	return ids
+}
+
+func TestDirectHistoryPagination(t *testing.T) {
+	f := newHandlerFixture(t)
+	ids := createConversation(t, f)
+	f.app.Get("/direct-messages/:userId", NewMessageHandler(f.store, f.store, f.store, f.store, f.store).DirectHistory)
+	bob, alice := fmt.Sprint(f.bob.ID), fmt.Sprint(f.alice.ID)
+
+	tests := []struct {
+		name        string
+		caller      *models.User
+		path        string // %d verbs are replaced by the IDs of the messages given by cursor
+		cursor      []int  // 1-based message numbers
+		wantCode    int
+		wantNumbers []int
+		wantMore    bool
+	}{
+		{name: "whole conversation", caller: f.alice, path: "/direct-messages/" + bob, wantCode: fiber.StatusOK, wantNumbers: []int{1, 2, 3, 4, 5}},
+		{name: "seen by the other participant", caller: f.bob, path: "/direct-messages/" + alice, wantCode: fiber.StatusOK, wantNumbers: []int{1, 2, 3, 4, 5}},
+		{name: "latest page", caller: f.alice, path: "/direct-messages/" + bob + "?limit=2", wantCode: fiber.StatusOK, wantNumbers: []int{4, 5}, wantMore: true},
+		{name: "before a cursor", caller: f.bob, path: "/direct-messages/" + alice + "?before=%d&limit=2", cursor: []int{4}, wantCode: fiber.StatusOK, wantNumbers: []int{2, 3}, wantMore: true},
+		{name: "before reaching the start", caller: f.alice, path: "/direct-messages/" + bob + "?before=%d&limit=2", cursor: []int{3}, wantCode: fiber.StatusOK, wantNumbers: []int{1, 2}},
+		{name: "after a cursor", caller: f.alice, path: "/direct-messages/" + bob + "?after=%d&limit=2", cursor: []int{1}, wantCode: fiber.StatusOK, wantNumbers: []int{2, 3}, wantMore: true},
+		{name: "after reaching the end", caller: f.alice, path: "/direct-messages/" + bob + "?after=%d&limit=2", cursor: []int{3}, wantCode: fiber.StatusOK, wantNumbers: []int{4, 5}},
+		{name: "unknown user", caller: f.alice, path: "/direct-messages/999", wantCode: fiber.StatusNotFound},
+		{name: "malformed user ID", caller: f.alice, path: "/direct-messages/bob", wantCode: fiber.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := make([]interface{}, len(tt.cursor))
+			for i, number := range tt.cursor {
+				args[i] = ids[number-1]
+			}
+
+			code, body := f.sendJSON(t, tt.caller, fiber.MethodGet, fmt.Sprintf(tt.path, args...), "")
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if code != fiber.StatusOK {
+				return
+			}
+
+			messages, _ := body["messages"].([]interface{})
+			numbers := make([]int, len(messages))
+			for i, message := range messages {
+				id := int64(message.(map[string]interface{})["id"].(float64))
+				for number, want := range ids {
+					if id == want {
+						numbers[i] = number + 1
+					}
+				}
+			}
+			if !reflect.DeepEqual(numbers, tt.wantNumbers) {
+				t.Errorf("messages = %v, want %v", numbers, tt.wantNumbers)
+			}
+			if body["has_more"] != tt.wantMore {
+				t.Errorf("has_more = %v, want %v", body["has_more"], tt.wantMore)
+			}
+		})
+	}
+}
+
+// A user only ever reads their own conversations: naming either participant
+// of someone else's conversation shows carol her own messages with them
+func TestDirectHistoryPrivacy(t *testing.T) {
+	f := newHandlerFixture(t)
+	createConversation(t, f)
+	f.app.Get("/direct-messages/:userId", NewMessageHandler(f.store, f.store, f.store, f.store, f.store).DirectHistory)
+
+	for _, peer := range []*models.User{f.alice, f.bob} {
+		code, body := f.sendJSON(t, f.carol, fiber.MethodGet, fmt.Sprintf("/direct-messages/%d", peer.ID), "")
+		if code != fiber.StatusOK {
+			t.Fatalf("with %s: status = %d (body %v)", peer.Username, code, body)
+		}
+
+		messages, _ := body["messages"].([]interface{})
+		for _, entry := range messages {
+			message := entry.(map[string]interface{})
+			if message["user_id"] != float64(f.carol.ID) && message["recipient_id"] != float64(f.carol.ID) {
+				t.Errorf("with %s: carol can read %v", peer.Username, message)
+			}
+		}
+
+		// Alice wrote to carol once; bob never did
+		want := 0
+		if peer == f.alice {
+			want = 1
+		}
+		if len(messages) != want {
+			t.Errorf("with %s: %d messages, want %d", peer.Username, len(messages), want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/logging"
+	"gochat/models"
+)
+
+// ReadMarkerRepository defines the interface for read marker operations
+type ReadMarkerRepository interface {
+	GetUnreadCounts(userID int64) ([]*models.UnreadCount, error)
+}
+
+// ReadMarkerHandler handles read marker requests
+type ReadMarkerHandler struct {
+	readRepo ReadMarkerRepository
+}
+
+// NewReadMarkerHandler creates a new read marker handler
+func NewReadMarkerHandler(readRepo ReadMarkerRepository) *ReadMarkerHandler {
+	return &ReadMarkerHandler{
+		readRepo: readRepo,
+	}
+}
+
+// MarkReadRequest represents a request to move a read marker. Both
+// conversation IDs zero means the global channel.
+type MarkReadRequest struct {
+	RoomID      int64 `json:"room_id"`
+	RecipientID int64 `json:"recipient_id"`
+	MessageID   int64 `json:"message_id"`
+}
+
+// MarkRead records that the current user has read a conversation up to a message
+func (h *ReadMarkerHandler) MarkRead(c *fiber.Ctx) error {
+	principal := CurrentPrincipal(c)
+
+	var req MarkReadRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// The hub validates the request and notifies the other participants
+	marker, err := ChatHub.MarkRead(principal.UserID, req.RoomID, req.RecipientID, req.MessageID)
+	if err != nil {
+		return hubError(c, err, "Failed to update read marker")
+	}
+
+	return c.JSON(marker)
+}
+
+// UnreadCounts returns the current user's unread message counts per conversation
+func (h *ReadMarkerHandler) UnreadCounts(c *fiber.Ctx) error {
+	principal := CurrentPrincipal(c)
+
+	counts, err := h.readRepo.GetUnreadCounts(principal.UserID)
+	if err != nil {
+		logging.Errorf("Error getting unread counts: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get unread counts",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"unread": counts,
+	})
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/database/memory"
+	"gochat/models"
+)
+
+func TestUnreadCounts(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.app.Get("/unread", NewReadMarkerHandler(f.store).UnreadCounts)
+
+	post := func(message *models.Message) *models.Message {
+		t.Helper()
+		if err := f.store.CreateMessage(message); err != nil {
+			t.Fatalf("CreateMessage: %v", err)
+		}
+		return message
+	}
+	first := post(&models.Message{UserID: f.bob.ID, Content: "one"})
+	post(&models.Message{UserID: f.bob.ID, Content: "two"})
+	post(&models.Message{UserID: f.bob.ID, RoomID: f.room.ID, Content: "in the room"})
+	post(&models.Message{UserID: f.carol.ID, RecipientID: f.alice.ID, Content: "psst"})
+	if _, err := f.store.MarkRead(f.alice.ID, 0, 0, first.ID); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+
+	tests := []struct {
+		user *models.User
+		want map[string]float64 // Count by "room <id> peer <id>"
+	}{
+		{f.alice, map[string]float64{
+			"room 0 peer 0":                           1,
+			fmt.Sprintf("room %d peer 0", f.room.ID):  1,
+			fmt.Sprintf("room 0 peer %d", f.carol.ID): 1,
+		}},
+		{f.carol, map[string]float64{
+			"room 0 peer 0": 2,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user.Username, func(t *testing.T) {
+			code, body := f.sendJSON(t, tt.user, fiber.MethodGet, "/unread", "")
+			if code != fiber.StatusOK {
+				t.Fatalf("status = %d, want %d (body %v)", code, fiber.StatusOK, body)
+			}
+
+			unread, _ := body["unread"].([]interface{})
+			got := make(map[string]float64)
+			for _, entry := range unread {
+				// Zero IDs are omitted
+				count := entry.(map[string]interface{})
+				roomID, _ := count["room_id"].(float64)
+				peerID, _ := count["peer_id"].(float64)
+				got[fmt.Sprintf("room %v peer %v", roomID, peerID)] = count["count"].(float64)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("unread = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// failingReadMarkerStore fails every unread count lookup
+type failingReadMarkerStore struct {
+	*memory.Store
+}
+
+func (s failingReadMarkerStore) GetUnreadCounts(int64) ([]*models.UnreadCount, error) {
+	return nil, errors.New("query unread counts: connection reset")
+}
+
+func TestUnreadCountsFailure(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.app.Get("/unread", NewReadMarkerHandler(failingReadMarkerStore{f.store}).UnreadCounts)
+
+	code, body := f.sendJSON(t, f.alice, fiber.MethodGet, "/unread", "")
+	if code != fiber.StatusInternalServerError || body["error"] != "Failed to get unread counts" {
+		t.Errorf("status = %d, body %v; want %d", code, body, fiber.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/logging"
+	"gochat/models"
+	"gochat/validation"
+)
+
+// RoomRepository defines the interface for room database operations
+type RoomRepository interface {
+	CreateRoom(room *models.Room, creatorID int64) error
+	GetRoomByID(id int64) (*models.Room, error)
+	GetRoomByName(name string) (*models.Room, error)
+	ListRooms() ([]*models.Room, error)
+	AddMember(roomID, userID int64) error
+	RemoveMember(roomID, userID int64) error
+}
+
+// RoomHandler handles room-related HTTP requests
+type RoomHandler struct {
+	roomRepo RoomRepository
+}
+
+// NewRoomHandler creates a new room handler
+func NewRoomHandler(roomRepo RoomRepository) *RoomHandler {
+	return &RoomHandler{
+		roomRepo: roomRepo,
+	}
+}
+
+// CreateRoomRequest represents the room creation request
+type CreateRoomRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateRoom handles room creation; the creator becomes the first member
+func (h *RoomHandler) CreateRoom(c *fiber.Ctx) error {
+	userID := CurrentPrincipal(c).UserID
+
+	// Parse request body
+	var req CreateRoomRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request data",
+		})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validation.RoomName(req.Name); err != nil {
+		return invalidFields(c, validation.Errors{err})
+	}
+
+	// Check if room name already exists
+	existingRoom, err := h.roomRepo.GetRoomByName(req.Name)
+	if err == nil && existingRoom != nil {
+		return fieldTaken(c, "name", "Room already exists")
+	}
+
+	// Save room to database together with the creator's membership
+	room := &models.Room{Name: req.Name}
+	if err := h.roomRepo.CreateRoom(room, userID); err != nil {
+		// Another request may have created the room since the check above
+		var duplicate *models.DuplicateError
+		if errors.As(err, &duplicate) {
+			return fieldTaken(c, "name", "Room already exists")
+		}
+		logging.Errorf("Error creating room: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create room",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(room)
+}
+
+// ListRooms returns all rooms
+func (h *RoomHandler) ListRooms(c *fiber.Ctx) error {
+	rooms, err := h.roomRepo.ListRooms()
+	if err != nil {
+		logging.Errorf("Error listing rooms: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list rooms",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"rooms": rooms,
+	})
+}
+
+// JoinRoom adds the authenticated user to a room
+func (h *RoomHandler) JoinRoom(c *fiber.Ctx) error {
+	userID, room, fiberErr := h.resolveMembershipRequest(c)
+	if fiberErr != nil {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{
+			"error": fiberErr.Message,
+		})
+	}
+
+	if err := h.roomRepo.AddMember(room.ID, userID); err != nil {
+		logging.Errorf("Error joining room %d: %v", room.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to join room",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"room_id": room.ID,
+		"user_id": userID,
+		"joined":  true,
+	})
+}
+
+// LeaveRoom removes the authenticated user from a room
+func (h *RoomHandler) LeaveRoom(c *fiber.Ctx) error {
+	userID, room, fiberErr := h.resolveMembershipRequest(c)
+	if fiberErr != nil {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{
+			"error": fiberErr.Message,
+		})
+	}
+
+	if err := h.roomRepo.RemoveMember(room.ID, userID); err != nil {
+		logging.Errorf("Error leaving room %d: %v", room.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to leave room",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"room_id": room.ID,
+		"user_id": userID,
+		"joined":  false,
+	})
+}
+
+// resolveMembershipRequest returns the caller and the room from the :id parameter
+func (h *RoomHandler) resolveMembershipRequest(c *fiber.Ctx) (int64, *models.Room, *fiber.Error) {
+	userID := CurrentPrincipal(c).UserID
+
+	roomID, err := c.ParamsInt("id")
+	if err != nil || roomID <= 0 {
+		return 0, nil, fiber.NewError(fiber.StatusBadRequest, "Invalid room ID")
+	}
+
+	room, err := h.roomRepo.GetRoomByID(int64(roomID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil, fiber.NewError(fiber.StatusNotFound, "Room not found")
+		}
+		logging.Errorf("Error getting room %d: %v", roomID, err)
+		return 0, nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to load room")
+	}
+
+	return userID, room, nil
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/database/memory"
+	"gochat/models"
+	"gochat/validation"
+)
+
+func TestCreateRoom(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.app.Post("/rooms", NewRoomHandler(f.store).CreateRoom)
+	if code, body := f.sendJSON(t, f.alice, fiber.MethodPost, "/rooms", `{"name":"general"}`); code != fiber.StatusCreated {
+		t.Fatalf("creating general: status %d (body %v)", code, body)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantFields map[string]string // Rejected field and error code
+	}{
+		{"valid", `{"name":"Team #3 (ops)"}`, fiber.StatusCreated, nil},
+		{"trimmed", `{"name":"  random  "}`, fiber.StatusCreated, nil},
+		{"missing", `{}`, fiber.StatusBadRequest, map[string]string{"name": validation.CodeRequired}},
+		{"blank", `{"name":"   "}`, fiber.StatusBadRequest, map[string]string{"name": validation.CodeRequired}},
+		{"too long", `{"name":"` + strings.Repeat("a", validation.MaxRoomNameLength+1) + `"}`, fiber.StatusBadRequest, map[string]string{"name": validation.CodeTooLong}},
+		{"line break", `{"name":"one\ntwo"}`, fiber.StatusBadRequest, map[string]string{"name": validation.CodeInvalid}},
+		{"taken", `{"name":"general"}`, fiber.StatusConflict, map[string]string{"name": validation.CodeTaken}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := f.sendJSON(t, f.alice, fiber.MethodPost, "/rooms", tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if fields := fieldCodes(body); !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+
+	// Names are stored trimmed, and the creator joins the room
+	room, err := f.store.GetRoomByName("random")
+	if err != nil {
+		t.Fatalf("GetRoomByName: %v", err)
+	}
+	if member, _ := f.store.IsMember(room.ID, f.alice.ID); !member {
+		t.Error("creator is not a member")
+	}
+}
+
+// Joining and leaving only change the caller's membership; the steps run
+// in order as carol, who starts outside alice and bob's room
+func TestJoinAndLeaveRoom(t *testing.T) {
+	f := newHandlerFixture(t)
+	handler := NewRoomHandler(f.store)
+	f.app.Post("/rooms/:id/join", handler.JoinRoom)
+	f.app.Post("/rooms/:id/leave", handler.LeaveRoom)
+	room := fmt.Sprint(f.room.ID)
+
+	tests := []struct {
+		name        string
+		path        string
+		wantCode    int
+		wantJoined  bool
+		wantMembers []int64 // Members of the room afterwards
+	}{
+		{"join", "/rooms/" + room + "/join", fiber.StatusOK, true, []int64{f.alice.ID, f.bob.ID, f.carol.ID}},
+		{"join again", "/rooms/" + room + "/join", fiber.StatusOK, true, []int64{f.alice.ID, f.bob.ID, f.carol.ID}},
+		{"leave", "/rooms/" + room + "/leave", fiber.StatusOK, false, []int64{f.alice.ID, f.bob.ID}},
+		{"leave when not a member", "/rooms/" + room + "/leave", fiber.StatusOK, false, []int64{f.alice.ID, f.bob.ID}},
+		{"join an unknown room", "/rooms/999/join", fiber.StatusNotFound, false, []int64{f.alice.ID, f.bob.ID}},
+		{"leave an unknown room", "/rooms/999/leave", fiber.StatusNotFound, false, []int64{f.alice.ID, f.bob.ID}},
+		{"malformed room ID", "/rooms/secret/join", fiber.StatusBadRequest, false, []int64{f.alice.ID, f.bob.ID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := f.sendJSON(t, f.carol, fiber.MethodPost, tt.path, "")
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if code == fiber.StatusOK {
+				if body["room_id"] != float64(f.room.ID) || body["user_id"] != float64(f.carol.ID) || body["joined"] != tt.wantJoined {
+					t.Errorf("body = %v, want carol with joined %v", body, tt.wantJoined)
+				}
+			}
+
+			members, err := f.store.GetMemberIDs(f.room.ID)
+			if err != nil {
+				t.Fatalf("GetMemberIDs: %v", err)
+			}
+			sort.Slice(members, func(i, j int) bool { return members[i] < members[j] })
+			if !reflect.DeepEqual(members, tt.wantMembers) {
+				t.Errorf("members = %v, want %v", members, tt.wantMembers)
+			}
+		})
+	}
+}
+
+// racingRoomStore hides existing rooms from lookups, as if they were created
+// by a concurrent request after the handler checked for them
+type racingRoomStore struct {
+	*memory.Store
+}
+
+func (s racingRoomStore) GetRoomByName(string) (*models.Room, error) { return nil, sql.ErrNoRows }
+
+// A name claimed between the check and the insert is reported like one found
+// by the check
+func TestCreateRoomRace(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.app.Post("/rooms", NewRoomHandler(racingRoomStore{f.store}).CreateRoom)
+
+	if code, _ := f.sendJSON(t, f.alice, fiber.MethodPost, "/rooms", `{"name":"general"}`); code != fiber.StatusCreated {
+		t.Fatalf("creating general: status %d", code)
+	}
+
+	code, body := f.sendJSON(t, f.bob, fiber.MethodPost, "/rooms", `{"name":"general"}`)
+	if code != fiber.StatusConflict || body["error"] != "Room already exists" {
+		t.Errorf("status = %d, body %v; want %d", code, body, fiber.StatusConflict)
+	}
+	if fields, want := fieldCodes(body), map[string]string{"name": validation.CodeTaken}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+}
+
+// failingRoomStore fails room creation as when adding the creator fails
+// within the transaction that creates the room
+type failingRoomStore struct {
+	*memory.Store
+}
+
+func (s failingRoomStore) CreateRoom(*models.Room, int64) error {
+	return errors.New("insert room member: connection reset")
+}
+
+// A failed creation leaves no room behind, so the name can be used again
+func TestCreateRoomFailure(t *testing.T) {
+	f := newHandlerFixture(t)
+	f.app.Post("/failing/rooms", NewRoomHandler(failingRoomStore{f.store}).CreateRoom)
+	f.app.Post("/rooms", NewRoomHandler(f.store).CreateRoom)
+
+	code, body := f.sendJSON(t, f.alice, fiber.MethodPost, "/failing/rooms", `{"name":"general"}`)
+	if code != fiber.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d (body %v)", code, fiber.StatusInternalServerError, body)
+	}
+	if _, err := f.store.GetRoomByName("general"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetRoomByName after a failed creation error = %v, want sql.ErrNoRows", err)
+	}
+
+	if code, body := f.sendJSON(t, f.alice, fiber.MethodPost, "/rooms", `{"name":"general"}`); code != fiber.StatusCreated {
+		t.Errorf("retry: status = %d, want %d (body %v)", code, fiber.StatusCreated, body)
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/chat"
+	"gochat/logging"
+	"gochat/models"
+)
+
+const (
+	// defaultSearchPageSize is the page size used when the client does not ask for one
+	defaultSearchPageSize = 20
+	// maxSearchPageSize caps the page size a client may request
+	maxSearchPageSize = 100
+	// maxSearchQueryLength caps the length of a search query in characters
+	maxSearchQueryLength = 256
+)
+
+// MessageSearcher runs full-text searches over messages
+type MessageSearcher interface {
+	SearchMessages(search models.MessageSearch) ([]*models.SearchResult, error)
+}
+
+// SearchHandler handles search requests
+type SearchHandler struct {
+	messageRepo MessageSearcher
+}
+
+// NewSearchHandler creates a new search handler
+func NewSearchHandler(messageRepo MessageSearcher) *SearchHandler {
+	return &SearchHandler{
+		messageRepo: messageRepo,
+	}
+}
+
+// SearchResult is a message matching a search. Snippet is HTML-escaped with
+// the matched terms wrapped in <mark> elements.
+type SearchResult struct {
+	Message *chat.ChatMessage `json:"message"`
+	Snippet string            `json:"snippet"`
+}
+
+// SearchMessages finds messages visible to the caller. Query parameters: q
+// (required), room_id, author_id, from and to (RFC 3339), limit and offset.
+// Timestamps are stored in UTC; from and to may use any offset and are
+// converted to UTC before being compared with them.
+func (h *SearchHandler) SearchMessages(c *fiber.Ctx) error {
+	search := models.MessageSearch{
+		UserID:   CurrentPrincipal(c).UserID,
+		Query:    strings.TrimSpace(c.Query("q")),
+		RoomID:   int64(c.QueryInt("room_id")),
+		AuthorID: int64(c.QueryInt("author_id")),
+		Limit:    c.QueryInt("limit", defaultSearchPageSize),
+		Offset:   c.QueryInt("offset"),
+	}
+
+	if search.Query == "" || utf8.RuneCountInString(search.Query) > maxSearchQueryLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Query must be between 1 and %d characters", maxSearchQueryLength),
+		})
+	}
+	if search.RoomID < 0 || search.AuthorID < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid room or author ID",
+		})
+	}
+
+	for param, target := range map[string]*time.Time{"from": &search.From, "to": &search.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Dates must use the RFC 3339 format, e.g. 2024-01-02T15:04:05Z",
+			})
+		}
+		*target = parsed
+	}
+
+	if search.Limit <= 0 || search.Limit > maxSearchPageSize {
+		search.Limit = defaultSearchPageSize
+	}
+	if search.Offset < 0 {
+		search.Offset = 0
+	}
+
+	// Fetch one extra result to know whether another page exists
+	pageSize := search.Limit
+	search.Limit++
+	results, err := h.messageRepo.SearchMessages(search)
+	if err != nil {
+		logging.Errorf("Error searching messages: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search messages",
+		})
+	}
+
+	hasMore := len(results) > pageSize
+	if hasMore {
+		results = results[:pageSize]
+	}
+
+	response := make([]*SearchResult, 0, len(results))
+	for _, result := range results {
+		response = append(response, &SearchResult{
+			Message: chat.NewChatMessage(result.Message),
+			Snippet: result.Snippet,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"results":  response,
+		"limit":    pageSize,
+		"offset":   search.Offset,
+		"has_more": hasMore,
+	})
+}
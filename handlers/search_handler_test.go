@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/models"
+)
+
+// searchFixture holds a channel message from alice, a message in the room of
+// alice and bob, a direct message from bob to alice and a channel message
+// from carol, posted an hour apart from searchEpoch in that order
+type searchFixture struct {
+	*handlerFixture
+	channel, private, direct, party int64 // Message IDs
+}
+
+// searchEpoch is when the first message of the search fixture was posted
+var searchEpoch = time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+
+// newSearchApp stores the searched messages and mounts the search route
+func newSearchApp(t *testing.T) *searchFixture {
+	t.Helper()
+
+	f := &searchFixture{handlerFixture: newHandlerFixture(t)}
+	messages := []*models.Message{
+		{UserID: f.alice.ID, Content: "deploying the release tonight"},
+		{UserID: f.bob.ID, RoomID: f.room.ID, Content: "release notes are in the room"},
+		{UserID: f.bob.ID, RecipientID: f.alice.ID, Content: "is the release ready?"},
+		{UserID: f.carol.ID, Content: "release party tomorrow"},
+	}
+	for i, message := range messages {
+		message.CreatedAt = searchEpoch.Add(time.Duration(i) * time.Hour)
+		if err := f.store.CreateMessage(message); err != nil {
+			t.Fatalf("CreateMessage: %v", err)
+		}
+	}
+	f.channel, f.private, f.direct, f.party = messages[0].ID, messages[1].ID, messages[2].ID, messages[3].ID
+
+	f.app.Get("/search", NewSearchHandler(f.store).SearchMessages)
+	return f
+}
+
+// search runs a search with the given query parameters as user
+func (f *searchFixture) search(t *testing.T, user *models.User, params url.Values) (int, map[string]interface{}) {
+	t.Helper()
+
+	resp := f.do(t, user, httptest.NewRequest(fiber.MethodGet, "/search?"+params.Encode(), nil))
+	return resp.StatusCode, decodeJSON(t, resp)
+}
+
+// resultIDs returns the sorted message IDs of a search response
+func resultIDs(body map[string]interface{}) []int64 {
+	results, _ := body["results"].([]interface{})
+	ids := []int64{}
+	for _, result := range results {
+		message, _ := result.(map[string]interface{})["message"].(map[string]interface{})
+		id, _ := message["id"].(float64)
+		ids = append(ids, int64(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestSearchMessages(t *testing.T) {
+	f := newSearchApp(t)
+
+	// Bounds in another offset than the stored times
+	east := time.FixedZone("east", 5*3600)
+	halfPastTen := searchEpoch.Add(30 * time.Minute).In(east).Format(time.RFC3339)
+	halfPastEleven := searchEpoch.Add(90 * time.Minute).In(east).Format(time.RFC3339)
+
+	tests := []struct {
+		name   string
+		user   *models.User
+		params url.Values
+		want   []int64
+	}{
+		{"member of the room and DM", f.alice, url.Values{"q": {"release"}}, []int64{f.channel, f.private, f.direct, f.party}},
+		{"outsider sees the channel only", f.carol, url.Values{"q": {"release"}}, []int64{f.channel, f.party}},
+		{"outsider searching the room", f.carol, url.Values{"q": {"release"}, "room_id": {fmt.Sprint(f.room.ID)}}, []int64{}},
+		{"member searching the room", f.bob, url.Values{"q": {"release"}, "room_id": {fmt.Sprint(f.room.ID)}}, []int64{f.private}},
+		{"outsider searching DM content", f.carol, url.Values{"q": {"ready"}}, []int64{}},
+		{"all terms must match", f.alice, url.Values{"q": {"release tonight"}}, []int64{f.channel}},
+		{"author", f.alice, url.Values{"q": {"release"}, "author_id": {fmt.Sprint(f.bob.ID)}}, []int64{f.private, f.direct}},
+		{"author outside the user's view", f.carol, url.Values{"q": {"release"}, "author_id": {fmt.Sprint(f.bob.ID)}}, []int64{}},
+		{"from", f.alice, url.Values{"q": {"release"}, "from": {halfPastTen}}, []int64{f.private, f.direct, f.party}},
+		{"to", f.alice, url.Values{"q": {"release"}, "to": {halfPastTen}}, []int64{f.channel}},
+		{"from and to", f.alice, url.Values{"q": {"release"}, "from": {halfPastTen}, "to": {halfPastEleven}}, []int64{f.private}},
+		{"to is exclusive", f.alice, url.Values{"q": {"release"}, "to": {searchEpoch.Format(time.RFC3339)}}, []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := f.search(t, tt.user, tt.params)
+			if code != fiber.StatusOK {
+				t.Fatalf("status = %d (body %v)", code, body)
+			}
+			want := append([]int64{}, tt.want...)
+			sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+			if got := resultIDs(body); !reflect.DeepEqual(got, want) {
+				t.Errorf("results = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSearchMessagesPages(t *testing.T) {
+	f := newSearchApp(t)
+
+	// Pages follow the results newest first
+	var seen []int64
+	for offset := 0; ; offset++ {
+		code, body := f.search(t, f.alice, url.Values{"q": {"release"}, "limit": {"1"}, "offset": {fmt.Sprint(offset)}})
+		if code != fiber.StatusOK || body["limit"] != float64(1) || body["offset"] != float64(offset) {
+			t.Fatalf("page %d: status %d, body %v", offset, code, body)
+		}
+		seen = append(seen, resultIDs(body)...)
+		if body["has_more"] != true {
+			break
+		}
+	}
+	if want := []int64{f.party, f.direct, f.private, f.channel}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("pages = %v, want %v", seen, want)
+	}
+
+	// Out-of-range page sizes fall back to the default
+	_, body := f.search(t, f.alice, url.Values{"q": {"release"}, "limit": {"1000"}})
+	if body["limit"] != float64(defaultSearchPageSize) {
+		t.Errorf("limit = %v, want %d", body["limit"], defaultSearchPageSize)
+	}
+}
+
+func TestSearchMessagesBadRequest(t *testing.T) {
+	f := newSearchApp(t)
+
+	tests := []struct {
+		name   string
+		params url.Values
+	}{
+		{"no query", url.Values{}},
+		{"blank query", url.Values{"q": {"   "}}},
+		{"long query", url.Values{"q": {strings.Repeat("a", maxSearchQueryLength+1)}}},
+		{"negative room", url.Values{"q": {"release"}, "room_id": {"-1"}}},
+		{"negative author", url.Values{"q": {"release"}, "author_id": {"-1"}}},
+		{"date without a time", url.Values{"q": {"release"}, "from": {"2024-01-02"}}},
+		{"unparsable date", url.Values{"q": {"release"}, "to": {"yesterday"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := f.search(t, f.alice, tt.params)
+			if code != fiber.StatusBadRequest || body["error"] == nil {
+				t.Errorf("status = %d, body %v; want %d with an error", code, body, fiber.StatusBadRequest)
+			}
+		})
+	}
+}
+
+// The query limit counts characters, so multibyte queries get the same
+// length as ASCII ones
+func TestSearchMessagesQueryLength(t *testing.T) {
+	f := newSearchApp(t)
+
+	query := strings.Repeat("検", maxSearchQueryLength)
+	if code, body := f.search(t, f.alice, url.Values{"q": {query}}); code != fiber.StatusOK {
+		t.Errorf("query of %d characters: status %d (body %v)", maxSearchQueryLength, code, body)
+	}
+
+	code, body := f.search(t, f.alice, url.Values{"q": {query + "検"}})
+	want := fmt.Sprintf("Query must be between 1 and %d characters", maxSearchQueryLength)
+	if code != fiber.StatusBadRequest || body["error"] != want {
+		t.Errorf("query of %d characters: status %d, body %v; want %d with %q", maxSearchQueryLength+1, code, body, fiber.StatusBadRequest, want)
+	}
+}
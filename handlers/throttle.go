@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/config"
+	"gochat/ratelimit"
+)
+
+// authThrottle locks out the IP addresses and usernames that make too many
+// failed attempts at an authentication endpoint
+type authThrottle struct {
+	ips       *ratelimit.Lockout
+	usernames *ratelimit.Lockout
+}
+
+// newAuthThrottle creates a throttle with the given limits
+func newAuthThrottle(cfg config.AuthLimitConfig) *authThrottle {
+	return &authThrottle{
+		ips:       ratelimit.NewLockout(cfg.MaxPerIP, cfg.Window.Duration, cfg.Lockout.Duration),
+		usernames: ratelimit.NewLockout(cfg.MaxPerUsername, cfg.Window.Duration, cfg.Lockout.Duration),
+	}
+}
+
+// locked reports whether the IP address or the username is locked out and,
+// if so, for how much longer
+func (t *authThrottle) locked(ip, username string) (time.Duration, bool) {
+	retryAfter, ipLocked := t.ips.Locked(ip)
+	if userRetryAfter, userLocked := t.usernames.Locked(usernameKey(username)); userLocked {
+		return max(retryAfter, userRetryAfter), true
+	}
+	return retryAfter, ipLocked
+}
+
+// record counts a failed attempt from the IP address at the username
+func (t *authThrottle) record(ip, username string) {
+	t.ips.Record(ip)
+	if username != "" {
+		t.usernames.Record(usernameKey(username))
+	}
+}
+
+// reset forgets the attempts made at the username. Attempts from the IP
+// address still count, so one valid account cannot be used to keep
+// guessing the passwords of others.
+func (t *authThrottle) reset(username string) {
+	t.usernames.Reset(usernameKey(username))
+}
+
+// usernameKey makes differently cased spellings of a username share a
+// lockout
+func usernameKey(username string) string {
+	return strings.ToLower(username)
+}
+
+// tooManyAttempts responds to a locked-out client
+func tooManyAttempts(c *fiber.Ctx, retryAfter time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error": "Too many attempts, try again later",
+	})
+}
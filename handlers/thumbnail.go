@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	// thumbnailSize is the longest side of a generated thumbnail in pixels
+	thumbnailSize = 320
+
+	// maxThumbnailPixels skips thumbnails for images too large to decode safely
+	maxThumbnailPixels = 40_000_000
+)
+
+// errImageTooLarge is returned for images with more than maxThumbnailPixels
+var errImageTooLarge = errors.New("image too large for a thumbnail")
+
+// thumbnailFormats are the upload types a thumbnail can be generated for
+var thumbnailFormats = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// imageSize reads the dimensions of an image without decoding it
+func imageSize(r io.Reader) (int, int, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// makeThumbnail decodes an image and encodes a copy scaled to fit within
+// thumbnailSize; JPEG sources give a JPEG thumbnail and others a PNG
+func makeThumbnail(r io.Reader, contentType string, width, height int) ([]byte, error) {
+	if width*height > maxThumbnailPixels {
+		return nil, errImageTooLarge
+	}
+
+	var src image.Image
+	var err error
+	switch contentType {
+	case "image/png":
+		src, err = png.Decode(r)
+	case "image/jpeg":
+		src, err = jpeg.Decode(r)
+	case "image/gif":
+		src, err = gif.Decode(r)
+	default:
+		return nil, errors.New("unsupported image type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := scaleDown(src, thumbnailSize)
+
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// thumbnailContentType is the type of the thumbnail generated for an upload
+func thumbnailContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// scaleDown shrinks an image to fit within maxSide pixels, averaging the
+// source pixels covered by each target pixel. Smaller images are returned
+// unchanged.
+func scaleDown(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= maxSide && srcH <= maxSide {
+		return src
+	}
+
+	dstW, dstH := maxSide, maxSide
+	if srcW > srcH {
+		dstH = max(1, srcH*maxSide/srcW)
+	} else {
+		dstW = max(1, srcW*maxSide/srcH)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/dstH)
+
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/dstW)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
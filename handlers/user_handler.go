@@ -1,30 +1,83 @@
 package handlers
 
 import (
-	"log"
+	"errors"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/crypto/bcrypt"
 
+	"gochat/config"
+	"gochat/logging"
 	"gochat/models" // Replace yourusername with your GitHub username
+	"gochat/validation"
 )
 
 // UserRepository defines the interface for user database operations
 type UserRepository interface {
 	CreateUser(user *models.User) error
 	GetUserByUsername(username string) (*models.User, error)
+	GetUserByID(id int64) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	ListUsers(filter models.UserFilter) ([]*models.User, int, error)
+	UpdateUserProfile(user *models.User) error
+}
+
+// SessionRepository defines the interface for session and refresh token database operations
+type SessionRepository interface {
+	CreateSession(session *models.Session) error
+	GetSession(id string) (*models.Session, error)
+	RevokeSession(id string) error
+	CreateRefreshToken(token *models.RefreshToken) error
+	GetRefreshToken(tokenHash string) (*models.RefreshToken, error)
+	MarkRefreshTokenUsed(tokenHash string) (bool, error)
 }
 
 // UserHandler handles user-related HTTP requests
 type UserHandler struct {
-	userRepo UserRepository
+	userRepo    UserRepository
+	sessionRepo SessionRepository
+
+	// Lockouts for failed logins and for registrations
+	loginThrottle    *authThrottle
+	registerThrottle *authThrottle
+
+	// Lowercased moderator usernames, which cannot be claimed by
+	// registering or renaming
+	moderators map[string]bool
 }
 
-// NewUserHandler creates a new user handler
-func NewUserHandler(userRepo UserRepository) *UserHandler {
-	return &UserHandler{
-		userRepo: userRepo,
+// NewUserHandler creates a new user handler with the default rate limits
+func NewUserHandler(userRepo UserRepository, sessionRepo SessionRepository) *UserHandler {
+	h := &UserHandler{
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
 	}
+	h.SetRateLimits(config.Default().RateLimit)
+	return h
+}
+
+// SetRateLimits replaces the login and registration limits, forgetting the
+// attempts counted so far
+func (h *UserHandler) SetRateLimits(cfg config.RateLimitConfig) {
+	h.loginThrottle = newAuthThrottle(cfg.Login)
+	h.registerThrottle = newAuthThrottle(cfg.Register)
+}
+
+// SetModerators reserves the usernames of the moderators. The chat hub
+// resolves them to accounts at startup, so a moderator account must exist
+// before it is listed and cannot be renamed afterwards.
+func (h *UserHandler) SetModerators(usernames []string) {
+	h.moderators = make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		h.moderators[strings.ToLower(username)] = true
+	}
+}
+
+// isModeratorName reports whether username matches a moderator's, ignoring
+// case so look-alike names are reserved as well
+func (h *UserHandler) isModeratorName(username string) bool {
+	return h.moderators[strings.ToLower(username)]
 }
 
 // RegisterRequest represents the user registration request
@@ -44,31 +97,45 @@ func (h *UserHandler) Register(c *fiber.Ctx) error {
 		})
 	}
 
-	// Basic validation
-	if req.Username == "" || req.Email == "" || req.Password == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Username, email and password are required",
-		})
+	// Refuse clients and usernames with too many rejected registrations
+	if retryAfter, locked := h.registerThrottle.locked(c.IP(), req.Username); locked {
+		return tooManyAttempts(c, retryAfter)
 	}
 
-	if len(req.Password) < 6 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Password must be at least 6 characters",
-		})
+	// Only rejections count, so usernames and emails cannot be probed while
+	// many people behind one address can still sign up
+	reject := func(err error) error {
+		h.registerThrottle.record(c.IP(), req.Username)
+		return err
 	}
 
-	// Check if username already exists
-	existingUser, err := h.userRepo.GetUserByUsername(req.Username)
-	if err == nil && existingUser != nil {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-			"error": "Username already exists",
-		})
+	// Emails are stored and compared in lower case
+	req.Email = strings.ToLower(req.Email)
+
+	// Check every field so clients can show all problems at once
+	var errs validation.Errors
+	errs.Add(validation.Username(req.Username))
+	errs.Add(validation.Email(req.Email))
+	errs.Add(validation.Password(req.Password))
+	if len(errs) > 0 {
+		return reject(invalidFields(c, errs))
+	}
+
+	// Usernames and emails identify a single account
+	if h.isModeratorName(req.Username) {
+		return reject(fieldReserved(c, "username", "Username is reserved"))
+	}
+	if existingUser, err := h.userRepo.GetUserByUsername(req.Username); err == nil && existingUser != nil {
+		return reject(userFieldTaken(c, "username"))
+	}
+	if existingUser, err := h.userRepo.GetUserByEmail(req.Email); err == nil && existingUser != nil {
+		return reject(userFieldTaken(c, "email"))
 	}
 
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("Error hashing password: %v", err)
+		logging.Errorf("Error hashing password: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to process registration",
 		})
@@ -84,7 +151,13 @@ func (h *UserHandler) Register(c *fiber.Ctx) error {
 
 	// Save user to database
 	if err := h.userRepo.CreateUser(user); err != nil {
-		log.Printf("Error creating user: %v", err)
+		// A concurrent registration may have claimed the username or email
+		// since the checks above
+		var duplicate *models.DuplicateError
+		if errors.As(err, &duplicate) {
+			return reject(userFieldTaken(c, duplicate.Field))
+		}
+		logging.Errorf("Error creating user: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create user",
 		})
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+
+	"gochat/config"
+	"gochat/database/memory"
+	"gochat/models"
+	"gochat/validation"
+)
+
+// newAuthApp serves the registration and login endpoints of a handler
+// backed by an empty in-memory store
+func newAuthApp(t *testing.T) (*fiber.App, *memory.Store) {
+	t.Helper()
+
+	store := memory.NewStore()
+	handler := NewUserHandler(store, store)
+
+	app := fiber.New()
+	app.Post("/register", handler.Register)
+	app.Post("/login", handler.Login)
+	return app, store
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantError  string
+		wantFields map[string]string // Rejected field and error code
+	}{
+		{
+			name:     "valid",
+			body:     `{"username":"carol","email":"Carol@Example.com","password":"secret123"}`,
+			wantCode: fiber.StatusCreated,
+		},
+		{
+			name:      "malformed body",
+			body:      `{"username":`,
+			wantCode:  fiber.StatusBadRequest,
+			wantError: "Invalid request data",
+		},
+		{
+			name:       "missing email",
+			body:       `{"username":"carol","password":"secret123"}`,
+			wantCode:   fiber.StatusBadRequest,
+			wantError:  "Validation failed",
+			wantFields: map[string]string{"email": validation.CodeRequired},
+		},
+		{
+			name:       "short password",
+			body:       `{"username":"carol","email":"carol@example.com","password":"12345"}`,
+			wantCode:   fiber.StatusBadRequest,
+			wantError:  "Validation failed",
+			wantFields: map[string]string{"password": validation.CodeTooShort},
+		},
+		{
+			name:       "every field invalid",
+			body:       `{"username":"c","email":"Carol <carol@example.com>","password":""}`,
+			wantCode:   fiber.StatusBadRequest,
+			wantError:  "Validation failed",
+			wantFields: map[string]string{"username": validation.CodeTooShort, "email": validation.CodeInvalid, "password": validation.CodeRequired},
+		},
+		{
+			name:       "username with spaces",
+			body:       `{"username":"carol smith","email":"carol@example.com","password":"secret123"}`,
+			wantCode:   fiber.StatusBadRequest,
+			wantError:  "Validation failed",
+			wantFields: map[string]string{"username": validation.CodeInvalid},
+		},
+		{
+			name:       "email without domain",
+			body:       `{"username":"carol","email":"carol","password":"secret123"}`,
+			wantCode:   fiber.StatusBadRequest,
+			wantError:  "Validation failed",
+			wantFields: map[string]string{"email": validation.CodeInvalid},
+		},
+		{
+			name:       "taken username",
+			body:       `{"username":"alice","email":"other@example.com","password":"secret123"}`,
+			wantCode:   fiber.StatusConflict,
+			wantError:  "Username already exists",
+			wantFields: map[string]string{"username": validation.CodeTaken},
+		},
+		{
+			name:       "taken username in other case",
+			body:       `{"username":"Alice","email":"other@example.com","password":"secret123"}`,
+			wantCode:   fiber.StatusConflict,
+			wantError:  "Username already exists",
+			wantFields: map[string]string{"username": validation.CodeTaken},
+		},
+		{
+			name:       "taken email",
+			body:       `{"username":"carol","email":"alice@example.com","password":"secret123"}`,
+			wantCode:   fiber.StatusConflict,
+			wantError:  "Email already exists",
+			wantFields: map[string]string{"email": validation.CodeTaken},
+		},
+		{
+			name:       "taken email in other case",
+			body:       `{"username":"carol","email":"Alice@EXAMPLE.com","password":"secret123"}`,
+			wantCode:   fiber.StatusConflict,
+			wantError:  "Email already exists",
+			wantFields: map[string]string{"email": validation.CodeTaken},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, store := newAuthApp(t)
+			if code, _ := postJSON(t, app, "/register", `{"username":"alice","email":"alice@example.com","password":"secret123"}`); code != fiber.StatusCreated {
+				t.Fatalf("registering alice: status %d", code)
+			}
+
+			code, body := postJSON(t, app, "/register", tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %v)", code, tt.wantCode, body)
+			}
+			if tt.wantError != "" {
+				if body["error"] != tt.wantError {
+					t.Errorf("error = %v, want %q", body["error"], tt.wantError)
+				}
+				if fields := fieldCodes(body); !reflect.DeepEqual(fields, tt.wantFields) {
+					t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+				}
+				return
+			}
+
+			// The user is stored with a hashed password, which is never returned
+			if _, ok := body["password"]; ok {
+				t.Error("response contains the password")
+			}
+			user, err := store.GetUserByUsername("carol")
+			if err != nil {
+				t.Fatalf("GetUserByUsername: %v", err)
+			}
+			if body["id"] != float64(user.ID) || user.Email != "carol@example.com" || user.Status != "offline" {
+				t.Errorf("stored user = %+v, response %v", user, body)
+			}
+			if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("secret123")) != nil {
+				t.Error("stored password is not a bcrypt hash of the password")
+			}
+		})
+	}
+}
+
+// racingStore hides existing users from lookups, as if they were created
+// by a concurrent request after the handler checked for them
+type racingStore struct {
+	*memory.Store
+}
+
+func (s racingStore) GetUserByUsername(string) (*models.User, error) { return nil, sql.ErrNoRows }
+func (s racingStore) GetUserByEmail(string) (*models.User, error)    { return nil, sql.ErrNoRows }
+
+// A username or email claimed between the checks and the insert is reported
+// like one found by the checks
+func TestRegisterRace(t *testing.T) {
+	store := memory.NewStore()
+	handler := NewUserHandler(racingStore{store}, store)
+	app := fiber.New()
+	app.Post("/register", handler.Register)
+
+	if code, _ := postJSON(t, app, "/register", `{"username":"alice","email":"alice@example.com","password":"secret123"}`); code != fiber.StatusCreated {
+		t.Fatalf("registering alice: status %d", code)
+	}
+
+	tests := []struct {
+		body       string
+		wantError  string
+		wantFields map[string]string
+	}{
+		{`{"username":"alice","email":"other@example.com","password":"secret123"}`, "Username already exists", map[string]string{"username": validation.CodeTaken}},
+		{`{"username":"ALICE","email":"other@example.com","password":"secret123"}`, "Username already exists", map[string]string{"username": validation.CodeTaken}},
+		{`{"username":"carol","email":"alice@example.com","password":"secret123"}`, "Email already exists", map[string]string{"email": validation.CodeTaken}},
+	}
+	for _, tt := range tests {
+		code, body := postJSON(t, app, "/register", tt.body)
+		if code != fiber.StatusConflict || body["error"] != tt.wantError {
+			t.Errorf("status = %d, body %v; want %d %q", code, body, fiber.StatusConflict, tt.wantError)
+		}
+		if fields := fieldCodes(body); !reflect.DeepEqual(fields, tt.wantFields) {
+			t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+		}
+	}
+}
+
+// fieldCodes maps the rejected fields of an error response to their codes,
+// or returns nil when there are none
+func fieldCodes(body map[string]interface{}) map[string]string {
+	fields, _ := body["fields"].([]interface{})
+	if len(fields) == 0 {
+		return nil
+	}
+
+	codes := make(map[string]string, len(fields))
+	for _, field := range fields {
+		if entry, ok := field.(map[string]interface{}); ok {
+			codes[fmt.Sprint(entry["field"])] = fmt.Sprint(entry["code"])
+		}
+	}
+	return codes
+}
+
+// Only rejected registrations count towards a lockout, so people sharing an
+// address can all sign up
+func TestRegisterLockout(t *testing.T) {
+	app, _ := newAuthApp(t)
+	limits := config.Default().RateLimit.Register
+
+	register := func(username string) int {
+		t.Helper()
+		code, _ := postJSON(t, app, "/register", fmt.Sprintf(`{"username":%q,"email":"%s@example.com","password":"secret123"}`, username, username))
+		return code
+	}
+
+	// Successful registrations from one address are never locked out
+	for i := 0; i < 2*limits.MaxPerIP; i++ {
+		if code := register(fmt.Sprintf("user%d", i)); code != fiber.StatusCreated {
+			t.Fatalf("registration %d: status %d, want %d", i+1, code, fiber.StatusCreated)
+		}
+	}
+
+	// Probing a taken username locks it out
+	for i := 0; i < limits.MaxPerUsername; i++ {
+		if code := register("user0"); code != fiber.StatusConflict {
+			t.Fatalf("probe %d: status %d, want %d", i+1, code, fiber.StatusConflict)
+		}
+	}
+	if code := register("USER0"); code != fiber.StatusTooManyRequests {
+		t.Errorf("probe after the limit: status %d, want %d", code, fiber.StatusTooManyRequests)
+	}
+
+	// Rejections under other usernames lock out the address
+	for i := limits.MaxPerUsername; i < limits.MaxPerIP; i++ {
+		if code := register("x"); code != fiber.StatusBadRequest {
+			t.Fatalf("invalid registration %d: status %d, want %d", i+1, code, fiber.StatusBadRequest)
+		}
+	}
+	if code := register("newcomer"); code != fiber.StatusTooManyRequests {
+		t.Errorf("registration from a locked-out address: status %d, want %d", code, fiber.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/validation"
+)
+
+// invalidFields responds with the field errors found in a request
+func invalidFields(c *fiber.Ctx, errs validation.Errors) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error":  "Validation failed",
+		"fields": errs,
+	})
+}
+
+// fieldTaken responds that the value of a unique field is already in use
+func fieldTaken(c *fiber.Ctx, field, message string) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":  message,
+		"fields": validation.Errors{validation.NewFieldError(field, validation.CodeTaken, "is already taken")},
+	})
+}
+
+// userFieldTaken responds that a username or email belongs to another user
+func userFieldTaken(c *fiber.Ctx, field string) error {
+	if field == "email" {
+		return fieldTaken(c, field, "Email already exists")
+	}
+	return fieldTaken(c, field, "Username already exists")
+}
+
+// fieldReserved responds that a field may not be set to or changed from a
+// reserved value
+func fieldReserved(c *fiber.Ctx, field, message string) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":  message,
+		"fields": validation.Errors{validation.NewFieldError(field, validation.CodeReserved, "is reserved")},
+	})
+}
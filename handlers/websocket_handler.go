@@ -1,93 +1,84 @@
 package handlers
 
 import (
-	"log"
-	"strconv"
+	"errors"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
-	"github.com/golang-jwt/jwt/v4"
 
 	"gochat/chat" // Replace with your GitHub username
+	"gochat/config"
+	"gochat/logging"
+	"gochat/validation"
 )
 
 // ChatHub is the global chat hub instance
 var ChatHub *chat.ChatHub
 
-// InitChatHub initializes the chat hub
-func InitChatHub(userRepo interface{}) {
-	// Type assertion to get the correct user repository type
-	userRepoTyped, ok := userRepo.(chat.UserRepository)
-	if !ok {
-		log.Fatalf("Invalid user repository type passed to InitChatHub")
-	}
-
-	ChatHub = chat.NewChatHub(userRepoTyped)
+// InitChatHub creates the chat hub over the given repositories and starts it
+func InitChatHub(userRepo chat.UserRepository, messageRepo chat.MessageRepository, roomRepo chat.RoomRepository, readRepo chat.ReadMarkerRepository, reactionRepo chat.ReactionRepository, attachmentRepo chat.AttachmentRepository, moderators []string, limits config.RateLimitConfig) {
+	ChatHub = chat.NewChatHub(userRepo, messageRepo, roomRepo, readRepo, reactionRepo, attachmentRepo)
+	ChatHub.SetModerators(moderators)
+	ChatHub.SetFrameLimits(limits.FrameRate, limits.FrameBurst, limits.FrameStrikes)
 	ChatHub.Run()
 }
 
 // WebSocketHandler handles WebSocket connections
 func WebSocketHandler(c *websocket.Conn) {
-	// Get user ID from locals
-	userID, ok := c.Locals("userID").(int64)
+	// Get the principal stored by RequireAuth
+	principal, ok := c.Locals(principalKey).(*Principal)
 	if !ok {
-		log.Println("Missing or invalid userID in WebSocket handler")
+		logging.Errorf("Missing or invalid principal in WebSocket handler")
 		return
 	}
 
 	// Hand over to the chat hub
-	ChatHub.HandleWebSocket(c, userID)
+	ChatHub.HandleWebSocket(c, principal.UserID, principal.SessionID)
 }
 
 // WebSocketMiddleware authenticates WebSocket connections
 func WebSocketMiddleware(c *fiber.Ctx) error {
 	// Check if it's a WebSocket upgrade request
-	if websocket.IsWebSocketUpgrade(c) {
-		// Get the token from query parameter
-		token := c.Query("token")
-		if token == "" {
-			return fiber.NewError(fiber.StatusUnauthorized, "No authentication token provided")
-		}
-
-		// Parse and validate the token
-		parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-			// Replace with your actual secret key
-			return []byte("your-secret-key"), nil
-		})
-
-		if err != nil || !parsedToken.Valid {
-			return fiber.NewError(fiber.StatusUnauthorized, "Invalid authentication token")
-		}
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.NewError(fiber.StatusUpgradeRequired, "WebSocket upgrade required")
+	}
 
-		// Extract user ID from claims
-		claims, ok := parsedToken.Claims.(jwt.MapClaims)
-		if !ok {
-			return fiber.NewError(fiber.StatusUnauthorized, "Invalid token claims")
-		}
+	// Refuse new connections while the server is shutting down
+	if ChatHub.IsShuttingDown() {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Server is shutting down")
+	}
 
-		// Convert user_id to int64
-		var userID int64
-		switch id := claims["user_id"].(type) {
-		case float64:
-			userID = int64(id)
-		case int64:
-			userID = id
-		case string:
-			var err error
-			userID, err = strconv.ParseInt(id, 10, 64)
-			if err != nil {
-				return fiber.NewError(fiber.StatusUnauthorized, "Invalid user ID in token")
-			}
-		default:
-			return fiber.NewError(fiber.StatusUnauthorized, "Invalid user ID in token")
-		}
+	// Browsers cannot set headers on WebSocket requests, so RequireAuth
+	// also accepts the token from the query string; it allows the upgrade
+	// once the token is valid
+	return RequireAuth(c)
+}
 
-		// Store user ID in locals for the WebSocket handler
-		c.Locals("userID", userID)
+// hubError writes the response for an error returned by a ChatHub method:
+// protocol errors map to a client error status, anything else is logged
+// and reported with the generic failure message
+func hubError(c *fiber.Ctx, err error, failure string) error {
+	var protocolErr *chat.ProtocolError
+	if !errors.As(err, &protocolErr) {
+		logging.Errorf("%s: %v", failure, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": failure,
+		})
+	}
 
-		// Allow the upgrade
-		return c.Next()
+	status := fiber.StatusBadRequest
+	switch protocolErr.Code {
+	case chat.ErrCodeForbidden:
+		status = fiber.StatusForbidden
+	case chat.ErrCodeNotFound:
+		status = fiber.StatusNotFound
 	}
 
-	return fiber.NewError(fiber.StatusUpgradeRequired, "WebSocket upgrade required")
+	response := fiber.Map{
+		"error": protocolErr.Message,
+	}
+	if protocolErr.Field != nil {
+		response["fields"] = validation.Errors{protocolErr.Field}
+	}
+	return c.Status(status).JSON(response)
 }
@@ -0,0 +1,89 @@
+// Package logging writes leveled log lines through the standard logger and
+// drops those below the configured level
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level orders log lines by severity
+type Level int32
+
+// Supported levels, from the most to the least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// levelNames are the names accepted by ParseLevel, indexed by Level
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the name of the level
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error"
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if name == levelName {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("log level must be %s, got %q", strings.Join(levelNames, ", "), name)
+}
+
+// minLevel is the least severe level that is written
+var minLevel atomic.Int32
+
+func init() {
+	SetLevel(LevelInfo)
+}
+
+// SetLevel drops log lines below level from then on
+func SetLevel(level Level) {
+	minLevel.Store(int32(level))
+}
+
+// Enabled reports whether lines at level are written
+func Enabled(level Level) bool {
+	return int32(level) >= minLevel.Load()
+}
+
+// Debugf logs details that are only useful when debugging
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, format, args...)
+}
+
+// Infof logs the normal progress of the server
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, format, args...)
+}
+
+// Warnf logs unusual events that the server handles
+func Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, format, args...)
+}
+
+// Errorf logs failures
+func Errorf(format string, args ...interface{}) {
+	logf(LevelError, format, args...)
+}
+
+// logf writes a line at level unless the level is disabled
+func logf(level Level, format string, args ...interface{}) {
+	if !Enabled(level) {
+		return
+	}
+	// Skip logf and its caller so flags such as log.Lshortfile point at the
+	// logging call
+	log.Output(3, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if parsed, err := ParseLevel(level.String()); err != nil || parsed != level {
+			t.Errorf("ParseLevel(%q) = %v, %v", level.String(), parsed, err)
+		}
+	}
+	for _, name := range []string{"", "INFO", "trace", "warning"} {
+		if _, err := ParseLevel(name); err == nil {
+			t.Errorf("ParseLevel(%q) succeeded", name)
+		}
+	}
+}
+
+// Lines below the level are dropped
+func TestSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+		SetLevel(LevelInfo)
+	})
+
+	SetLevel(LevelWarn)
+	Debugf("debug %d", 1)
+	Infof("info %d", 2)
+	Warnf("warn %d", 3)
+	Errorf("error %d", 4)
+
+	if got, want := strings.Fields(buf.String()), []string{"warn", "3", "error", "4"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("output = %q, want the warn and error lines", buf.String())
+	}
+}
@@ -1,75 +1,194 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/websocket/v2"
 
+	"gochat/config"
 	"gochat/database"
 	"gochat/handlers"
+	"gochat/logging"
 	"gochat/routes"
+	"gochat/storage"
 )
 
+// shutdownTimeout bounds how long a graceful shutdown may take
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Drop log lines below the configured level
+	logging.SetLevel(cfg.Level())
+
+	// Subcommands run instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrateCommand(cfg, os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("Migration failed: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+		return
+	}
+
+	// Exit with exitCode once every deferred cleanup below has run; log.Fatalf
+	// would skip them
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	// Connect to database
-	err := database.Connect("./chat.db")
+	db, err := database.Connect(cfg.DBDriver, cfg.DatabaseDSN(), cfg.AllowSearchFallback())
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	defer database.Close()
+	defer db.Close()
 
-	// Create a new Fiber app
-	app := fiber.New()
+	// Create a new Fiber app; the body limit leaves room for the multipart
+	// framing around the largest accepted upload
+	app := fiber.New(fiber.Config{
+		BodyLimit: int(cfg.Attachments.MaxSize) + 1<<20,
+	})
 
 	// Middleware
-	app.Use(logger.New())
-	app.Use(cors.New())
+	if cfg.AccessLog {
+		app.Use(logger.New())
+	}
+	app.Use(cors.New(cors.Config{
+		AllowOrigins: strings.Join(cfg.CORSOrigins, ","),
+	}))
 
-	// Create user repository
-	userRepo := database.NewUserRepository(database.DB)
+	// Create repositories
+	repos := database.NewRepositories(db)
+	userRepo := repos.Users
+	messageRepo := repos.Messages
+	roomRepo := repos.Rooms
+	sessionRepo := repos.Sessions
+	readMarkerRepo := repos.ReadMarkers
+	reactionRepo := repos.Reactions
+	attachmentRepo := repos.Attachments
+
+	// Blob store for uploaded files
+	blobStore, err := storage.NewLocalStorage(cfg.Attachments.Dir)
+	if err != nil {
+		log.Fatalf("Failed to open attachment storage: %v", err)
+	}
+
+	// Token settings and session revocation checks for authentication
+	handlers.InitAuth(cfg.JWT, sessionRepo)
 
 	// Initialize chat hub - this is the critical line that was missing
-	log.Println("Initializing chat hub...")
-	handlers.InitChatHub(userRepo)
-	log.Println("Chat hub initialized successfully")
+	logging.Infof("Initializing chat hub...")
+	handlers.InitChatHub(userRepo, messageRepo, roomRepo, readMarkerRepo, reactionRepo, attachmentRepo, cfg.Moderators, cfg.RateLimit)
+	logging.Infof("Chat hub initialized successfully")
 
 	// Create handlers
-	userHandler := handlers.NewUserHandler(userRepo)
+	userHandler := handlers.NewUserHandler(userRepo, sessionRepo)
+	userHandler.SetRateLimits(cfg.RateLimit)
+	userHandler.SetModerators(cfg.Moderators)
+	roomHandler := handlers.NewRoomHandler(roomRepo)
+	messageHandler := handlers.NewMessageHandler(messageRepo, roomRepo, userRepo, reactionRepo, attachmentRepo)
+	readMarkerHandler := handlers.NewReadMarkerHandler(readMarkerRepo)
+	searchHandler := handlers.NewSearchHandler(messageRepo)
+	attachmentHandler := handlers.NewAttachmentHandler(attachmentRepo, messageRepo, roomRepo, blobStore, cfg.Attachments.MaxSize)
 
 	// Setup routes
-	routes.SetupRoutes(app, userHandler)
+	routes.SetupRoutes(app, userHandler, roomHandler, messageHandler, readMarkerHandler, attachmentHandler, searchHandler)
 
 	// Basic test route
 	app.Get("/", func(c *fiber.Ctx) error {
-		return c.SendString("GoChat server is running! WebSocket endpoint: ws://localhost:8080/ws")
+		return c.SendString("GoChat server is running! WebSocket endpoint: /ws")
 	})
 
 	// Simple WebSocket test endpoint for debugging
 	app.Get("/ws-test", websocket.New(func(c *websocket.Conn) {
-		log.Println("Test WebSocket connected")
+		logging.Debugf("Test WebSocket connected")
 
 		// Simple echo for testing
 		for {
 			mt, msg, err := c.ReadMessage()
 			if err != nil {
-				log.Println("Read error:", err)
+				logging.Debugf("Read error: %v", err)
 				break
 			}
-			log.Printf("Received on test socket: %s", msg)
+			logging.Debugf("Received on test socket: %s", msg)
 
 			if err := c.WriteMessage(mt, msg); err != nil {
-				log.Println("Write error:", err)
+				logging.Debugf("Write error: %v", err)
 				break
 			}
 		}
 	}))
 
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Delete uploads that were never sent with a message. The sweeper
+	// queries the database, so it is stopped and waited for before the
+	// deferred db.Close runs.
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		attachmentHandler.ExpireUploads(ctx, cfg.Attachments.PendingTTL.Duration)
+	}()
+	defer func() {
+		stop()
+		<-sweeperDone
+	}()
+
 	// Start server
-	log.Println("Starting server on :8080")
-	if err := app.Listen(":8080"); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	serverErr := make(chan error, 1)
+	go func() {
+		logging.Infof("Starting server on %s", cfg.ListenAddr)
+		serverErr <- app.Listen(cfg.ListenAddr)
+	}()
+
+	// A server that stops on its own, such as when the address is taken,
+	// is cleaned up like one stopped by a signal
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			logging.Errorf("Failed to start server: %v", err)
+			exitCode = 1
+		}
+	case <-ctx.Done():
+	}
+
+	// Graceful shutdown: the hub refuses new sockets, flushes pending
+	// broadcasts and disconnects its clients before HTTP stops; the
+	// deferred db.Close runs last
+	logging.Infof("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := handlers.ChatHub.Shutdown(shutdownCtx); err != nil {
+		logging.Errorf("Chat hub shutdown: %v", err)
 	}
+
+	if err := app.ShutdownWithContext(shutdownCtx); err != nil {
+		logging.Errorf("Server shutdown: %v", err)
+	}
+
+	logging.Infof("Server stopped")
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gochat/config"
+	"gochat/database"
+)
+
+// runMigrateCommand implements "gochat migrate status" and "gochat migrate up",
+// writing its report to out
+func runMigrateCommand(cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) != 1 || (args[0] != "status" && args[0] != "up") {
+		return errors.New("usage: gochat migrate status|up")
+	}
+
+	db, err := database.Open(cfg.DBDriver, cfg.DatabaseDSN())
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	if args[0] == "up" {
+		applied, err := database.Migrate(db)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Applied %d migration(s)\n", applied)
+	}
+
+	return printMigrationStatus(db, out)
+}
+
+// printMigrationStatus writes a table of the migrations and whether they are applied
+func printMigrationStatus(db *database.DB, out io.Writer) error {
+	statuses, err := database.Status(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, status := range statuses {
+		state := "pending"
+		switch {
+		case !status.Known:
+			state = "unknown (applied by a newer server)"
+		case status.AppliedAt != nil:
+			state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, state)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gochat/config"
+	"gochat/database"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	cfg := config.Default()
+	cfg.DBPath = filepath.Join(t.TempDir(), "chat.db")
+
+	migrations, err := database.Migrations(database.DriverSQLite)
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	// run runs the subcommand and returns its report split into lines
+	run := func(args ...string) []string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := runMigrateCommand(cfg, args, &out); err != nil {
+			t.Fatalf("migrate %s: %v", strings.Join(args, " "), err)
+		}
+		return strings.Split(strings.TrimSpace(out.String()), "\n")
+	}
+
+	// countRows returns how many migration rows have the given state
+	countRows := func(lines []string, state string) int {
+		count := 0
+		for _, line := range lines {
+			if strings.Contains(line, state) {
+				count++
+			}
+		}
+		return count
+	}
+
+	// A fresh database has every migration pending
+	lines := run("status")
+	if header := strings.Join(strings.Fields(lines[0]), " "); header != "VERSION NAME STATUS" {
+		t.Errorf("header = %q, want the VERSION, NAME and STATUS columns", lines[0])
+	}
+	if got := countRows(lines, "pending"); got != len(migrations) {
+		t.Errorf("status listed %d pending migrations, want %d:\n%s", got, len(migrations), strings.Join(lines, "\n"))
+	}
+
+	// up applies them all and reports the new state
+	lines = run("up")
+	if want := fmt.Sprintf("Applied %d migration(s)", len(migrations)); lines[0] != want {
+		t.Errorf("first line = %q, want %q", lines[0], want)
+	}
+	if got := countRows(lines, "applied 2"); got != len(migrations) {
+		t.Errorf("up listed %d applied migrations, want %d:\n%s", got, len(migrations), strings.Join(lines, "\n"))
+	}
+
+	// Running up again has nothing left to do
+	if lines = run("up"); lines[0] != "Applied 0 migration(s)" {
+		t.Errorf("second up reported %q, want nothing applied", lines[0])
+	}
+
+	for _, args := range [][]string{nil, {"down"}, {"status", "up"}} {
+		if err := runMigrateCommand(cfg, args, &bytes.Buffer{}); err == nil {
+			t.Errorf("migrate %v succeeded, want a usage error", args)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -15,14 +16,149 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// DuplicateError reports that a user or room could not be stored because the
+// value of a unique field belongs to another one
+type DuplicateError struct {
+	Field string // "username" or "email" for users, "name" for rooms
+}
+
+// Error names the field whose value is taken
+func (e *DuplicateError) Error() string {
+	return e.Field + " already exists"
+}
+
+// UserFilter selects a page of users in the user directory
+type UserFilter struct {
+	UsernamePrefix string
+	Status         string // Empty matches every status
+	Limit          int
+	Offset         int
+}
+
 // Message represents a chat message
 type Message struct {
+	ID          int64           `json:"id"`
+	UserID      int64           `json:"user_id"`
+	Username    string          `json:"username,omitempty"`     // Populated from users when reading
+	RoomID      int64           `json:"room_id,omitempty"`      // Zero for the global channel
+	RecipientID int64           `json:"recipient_id,omitempty"` // Set for direct messages
+	ParentID    int64           `json:"parent_id,omitempty"`    // Set for thread replies
+	ReplyCount  int             `json:"reply_count,omitempty"`
+	LastReplyAt *time.Time      `json:"last_reply_at,omitempty"`
+	Content     string          `json:"content"`
+	CreatedAt   time.Time       `json:"created_at"`
+	EditedAt    *time.Time      `json:"edited_at,omitempty"`
+	DeletedAt   *time.Time      `json:"deleted_at,omitempty"`
+	Reactions   []ReactionCount `json:"reactions,omitempty"`   // Filled in by callers that need it
+	Attachments []*Attachment   `json:"attachments,omitempty"` // Filled in by callers that need it
+}
+
+// MembershipChecker reports whether a user belongs to a room
+type MembershipChecker interface {
+	IsMember(roomID, userID int64) (bool, error)
+}
+
+// VisibleTo reports whether a user may read the message: global messages
+// are public, room messages need membership and direct messages a
+// participant
+func (m *Message) VisibleTo(userID int64, rooms MembershipChecker) (bool, error) {
+	switch {
+	case m.RecipientID != 0:
+		return m.UserID == userID || m.RecipientID == userID, nil
+	case m.RoomID != 0:
+		return rooms.IsMember(m.RoomID, userID)
+	default:
+		return true, nil
+	}
+}
+
+// Attachment is an uploaded file, stored as a blob and optionally linked to
+// the message it was sent with
+type Attachment struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"user_id"`
+	MessageID    int64     `json:"message_id,omitempty"` // Zero until the attachment is sent
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"` // Set for images
+	Height       int       `json:"height,omitempty"`
+	StorageKey   string    `json:"-"`
+	ThumbnailKey string    `json:"-"` // Empty when no thumbnail was generated
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// SetURLs fills in the authenticated download URLs of the attachment
+func (a *Attachment) SetURLs() {
+	a.URL = fmt.Sprintf("/api/attachments/%d", a.ID)
+	if a.ThumbnailKey != "" {
+		a.ThumbnailURL = a.URL + "/thumbnail"
+	}
+}
+
+// ReactionCount is the number of users who reacted to a message with an emoji
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// MessageSearch selects the messages returned by a full-text search. Only
+// messages visible to UserID are searched.
+type MessageSearch struct {
+	UserID   int64
+	Query    string
+	RoomID   int64     // Zero searches every conversation
+	AuthorID int64     // Zero matches any author
+	From     time.Time // Inclusive; compared in UTC like the stored times
+	To       time.Time // Exclusive
+	Limit    int
+	Offset   int
+}
+
+// SearchResult is a message matching a search with the matched terms
+// highlighted in Snippet
+type SearchResult struct {
+	Message *Message
+	Snippet string
+}
+
+// Revision actions recorded in the message audit trail
+const (
+	RevisionEdit   = "edit"
+	RevisionDelete = "delete"
+)
+
+// MessageRevision is the content a message had before it was edited or deleted
+type MessageRevision struct {
 	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
+	MessageID int64     `json:"message_id"`
+	Action    string    `json:"action"` // RevisionEdit or RevisionDelete
 	Content   string    `json:"content"`
+	ChangedBy int64     `json:"changed_by"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// ReadMarker records the last message a user has read in a conversation.
+// RoomID and PeerID are both zero for the global channel; PeerID is the other
+// user of a direct conversation.
+type ReadMarker struct {
+	UserID            int64     `json:"user_id"`
+	RoomID            int64     `json:"room_id,omitempty"`
+	PeerID            int64     `json:"peer_id,omitempty"`
+	LastReadMessageID int64     `json:"last_read_message_id"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// UnreadCount is the number of unread messages in one conversation
+type UnreadCount struct {
+	RoomID            int64 `json:"room_id,omitempty"`
+	PeerID            int64 `json:"peer_id,omitempty"`
+	Count             int   `json:"count"`
+	LastReadMessageID int64 `json:"last_read_message_id"`
+}
+
 // Room represents a chat room
 type Room struct {
 	ID        int64     `json:"id"`
@@ -36,3 +172,22 @@ type RoomMember struct {
 	RoomID   int64     `json:"room_id"`
 	JoinedAt time.Time `json:"joined_at"`
 }
+
+// Session represents one login of a user; every refresh token and access
+// token issued for that login belongs to it
+type Session struct {
+	ID        string     `json:"id"`
+	UserID    int64      `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// RefreshToken represents a single-use refresh token, stored by hash only
+type RefreshToken struct {
+	TokenHash string     `json:"-"`
+	SessionID string     `json:"session_id"`
+	UserID    int64      `json:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
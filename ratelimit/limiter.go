@@ -0,0 +1,91 @@
+// Package ratelimit limits how often callers identified by a key, such as a
+// user ID or an IP address, may act
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle entries are dropped
+const sweepInterval = time.Minute
+
+// Limiter keeps a token bucket per key. Each bucket holds up to burst
+// tokens, starts full and refills at rate tokens per second.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+}
+
+// bucket is the state of one key's token bucket
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewLimiter creates a limiter allowing rate actions per second per key,
+// with bursts of up to burst actions
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of key and reports whether one was
+// available
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.tokensAt(b, now)
+	b.updated = now
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// tokensAt returns the tokens in b at time now
+func (l *Limiter) tokensAt(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.updated).Seconds()*l.rate
+	if tokens > l.burst {
+		return l.burst
+	}
+	return tokens
+}
+
+// sweep drops the buckets that have refilled completely, since a new bucket
+// starts full anyway; the caller holds the lock
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if l.tokensAt(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout counts the attempts made against each key and locks a key out
+// once it reaches the maximum number of attempts within a window
+type Lockout struct {
+	maxAttempts int
+	window      time.Duration
+	duration    time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*attempts
+	lastSweep time.Time
+
+	// now returns the current time; replaced in tests
+	now func() time.Time
+}
+
+// attempts is the state of one key
+type attempts struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// NewLockout creates a lockout that locks a key for duration once
+// maxAttempts attempts are recorded against it within window
+func NewLockout(maxAttempts int, window, duration time.Duration) *Lockout {
+	return &Lockout{
+		maxAttempts: maxAttempts,
+		window:      window,
+		duration:    duration,
+		entries:     make(map[string]*attempts),
+		now:         time.Now,
+	}
+}
+
+// Locked reports whether key is locked out and, if so, for how much longer
+func (l *Lockout) Locked(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok {
+		return 0, false
+	}
+
+	remaining := entry.lockedUntil.Sub(l.now())
+	if remaining <= 0 {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// Record counts an attempt against key, locking it out when the attempt
+// reaches the maximum. Attempts made while locked out are not counted.
+func (l *Lockout) Record(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &attempts{}
+		l.entries[key] = entry
+	}
+	if now.Before(entry.lockedUntil) {
+		return
+	}
+
+	// Start a new window once the previous one has passed
+	if now.Sub(entry.windowStart) >= l.window {
+		entry.count = 0
+		entry.windowStart = now
+	}
+
+	entry.count++
+	if entry.count >= l.maxAttempts {
+		entry.count = 0
+		entry.windowStart = time.Time{}
+		entry.lockedUntil = now.Add(l.duration)
+	}
+}
+
+// Reset forgets the attempts made against key and lifts its lockout
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+// sweep drops the keys that are neither locked out nor in an open window;
+// the caller holds the lock
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, entry := range l.entries {
+		if !now.Before(entry.lockedUntil) && now.Sub(entry.windowStart) >= l.window {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a manually advanced time source
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newClock() *clock {
+	return &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestLimiter(t *testing.T) {
+	clk := newClock()
+	limiter := NewLimiter(2, 3)
+	limiter.now = clk.Now
+
+	// A new key gets a full burst, then runs dry
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("alice") {
+			t.Fatalf("action %d of the burst was refused", i+1)
+		}
+	}
+	if limiter.Allow("alice") {
+		t.Fatal("action beyond the burst was allowed")
+	}
+
+	// Keys do not share buckets
+	if !limiter.Allow("bob") {
+		t.Error("bob was limited by alice's actions")
+	}
+
+	// Tokens refill at the rate, up to the burst
+	clk.Advance(500 * time.Millisecond)
+	if !limiter.Allow("alice") || limiter.Allow("alice") {
+		t.Error("half a second should refill exactly one token")
+	}
+	clk.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("alice") {
+			t.Fatalf("action %d after refilling was refused", i+1)
+		}
+	}
+	if limiter.Allow("alice") {
+		t.Error("bucket refilled beyond the burst")
+	}
+
+	// Full buckets are swept
+	clk.Advance(time.Hour)
+	limiter.Allow("carol")
+	if _, ok := limiter.buckets["bob"]; ok {
+		t.Error("idle bucket was not swept")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	clk := newClock()
+	lockout := NewLockout(3, time.Minute, 10*time.Minute)
+	lockout.now = clk.Now
+
+	// Attempts spread over more than a window never lock a key out
+	for i := 0; i < 5; i++ {
+		lockout.Record("alice")
+		clk.Advance(40 * time.Second)
+	}
+	if _, locked := lockout.Locked("alice"); locked {
+		t.Fatal("attempts in separate windows locked the key out")
+	}
+
+	// The maximum number of attempts within a window does
+	for i := 0; i < 3; i++ {
+		lockout.Record("bob")
+	}
+	remaining, locked := lockout.Locked("bob")
+	if !locked || remaining != 10*time.Minute {
+		t.Fatalf("Locked = %v, %v; want locked for 10m", remaining, locked)
+	}
+	if _, locked := lockout.Locked("carol"); locked {
+		t.Error("an unrelated key is locked out")
+	}
+
+	// Attempts while locked out do not extend the lockout
+	clk.Advance(5 * time.Minute)
+	lockout.Record("bob")
+	if remaining, _ := lockout.Locked("bob"); remaining != 5*time.Minute {
+		t.Errorf("remaining = %v, want 5m", remaining)
+	}
+
+	// The lockout expires, and a fresh window starts afterwards
+	clk.Advance(5 * time.Minute)
+	if _, locked := lockout.Locked("bob"); locked {
+		t.Fatal("lockout did not expire")
+	}
+	lockout.Record("bob")
+	if _, locked := lockout.Locked("bob"); locked {
+		t.Error("one attempt after the lockout locked the key out again")
+	}
+
+	// Reset lifts a lockout
+	for i := 0; i < 3; i++ {
+		lockout.Record("carol")
+	}
+	lockout.Reset("carol")
+	if _, locked := lockout.Locked("carol"); locked {
+		t.Error("Reset did not lift the lockout")
+	}
+}
@@ -0,0 +1,405 @@
+package routes_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+
+	"gochat/config"
+	"gochat/database"
+	"gochat/handlers"
+	"gochat/routes"
+	"gochat/storage"
+)
+
+// frameTimeout bounds how long a test waits for an expected frame, and
+// quietPeriod how long it watches for a frame that must not arrive
+const (
+	frameTimeout = 5 * time.Second
+	quietPeriod  = 200 * time.Millisecond
+)
+
+// testServer is the full application listening on an ephemeral port with a
+// temporary SQLite database. The handlers keep the chat hub and the token
+// settings in package variables, so tests using it must not run in parallel.
+type testServer struct {
+	t       *testing.T
+	baseURL string
+	wsURL   string
+}
+
+// startServer boots the application the way main does and stops it, along
+// with the hub and the database, when the test ends
+func startServer(t *testing.T) *testServer {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Attachments.Dir = filepath.Join(dir, "attachments")
+
+	db, err := database.Connect(database.DriverSQLite, filepath.Join(dir, "chat.db"), cfg.AllowSearchFallback())
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	repos := database.NewRepositories(db)
+
+	blobStore, err := storage.NewLocalStorage(cfg.Attachments.Dir)
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+
+	handlers.InitAuth(cfg.JWT, repos.Sessions)
+	handlers.InitChatHub(repos.Users, repos.Messages, repos.Rooms, repos.ReadMarkers, repos.Reactions, repos.Attachments, cfg.Moderators, cfg.RateLimit)
+
+	userHandler := handlers.NewUserHandler(repos.Users, repos.Sessions)
+	userHandler.SetModerators(cfg.Moderators)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	routes.SetupRoutes(app,
+		userHandler,
+		handlers.NewRoomHandler(repos.Rooms),
+		handlers.NewMessageHandler(repos.Messages, repos.Rooms, repos.Users, repos.Reactions, repos.Attachments),
+		handlers.NewReadMarkerHandler(repos.ReadMarkers),
+		handlers.NewAttachmentHandler(repos.Attachments, repos.Messages, repos.Rooms, blobStore, cfg.Attachments.MaxSize),
+		handlers.NewSearchHandler(repos.Messages),
+	)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go app.Listener(listener)
+
+	// Shut down in the same order as main: the hub, then HTTP, then the
+	// database
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), frameTimeout)
+		defer cancel()
+		if err := handlers.ChatHub.Shutdown(ctx); err != nil {
+			t.Errorf("hub shutdown: %v", err)
+		}
+		if err := app.ShutdownWithContext(ctx); err != nil {
+			t.Errorf("server shutdown: %v", err)
+		}
+		db.Close()
+	})
+
+	addr := listener.Addr().String()
+	return &testServer{
+		t:       t,
+		baseURL: "http://" + addr,
+		wsURL:   "ws://" + addr + "/ws",
+	}
+}
+
+// testUser is a registered user with a valid access token
+type testUser struct {
+	ID       int64
+	Username string
+	Token    string
+}
+
+// signUp registers a user through the REST API and logs them in
+func (s *testServer) signUp(username string) *testUser {
+	s.t.Helper()
+
+	credentials := map[string]string{
+		"username": username,
+		"email":    username + "@example.com",
+		"password": "secret123",
+	}
+	if code, body := s.postJSON("/api/auth/register", "", credentials); code != fiber.StatusCreated {
+		s.t.Fatalf("register %s: status %d (body %v)", username, code, body)
+	}
+
+	code, body := s.postJSON("/api/auth/login", "", credentials)
+	if code != fiber.StatusOK {
+		s.t.Fatalf("login %s: status %d (body %v)", username, code, body)
+	}
+
+	user := &testUser{Username: username}
+	user.Token, _ = body["token"].(string)
+	if id, ok := body["user_id"].(float64); ok {
+		user.ID = int64(id)
+	}
+	if user.Token == "" || user.ID == 0 {
+		s.t.Fatalf("login %s: unexpected response %v", username, body)
+	}
+	return user
+}
+
+// postJSON posts body as JSON to path, authenticated with token unless it
+// is empty, and decodes the JSON response, if any
+func (s *testServer) postJSON(path, token string, body interface{}) (int, map[string]interface{}) {
+	s.t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		s.t.Fatalf("marshal request: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		s.t.Fatalf("build request: %v", err)
+	}
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("read response: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			s.t.Fatalf("decode response %q: %v", data, err)
+		}
+	}
+	return resp.StatusCode, decoded
+}
+
+// dial opens a WebSocket to the server with the given token, which is left
+// out when empty
+func (s *testServer) dial(token string) (*websocket.Conn, *http.Response, error) {
+	target := s.wsURL
+	if token != "" {
+		target += "?token=" + url.QueryEscape(token)
+	}
+
+	dialer := websocket.Dialer{HandshakeTimeout: frameTimeout}
+	return dialer.Dial(target, nil)
+}
+
+// connect opens a WebSocket for user and waits for the initial online users
+// frame, which is kept in the client. The connection is closed when the test
+// ends.
+func (s *testServer) connect(user *testUser) *wsClient {
+	s.t.Helper()
+
+	conn, resp, err := s.dial(user.Token)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		s.t.Fatalf("dial as %s: %v (status %d)", user.Username, err, status)
+	}
+
+	client := s.attach(user, conn)
+	client.online = client.expectFrame("online_users", nil)
+	return client
+}
+
+// attach starts decoding the frames of an open connection for user. The
+// connection is closed when the test ends.
+func (s *testServer) attach(user *testUser, conn *websocket.Conn) *wsClient {
+	client := &wsClient{
+		t:      s.t,
+		user:   user,
+		conn:   conn,
+		frames: make(chan frame, 256),
+	}
+	go client.readLoop()
+	s.t.Cleanup(client.close)
+
+	return client
+}
+
+// frame is a decoded server frame
+type frame map[string]interface{}
+
+// wsClient is a real WebSocket connection whose frames are decoded in the
+// background and consumed by the expect helpers
+type wsClient struct {
+	t         *testing.T
+	user      *testUser
+	conn      *websocket.Conn
+	frames    chan frame
+	online    frame // The online users frame received on connecting
+	readErr   error // Why the connection ended; set before frames is closed
+	closeOnce sync.Once
+}
+
+// readLoop decodes frames until the connection fails, then closes frames
+func (c *wsClient) readLoop() {
+	defer close(c.frames)
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+
+		var f frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			f = frame{"type": "undecodable", "raw": string(data)}
+		}
+		c.frames <- f
+	}
+}
+
+// send writes v as a JSON text frame
+func (c *wsClient) send(v interface{}) {
+	c.t.Helper()
+
+	if err := c.conn.WriteJSON(v); err != nil {
+		c.t.Fatalf("%s: write frame: %v", c.user.Username, err)
+	}
+}
+
+// sendRaw writes data as a text frame as is
+func (c *wsClient) sendRaw(data []byte) {
+	c.t.Helper()
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		c.t.Fatalf("%s: write frame: %v", c.user.Username, err)
+	}
+}
+
+// close sends a close frame and closes the connection; it is safe to call
+// more than once
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		deadline := time.Now().Add(time.Second)
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+		c.conn.Close()
+	})
+}
+
+// expectFrame returns the next frame of the given type accepted by match,
+// or of that type at all when match is nil, skipping any other frames
+func (c *wsClient) expectFrame(frameType string, match func(frame) bool) frame {
+	c.t.Helper()
+
+	deadline := time.After(frameTimeout)
+	for {
+		select {
+		case f, ok := <-c.frames:
+			if !ok {
+				c.t.Fatalf("%s: connection closed while waiting for a %q frame", c.user.Username, frameType)
+			}
+			if f["type"] == frameType && (match == nil || match(f)) {
+				return f
+			}
+		case <-deadline:
+			c.t.Fatalf("%s: no matching %q frame within %v", c.user.Username, frameType, frameTimeout)
+			return nil
+		}
+	}
+}
+
+// expectNoFrame fails if a frame of the given type arrives within the quiet
+// period; other frames are discarded
+func (c *wsClient) expectNoFrame(frameType string) {
+	c.t.Helper()
+
+	deadline := time.After(quietPeriod)
+	for {
+		select {
+		case f, ok := <-c.frames:
+			if !ok {
+				return
+			}
+			if f["type"] == frameType {
+				c.t.Fatalf("%s: unexpected frame %v", c.user.Username, f)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// expectUserJoined waits for the announcement of username joining
+func (c *wsClient) expectUserJoined(username string) frame {
+	c.t.Helper()
+	return c.expectFrame("user_joined", fromUser(username))
+}
+
+// expectUserLeft waits for the announcement of username leaving
+func (c *wsClient) expectUserLeft(username string) frame {
+	c.t.Helper()
+	return c.expectFrame("user_left", fromUser(username))
+}
+
+// expectMessage waits for a chat message from username with the given
+// content
+func (c *wsClient) expectMessage(frameType, username, content string) frame {
+	c.t.Helper()
+	return c.expectFrame(frameType, func(f frame) bool {
+		return f["username"] == username && f["content"] == content
+	})
+}
+
+// expectAck waits for the acknowledgement of the frame sent with requestID
+func (c *wsClient) expectAck(requestID string) frame {
+	c.t.Helper()
+	return c.expectFrame("ack", func(f frame) bool { return f["request_id"] == requestID })
+}
+
+// expectClosed waits for the server to close the connection and returns
+// the error that ended it, a *websocket.CloseError when a close frame was
+// received
+func (c *wsClient) expectClosed() error {
+	c.t.Helper()
+
+	deadline := time.After(frameTimeout)
+	for {
+		select {
+		case _, ok := <-c.frames:
+			if !ok {
+				return c.readErr
+			}
+		case <-deadline:
+			c.t.Fatalf("%s: connection still open after %v", c.user.Username, frameTimeout)
+			return nil
+		}
+	}
+}
+
+// fromUser matches frames about username
+func fromUser(username string) func(frame) bool {
+	return func(f frame) bool { return f["username"] == username }
+}
+
+// onlineUsernames returns the sorted usernames listed in an online users
+// frame
+func onlineUsernames(f frame) []string {
+	entries, _ := f["online_users"].([]interface{})
+	usernames := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if user, ok := entry.(map[string]interface{}); ok {
+			usernames = append(usernames, fmt.Sprint(user["username"]))
+		}
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// sameUsernames reports whether got holds exactly the usernames in want
+func sameUsernames(got []string, want ...string) bool {
+	sorted := append([]string(nil), want...)
+	sort.Strings(sorted)
+	return strings.Join(got, ",") == strings.Join(sorted, ",")
+}
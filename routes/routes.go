@@ -8,7 +8,7 @@ import (
 )
 
 // SetupRoutes configures all application routes
-func SetupRoutes(app *fiber.App, userHandler *handlers.UserHandler) {
+func SetupRoutes(app *fiber.App, userHandler *handlers.UserHandler, roomHandler *handlers.RoomHandler, messageHandler *handlers.MessageHandler, readMarkerHandler *handlers.ReadMarkerHandler, attachmentHandler *handlers.AttachmentHandler, searchHandler *handlers.SearchHandler) {
 	// API group
 	api := app.Group("/api")
 
@@ -16,12 +16,49 @@ func SetupRoutes(app *fiber.App, userHandler *handlers.UserHandler) {
 	auth := api.Group("/auth")
 	auth.Post("/register", userHandler.Register)
 	auth.Post("/login", userHandler.Login)
+	auth.Post("/refresh", userHandler.Refresh)
+	auth.Post("/logout", handlers.RequireAuth, userHandler.Logout)
 
-	// User routes (to be implemented later)
-	users := api.Group("/users")
-	users.Get("/", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"message": "List users endpoint - to be implemented"})
-	})
+	// User directory routes
+	users := api.Group("/users", handlers.RequireAuth)
+	users.Get("/", userHandler.ListUsers)
+	users.Get("/me", userHandler.GetMe)
+	users.Patch("/me", userHandler.UpdateMe)
+	users.Get("/:id", userHandler.GetUser)
+
+	// Room routes
+	rooms := api.Group("/rooms", handlers.RequireAuth)
+	rooms.Get("/", roomHandler.ListRooms)
+	rooms.Post("/", roomHandler.CreateRoom)
+	rooms.Post("/:id/join", roomHandler.JoinRoom)
+	rooms.Post("/:id/leave", roomHandler.LeaveRoom)
+	rooms.Get("/:id/messages", messageHandler.RoomHistory)
+
+	// Message routes; the root lists the global channel's history
+	messages := api.Group("/messages", handlers.RequireAuth)
+	messages.Get("/", messageHandler.GlobalHistory)
+	messages.Patch("/:id", messageHandler.EditMessage)
+	messages.Delete("/:id", messageHandler.DeleteMessage)
+	messages.Get("/:id/revisions", messageHandler.MessageRevisions)
+	messages.Get("/:id/thread", messageHandler.Thread)
+
+	// Direct message history with another user
+	api.Get("/direct-messages/:userId", handlers.RequireAuth, messageHandler.DirectHistory)
+
+	// Attachment routes; downloads also accept the token as a query
+	// parameter so they can be used as image sources
+	attachments := api.Group("/attachments", handlers.RequireAuth)
+	attachments.Post("/", attachmentHandler.Upload)
+	attachments.Get("/:id", attachmentHandler.Download)
+	attachments.Get("/:id/thumbnail", attachmentHandler.Thumbnail)
+
+	// Search routes
+	api.Get("/search/messages", handlers.RequireAuth, searchHandler.SearchMessages)
+
+	// Read marker routes
+	readMarkers := api.Group("/read-markers", handlers.RequireAuth)
+	readMarkers.Post("/", readMarkerHandler.MarkRead)
+	readMarkers.Get("/unread", readMarkerHandler.UnreadCounts)
 
 	// WebSocket configuration
 	// First add the middleware for authentication
@@ -0,0 +1,226 @@
+package routes_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+
+	"gochat/chat"
+	"gochat/handlers"
+	"gochat/validation"
+)
+
+func TestWebSocketPresence(t *testing.T) {
+	server := startServer(t)
+	alice, bob := server.signUp("alice"), server.signUp("bob")
+
+	// Alice is announced to herself once she is online
+	aliceConn := server.connect(alice)
+	aliceConn.expectUserJoined("alice")
+
+	// Bob sees everyone online, and alice sees him join
+	bobConn := server.connect(bob)
+	if got := onlineUsernames(bobConn.online); !sameUsernames(got, "alice", "bob") {
+		t.Errorf("online users = %v, want alice and bob", got)
+	}
+	aliceConn.expectUserJoined("bob")
+
+	// Closing bob's only connection takes him offline
+	bobConn.close()
+	aliceConn.expectUserLeft("bob")
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	server := startServer(t)
+	alice, bob, carol := server.signUp("alice"), server.signUp("bob"), server.signUp("carol")
+	aliceConn, bobConn, carolConn := server.connect(alice), server.connect(bob), server.connect(carol)
+
+	// A global message is acknowledged and reaches everyone
+	aliceConn.send(map[string]interface{}{
+		"type":       "message",
+		"request_id": "m1",
+		"payload":    map[string]interface{}{"content": "hello everyone"},
+	})
+	if ack := aliceConn.expectAck("m1"); ack["id"] == nil {
+		t.Errorf("ack %v carries no message ID", ack)
+	}
+	for _, conn := range []*wsClient{aliceConn, bobConn, carolConn} {
+		conn.expectMessage("message", "alice", "hello everyone")
+	}
+
+	// A direct message only reaches its recipient
+	aliceConn.send(map[string]interface{}{
+		"type":       "direct_message",
+		"request_id": "m2",
+		"payload":    map[string]interface{}{"recipient_id": bob.ID, "content": "hi bob"},
+	})
+	aliceConn.expectAck("m2")
+	bobConn.expectMessage("direct_message", "alice", "hi bob")
+	carolConn.expectNoFrame("direct_message")
+
+	// Rejected frames are answered with an error
+	carolConn.send(map[string]interface{}{"type": "shout", "request_id": "m3"})
+	if reply := carolConn.expectFrame("error", nil); reply["request_id"] != "m3" || reply["code"] != chat.ErrCodeUnknownType {
+		t.Errorf("error frame = %v", reply)
+	}
+}
+
+func TestWebSocketAuthentication(t *testing.T) {
+	server := startServer(t)
+	alice := server.signUp("alice")
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"invalid token", "not-a-token", http.StatusUnauthorized},
+		{"tampered token", alice.Token + "x", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := server.dial(tt.token)
+			if err == nil {
+				conn.Close()
+				t.Fatal("upgrade succeeded")
+			}
+			if resp == nil || resp.StatusCode != tt.wantStatus {
+				t.Errorf("response = %v, want status %d", resp, tt.wantStatus)
+			}
+		})
+	}
+
+	// Logging out revokes the session, which closes its sockets
+	aliceConn := server.connect(alice)
+	if code, body := server.postJSON("/api/auth/logout", alice.Token, nil); code != http.StatusNoContent {
+		t.Fatalf("logout: status %d (body %v)", code, body)
+	}
+	aliceConn.expectClosed()
+}
+
+func TestWebSocketRateLimit(t *testing.T) {
+	server := startServer(t)
+	alice := server.signUp("alice")
+	aliceConn := server.connect(alice)
+
+	// A flood is answered with rate limit errors and then disconnected
+	for i := 0; i < 200; i++ {
+		aliceConn.send(map[string]interface{}{
+			"type":       "typing_start",
+			"request_id": fmt.Sprintf("t%d", i),
+			"payload":    map[string]interface{}{},
+		})
+	}
+	reply := aliceConn.expectFrame("error", func(f frame) bool { return f["code"] == chat.ErrCodeRateLimited })
+	if reply["request_id"] == "" {
+		t.Errorf("rate limit error %v does not name the dropped frame", reply)
+	}
+	aliceConn.expectClosed()
+}
+
+func TestWebSocketFrameValidation(t *testing.T) {
+	server := startServer(t)
+	alice := server.signUp("alice")
+	aliceConn := server.connect(alice)
+
+	// Text frames must be valid UTF-8
+	aliceConn.sendRaw([]byte("{\"type\":\"message\",\"payload\":{\"content\":\"\xff\"}}"))
+	if reply := aliceConn.expectFrame("error", nil); reply["code"] != chat.ErrCodeBadFrame {
+		t.Errorf("error frame = %v, want %q", reply, chat.ErrCodeBadFrame)
+	}
+
+	// Content over the limit is rejected with the offending field
+	aliceConn.send(map[string]interface{}{
+		"type":       "message",
+		"request_id": "long",
+		"payload":    map[string]interface{}{"content": strings.Repeat("a", validation.MaxContentLength+1)},
+	})
+	reply := aliceConn.expectFrame("error", nil)
+	if fields, _ := reply["fields"].([]interface{}); reply["request_id"] != "long" || len(fields) != 1 {
+		t.Errorf("error frame = %v, want the content field rejected", reply)
+	}
+
+	// A frame over the read limit closes the connection; the server may do
+	// so before the whole frame is written
+	oversized := `{"type":"message","payload":{"content":"` + strings.Repeat("a", 1<<20) + `"}}`
+	aliceConn.conn.WriteMessage(websocket.TextMessage, []byte(oversized))
+	aliceConn.expectClosed()
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	server := startServer(t)
+	alice := server.signUp("alice")
+	aliceConn := server.connect(alice)
+
+	ctx, cancel := context.WithTimeout(context.Background(), frameTimeout)
+	defer cancel()
+	if err := handlers.ChatHub.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	// Connected clients are told why before the connection goes away
+	aliceConn.expectFrame("server_shutdown", nil)
+	if err := aliceConn.expectClosed(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("connection ended with %v, want close code %d", err, websocket.CloseGoingAway)
+	}
+
+	// New upgrades are refused while the server finishes shutting down
+	conn, resp, err := server.dial(alice.Token)
+	if err == nil {
+		conn.Close()
+		t.Fatal("upgrade succeeded after shutdown")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("response = %v, want status %d", resp, http.StatusServiceUnavailable)
+	}
+}
+
+func TestWebSocketShutdownWhileConnecting(t *testing.T) {
+	server := startServer(t)
+	alice := server.signUp("alice")
+
+	// Dial while the hub shuts down, so that some connections are upgraded
+	// before Shutdown but only reach the hub after it has started
+	const dials = 20
+	type result struct {
+		conn *websocket.Conn
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, dials)
+	for i := 0; i < dials; i++ {
+		go func() {
+			conn, resp, err := server.dial(alice.Token)
+			results <- result{conn, resp, err}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), frameTimeout)
+	defer cancel()
+	if err := handlers.ChatHub.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	// Every connection that got through is closed as going away rather
+	// than left open; the others were refused
+	for i := 0; i < dials; i++ {
+		r := <-results
+		if r.err != nil {
+			if r.resp == nil || r.resp.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("dial failed with %v (response %v), want status %d", r.err, r.resp, http.StatusServiceUnavailable)
+			}
+			continue
+		}
+
+		conn := server.attach(alice, r.conn)
+		if err := conn.expectClosed(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("connection ended with %v, want close code %d", err, websocket.CloseGoingAway)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when a blob does not exist
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned for keys a store cannot hold
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Storage stores uploaded blobs under opaque keys
+type Storage interface {
+	// Put stores the content of r under key, replacing any existing blob
+	Put(key string, r io.Reader) error
+
+	// Open returns a reader for the blob stored under key
+	Open(key string) (io.ReadCloser, error)
+
+	// Delete removes the blob stored under key; missing blobs are ignored
+	Delete(key string) error
+}
@@ -0,0 +1,176 @@
+// Package validation checks user input and describes the problems found as
+// field-level errors that can be returned to clients
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on user input
+const (
+	MaxContentLength  = 4000 // Characters in a message
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+	MaxEmailLength    = 254
+	MinPasswordLength = 6
+	MaxPasswordLength = 72 // Bytes; bcrypt rejects longer passwords
+	MaxRoomNameLength = 64 // Characters
+)
+
+// Codes of field errors
+const (
+	CodeRequired = "required"
+	CodeTooShort = "too_short"
+	CodeTooLong  = "too_long"
+	CodeInvalid  = "invalid"
+	CodeTaken    = "taken"
+	CodeReserved = "reserved"
+)
+
+// FieldError describes why the value of one field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error returns the field name followed by the message
+func (e *FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// NewFieldError creates a field error with a formatted message
+func NewFieldError(field, code, format string, args ...interface{}) *FieldError {
+	return &FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Errors collects the field errors found in a request
+type Errors []*FieldError
+
+// Add appends err unless it is nil
+func (errs *Errors) Add(err *FieldError) {
+	if err != nil {
+		*errs = append(*errs, err)
+	}
+}
+
+// Error joins the messages of all field errors
+func (errs Errors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Text checks that a value is valid UTF-8 without control characters other
+// than tabs and line breaks
+func Text(field, value string) *FieldError {
+	if !utf8.ValidString(value) {
+		return NewFieldError(field, CodeInvalid, "must be valid UTF-8")
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return NewFieldError(field, CodeInvalid, "must not contain control characters")
+		}
+	}
+	return nil
+}
+
+// Content checks the text of a message. Blank content is only accepted when
+// it is not required, such as for a message carrying attachments.
+func Content(field, content string, required bool) *FieldError {
+	if required && strings.TrimSpace(content) == "" {
+		return NewFieldError(field, CodeRequired, "is required")
+	}
+	if utf8.RuneCountInString(content) > MaxContentLength {
+		return NewFieldError(field, CodeTooLong, "must be at most %d characters", MaxContentLength)
+	}
+	return Text(field, content)
+}
+
+// Username checks that a username is 3 to 32 ASCII letters, digits, dots,
+// hyphens or underscores, starting with a letter or digit
+func Username(username string) *FieldError {
+	const field = "username"
+
+	switch {
+	case username == "":
+		return NewFieldError(field, CodeRequired, "is required")
+	case len(username) < MinUsernameLength:
+		return NewFieldError(field, CodeTooShort, "must be at least %d characters", MinUsernameLength)
+	case len(username) > MaxUsernameLength:
+		return NewFieldError(field, CodeTooLong, "must be at most %d characters", MaxUsernameLength)
+	}
+
+	for i, r := range username {
+		alphanumeric := r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r))
+		if i == 0 && !alphanumeric {
+			return NewFieldError(field, CodeInvalid, "must start with a letter or digit")
+		}
+		if !alphanumeric && r != '.' && r != '-' && r != '_' {
+			return NewFieldError(field, CodeInvalid, "may only contain letters, digits, dots, hyphens and underscores")
+		}
+	}
+	return nil
+}
+
+// Email checks that an email is a bare RFC 5322 address, without a display
+// name or angle brackets
+func Email(email string) *FieldError {
+	const field = "email"
+
+	if email == "" {
+		return NewFieldError(field, CodeRequired, "is required")
+	}
+	if len(email) > MaxEmailLength {
+		return NewFieldError(field, CodeTooLong, "must be at most %d characters", MaxEmailLength)
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return NewFieldError(field, CodeInvalid, "must be a valid email address")
+	}
+	return nil
+}
+
+// Password checks the length of a password
+func Password(password string) *FieldError {
+	const field = "password"
+
+	switch {
+	case password == "":
+		return NewFieldError(field, CodeRequired, "is required")
+	case len(password) < MinPasswordLength:
+		return NewFieldError(field, CodeTooShort, "must be at least %d characters", MinPasswordLength)
+	case len(password) > MaxPasswordLength:
+		return NewFieldError(field, CodeTooLong, "must be at most %d bytes", MaxPasswordLength)
+	}
+	return nil
+}
+
+// RoomName checks that a room name is 1 to 64 printable characters; spaces
+// are the only whitespace allowed
+func RoomName(name string) *FieldError {
+	const field = "name"
+
+	switch {
+	case name == "":
+		return NewFieldError(field, CodeRequired, "is required")
+	case !utf8.ValidString(name):
+		return NewFieldError(field, CodeInvalid, "must be valid UTF-8")
+	case utf8.RuneCountInString(name) > MaxRoomNameLength:
+		return NewFieldError(field, CodeTooLong, "must be at most %d characters", MaxRoomNameLength)
+	}
+
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return NewFieldError(field, CodeInvalid, "may only contain printable characters and spaces")
+		}
+	}
+	return nil
+}
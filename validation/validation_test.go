@@ -0,0 +1,170 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+// code returns the code of err, or "" when err is nil
+func code(err *FieldError) string {
+	if err == nil {
+		return ""
+	}
+	return err.Code
+}
+
+func TestContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		required bool
+		wantCode string
+	}{
+		{"text", "hello, world", true, ""},
+		{"multiline", "line one\r\nline two\tindented", true, ""},
+		{"unicode", "héllo 👋 世界", true, ""},
+		{"empty", "", true, CodeRequired},
+		{"blank", " \n\t ", true, CodeRequired},
+		{"empty but optional", "", false, ""},
+		{"at the limit", strings.Repeat("é", MaxContentLength), true, ""},
+		{"over the limit", strings.Repeat("a", MaxContentLength+1), true, CodeTooLong},
+		{"invalid UTF-8", "hello \xff", true, CodeInvalid},
+		{"NUL", "hello\x00", true, CodeInvalid},
+		{"escape", "\x1b[31mred", true, CodeInvalid},
+		{"C1 control", "hello\u0085", true, CodeInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Content("content", tt.content, tt.required)
+			if got := code(err); got != tt.wantCode {
+				t.Errorf("code = %q, want %q (%v)", got, tt.wantCode, err)
+			}
+			if err != nil && err.Field != "content" {
+				t.Errorf("field = %q, want content", err.Field)
+			}
+		})
+	}
+}
+
+func TestUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		wantCode string
+	}{
+		{"alice", ""},
+		{"Alice_99", ""},
+		{"j.doe-smith", ""},
+		{"abc", ""},
+		{strings.Repeat("a", MaxUsernameLength), ""},
+		{"", CodeRequired},
+		{"ab", CodeTooShort},
+		{strings.Repeat("a", MaxUsernameLength+1), CodeTooLong},
+		{"_alice", CodeInvalid},
+		{"alice smith", CodeInvalid},
+		{"alice@home", CodeInvalid},
+		{"alicé", CodeInvalid},
+		{"аlice", CodeInvalid}, // Cyrillic a
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			if got := code(Username(tt.username)); got != tt.wantCode {
+				t.Errorf("code = %q, want %q", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		email    string
+		wantCode string
+	}{
+		{"alice@example.com", ""},
+		{"alice.smith+chat@mail.example.org", ""},
+		{"alice@localhost", ""},
+		{"", CodeRequired},
+		{"alice", CodeInvalid},
+		{"alice@", CodeInvalid},
+		{"@example.com", CodeInvalid},
+		{"alice@@example.com", CodeInvalid},
+		{"Alice <alice@example.com>", CodeInvalid},
+		{"<alice@example.com>", CodeInvalid},
+		{" alice@example.com", CodeInvalid},
+		{strings.Repeat("a", MaxEmailLength) + "@example.com", CodeTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			if got := code(Email(tt.email)); got != tt.wantCode {
+				t.Errorf("code = %q, want %q", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantCode string
+	}{
+		{"valid", "secret123", ""},
+		{"empty", "", CodeRequired},
+		{"short", "12345", CodeTooShort},
+		{"longest", strings.Repeat("a", MaxPasswordLength), ""},
+		{"too long", strings.Repeat("a", MaxPasswordLength+1), CodeTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := code(Password(tt.password)); got != tt.wantCode {
+				t.Errorf("code = %q, want %q", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRoomName(t *testing.T) {
+	tests := []struct {
+		name     string
+		roomName string
+		wantCode string
+	}{
+		{"word", "general", ""},
+		{"spaces and punctuation", "Team #3 (ops)", ""},
+		{"unicode", "café 世界 👋", ""},
+		{"longest", strings.Repeat("é", MaxRoomNameLength), ""},
+		{"empty", "", CodeRequired},
+		{"too long", strings.Repeat("a", MaxRoomNameLength+1), CodeTooLong},
+		{"invalid UTF-8", "room \xff", CodeInvalid},
+		{"line break", "room\nname", CodeInvalid},
+		{"tab", "room\tname", CodeInvalid},
+		{"escape", "\x1b[31mred", CodeInvalid},
+		{"zero-width space", "room\u200bname", CodeInvalid},
+		{"right-to-left override", "room\u202ename", CodeInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := code(RoomName(tt.roomName)); got != tt.wantCode {
+				t.Errorf("code = %q, want %q", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	errs.Add(nil)
+	errs.Add(Username("ab"))
+	errs.Add(Password(""))
+
+	if len(errs) != 2 {
+		t.Fatalf("len = %d, want 2", len(errs))
+	}
+	if want := "username must be at least 3 characters; password is required"; errs.Error() != want {
+		t.Errorf("Error() = %q, want %q", errs.Error(), want)
+	}
+}